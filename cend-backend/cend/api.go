@@ -0,0 +1,173 @@
+package main
+
+import (
+	"cend/database"
+	"cend/database/collection"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// route is an endpoint of the API. The route table both registers the
+// endpoints with the router and describes them in the OpenAPI document, so
+// that the two cannot drift apart.
+type route struct {
+	method  string
+	path    string
+	summary string
+	handler func(*database.DB) http.HandlerFunc
+
+	query    any // struct whose fields, by JSON name, are the query parameters
+	request  any // JSON request body, or the mediaType of another body
+	response any // JSON success body, or the mediaType of another body; nil if none
+	status   int // success status, if not 200
+	// successor is the endpoint that replaces a deprecated one.
+	successor string
+
+	role  role      // role an API key needs in the route's collection
+	class rateClass // rate limit the route shares, if not its role's
+	// scope is the collection of a route whose path names none: docs, for
+	// the endpoints that predate collections, unless set.
+	scope string
+}
+
+// collection returns the collection a request to the route acts on.
+func (rt route) collection(r *http.Request) string {
+	if name, ok := mux.Vars(r)["collection"]; ok {
+		return name
+	}
+	return rt.fixedScope()
+}
+
+// fixedScope returns the collection of a route whose path names none.
+func (rt route) fixedScope() string {
+	if rt.scope != "" {
+		return rt.scope
+	}
+	return "docs"
+}
+
+// mediaType describes a request or response body that is not JSON.
+type mediaType string
+
+const documentsPath = "/collections/{collection}/documents"
+
+// apiRoutes returns the route table.
+func apiRoutes() []route {
+	return []route{
+		{method: http.MethodGet, path: "/", summary: "Describe the API",
+			handler: func(*database.DB) http.HandlerFunc { return rootHandler }, response: map[string]string{}},
+		{method: http.MethodGet, path: "/openapi.json", summary: "Get this OpenAPI document",
+			handler: func(*database.DB) http.HandlerFunc { return openAPIHandler }, response: map[string]any{}},
+
+		{method: http.MethodPut, path: "/collections/{collection}", summary: "Create a collection",
+			handler: createCollectionHandler, response: CollectionResult{}, status: http.StatusCreated, role: roleAdmin},
+		{method: http.MethodDelete, path: "/collections/{collection}", summary: "Delete a collection",
+			handler: deleteCollectionHandler, status: http.StatusNoContent, role: roleAdmin},
+		{method: http.MethodGet, path: documentsPath, summary: "List documents",
+			handler: listDocumentsHandler, query: ListRequest{}, response: ListResult{}, role: roleReader},
+		{method: http.MethodPost, path: documentsPath, summary: "Add a document",
+			handler: createDocumentHandler, request: AddRequest{}, response: QueryResult{}, status: http.StatusCreated, role: roleCurator},
+		{method: http.MethodGet, path: documentsPath + "/{id}", summary: "Get a document",
+			handler: getDocumentHandler, response: QueryResult{}, role: roleReader},
+		{method: http.MethodPut, path: documentsPath + "/{id}", summary: "Replace a document",
+			handler: putDocumentHandler, request: AddRequest{}, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodPatch, path: documentsPath + "/{id}", summary: "Change part of a document",
+			handler: patchDocumentHandler, request: DocumentPatch{}, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodDelete, path: documentsPath + "/{id}", summary: "Delete a document",
+			handler: deleteDocumentHandler, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodPost, path: documentsPath + "/{id}/restore", summary: "Restore a deleted document",
+			handler: restoreDocumentHandler, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodGet, path: "/collections/{collection}/search", summary: "Search documents",
+			handler: searchDocumentsHandler, query: SearchPageRequest{}, response: SearchPageResult{}, role: roleReader, class: classSearch},
+
+		{method: http.MethodPost, path: "/search", summary: "Search documents",
+			handler: searchHandler, request: SearchRequest{}, response: []SearchResult{}, successor: "/collections/docs/search", role: roleReader, class: classSearch},
+		{method: http.MethodPost, path: "/search/page", summary: "Search documents a page at a time",
+			handler: searchPageHandler, request: SearchPageRequest{}, response: SearchPageResult{}, successor: "/collections/docs/search", role: roleReader, class: classSearch},
+		{method: http.MethodPost, path: "/add", summary: "Add a document",
+			handler: addHandler, request: AddRequest{}, response: QueryResult{}, status: http.StatusCreated, successor: "/collections/docs/documents", role: roleCurator},
+		{method: http.MethodPost, path: "/update", summary: "Update a document",
+			handler: updateHandler, request: UpdateRequest{}, response: QueryResult{}, successor: "/collections/docs/documents/{id}", role: roleCurator},
+		{method: http.MethodPost, path: "/delete", summary: "Delete a document",
+			handler: removeHandler, request: DeleteRequest{}, response: QueryResult{}, successor: "/collections/docs/documents/{id}", role: roleCurator},
+		{method: http.MethodPost, path: "/query", summary: "Get the documents in a range of IDs",
+			handler: queryHandler, request: QueryRequest{}, response: []QueryResult{}, successor: "/collections/docs/documents", role: roleReader},
+		{method: http.MethodPost, path: "/get", summary: "Get documents by ID",
+			handler: getHandler, request: GetRequest{}, response: []QueryResult{}, successor: "/collections/docs/documents/{id}", role: roleReader},
+		{method: http.MethodPost, path: "/list", summary: "List documents",
+			handler: listHandler, request: ListRequest{}, response: ListResult{}, successor: "/collections/docs/documents", role: roleReader},
+
+		{method: http.MethodPost, path: "/search/batch", summary: "Run many searches at once",
+			handler: searchBatchHandler, request: BatchSearchRequest{}, response: BatchSearchResult{}, role: roleReader, class: classSearch},
+		{method: http.MethodPost, path: "/merge", summary: "Merge a document into another",
+			handler: mergeHandler, request: MergeRequest{}, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodPost, path: "/split", summary: "Split a new document off another",
+			handler: splitHandler, request: SplitRequest{}, response: QueryResult{}, role: roleCurator},
+		{method: http.MethodPost, path: "/history", summary: "Read the history of changes",
+			handler: historyHandler, request: HistoryRequest{}, response: HistoryResult{}, role: roleReader},
+		{method: http.MethodPost, path: "/undo", summary: "Undo a merge or split",
+			handler: undoHandler, request: UndoRequest{}, response: collection.Change{}, role: roleCurator},
+		{method: http.MethodPost, path: "/undelete", summary: "Restore a deleted document",
+			handler: undeleteHandler, request: UndeleteRequest{}, response: QueryResult{}, successor: "/collections/docs/documents/{id}/restore", role: roleCurator},
+		{method: http.MethodPost, path: "/batch", summary: "Apply operations all or nothing",
+			handler: batchHandler, request: BatchRequest{}, response: BatchResult{}, role: roleCurator},
+		{method: http.MethodPost, path: "/suggest", summary: "Complete a prefix",
+			handler: suggestHandler, request: SuggestRequest{}, response: []SuggestResult{}, role: roleReader, class: classSearch},
+		{method: http.MethodGet, path: "/snapshot", summary: "Download a snapshot of every collection",
+			handler: snapshotHandler, response: mediaType("application/gzip"), role: roleAdmin, scope: allCollections},
+		{method: http.MethodPost, path: "/restore", summary: "Restore a snapshot",
+			handler: restoreHandler, query: struct {
+				Rename []string `json:"rename"`
+			}{}, request: mediaType("application/gzip"), response: RestoreResult{}, role: roleAdmin, scope: allCollections},
+
+		{method: http.MethodGet, path: "/reconcile/{collection}", summary: "Reconcile values against a collection",
+			handler: reconcileHandler, query: reconcileParams{}, response: ReconcileManifest{}, role: roleReader, class: classSearch},
+		{method: http.MethodPost, path: "/reconcile/{collection}", summary: "Reconcile values against a collection",
+			handler: reconcileHandler, query: reconcileParams{}, response: ReconcileManifest{}, role: roleReader, class: classSearch},
+		{method: http.MethodGet, path: "/reconcile/{collection}/preview", summary: "Preview a reconciliation candidate",
+			handler: reconcilePreviewHandler, query: struct {
+				Id int `json:"id"`
+			}{}, response: mediaType("text/html"), role: roleReader},
+		{method: http.MethodGet, path: "/reconcile/{collection}/suggest/{kind}", summary: "Complete an entity, property or type",
+			handler: reconcileSuggestHandler, query: struct {
+				Prefix string `json:"prefix"`
+				Cursor int    `json:"cursor"`
+			}{}, response: ReconcileSuggestResult{}, role: roleReader, class: classSearch},
+	}
+}
+
+// reconcileParams are the parameters of /reconcile/{collection}: without
+// queries or query, it answers with the service manifest.
+type reconcileParams struct {
+	Queries  string `json:"queries"`
+	Query    string `json:"query"`
+	Callback string `json:"callback"`
+}
+
+// newRouter routes every endpoint of the API to its handler. With keys,
+// every endpoint that is not public needs an API key granting its role;
+// with limiter, each client's requests to each class of endpoint are
+// limited.
+func newRouter(db *database.DB, keys *apiKeys, limiter *rateLimiter) *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	routes := make(map[string]route)
+	for _, rt := range apiRoutes() {
+		handler := rt.handler(db)
+		if rt.successor != "" {
+			handler = deprecated(rt.successor, handler)
+		}
+		name := rt.method + " " + rt.path
+		routes[name] = rt
+		r.HandleFunc(rt.path, handler).Methods(rt.method).Name(name)
+	}
+	if limiter != nil {
+		r.Use(limiter.middleware(routes, keys))
+	}
+	if keys != nil {
+		r.Use(keys.middleware(routes))
+	}
+	return r
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// role is what an API key may do in a collection. Each role may do all
+// that the roles before it may.
+type role int
+
+const (
+	// rolePublic marks the endpoints that need no key.
+	rolePublic role = iota
+	// roleReader may search and read documents.
+	roleReader
+	// roleCurator may also add, change and delete documents.
+	roleCurator
+	// roleAdmin may also create collections, and snapshot and restore
+	// them.
+	roleAdmin
+)
+
+var roleNames = []string{"public", "reader", "curator", "admin"}
+
+func (ro role) String() string {
+	return roleNames[ro]
+}
+
+func (ro *role) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for i, n := range roleNames[roleReader:] {
+		if name == n {
+			*ro = roleReader + role(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown role %q; expected reader, curator or admin", name)
+}
+
+// allCollections is the collection of a grant that applies to every
+// collection, and the collection of the endpoints that act on all of them.
+const allCollections = "*"
+
+// apiKey is a key clients authenticate with. Name identifies the key's
+// holder, and is recorded as the actor of the changes they make. Roles
+// grants a role in each collection, or with "*", in every collection.
+type apiKey struct {
+	Name  string          `json:"name"`
+	Key   string          `json:"key"`
+	Roles map[string]role `json:"roles"`
+}
+
+// role returns the role the key grants in a collection.
+func (k *apiKey) role(collection string) role {
+	granted := k.Roles[allCollections]
+	if collection != allCollections {
+		granted = max(granted, k.Roles[collection])
+	}
+	return granted
+}
+
+// apiKeys authenticates requests by their API key.
+type apiKeys struct {
+	// byHash indexes the keys by the SHA-256 of their secret, so that
+	// looking one up does not leak the secret through its timing.
+	byHash map[[sha256.Size]byte]*apiKey
+}
+
+func newAPIKeys(keys []apiKey) (*apiKeys, error) {
+	a := &apiKeys{byHash: make(map[[sha256.Size]byte]*apiKey, len(keys))}
+	for i := range keys {
+		k := &keys[i]
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("API key %d needs a name and a key", i)
+		}
+		hash := sha256.Sum256([]byte(k.Key))
+		if _, exists := a.byHash[hash]; exists {
+			return nil, fmt.Errorf("API key %s is not unique", k.Name)
+		}
+		a.byHash[hash] = k
+	}
+	return a, nil
+}
+
+// loadAPIKeys reads the API keys from the JSON file at CEND_API_KEYS_FILE,
+// or else the JSON in CEND_API_KEYS: a list of keys such as
+//
+//	[{"name": "ingest", "key": "...", "roles": {"docs": "curator", "*": "reader"}}]
+//
+// With neither set, it returns nil, and every endpoint is public.
+func loadAPIKeys() (*apiKeys, error) {
+	var data []byte
+	if path, exists := os.LookupEnv("CEND_API_KEYS_FILE"); exists {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	} else if keys, exists := os.LookupEnv("CEND_API_KEYS"); exists {
+		data = []byte(keys)
+	} else {
+		return nil, nil
+	}
+	var keys []apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys: %w", err)
+	}
+	return newAPIKeys(keys)
+}
+
+// authenticate returns the key a request presents, as a bearer token or
+// in X-API-Key, and whether it presented one at all.
+func (a *apiKeys) authenticate(r *http.Request) (*apiKey, bool) {
+	secret, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !bearer {
+		secret = r.Header.Get("X-API-Key")
+	}
+	if secret == "" {
+		return nil, false
+	}
+	return a.byHash[sha256.Sum256([]byte(secret))], true
+}
+
+type apiKeyContextKey struct{}
+
+// requestKey returns the API key a request was authorized with, if any.
+func requestKey(r *http.Request) *apiKey {
+	k, _ := r.Context().Value(apiKeyContextKey{}).(*apiKey)
+	return k
+}
+
+// middleware authorizes each request for the route it matched: the key it
+// presents must grant the route's role in the route's collection. routes
+// are the routes of the router by name.
+func (a *apiKeys) middleware(routes map[string]route) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt := routes[mux.CurrentRoute(r).GetName()]
+			if rt.role == rolePublic {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			k, presented := a.authenticate(r)
+			if k == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cend"`)
+				if presented {
+					writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid API key", "The key is not one this server knows.")
+				} else {
+					writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "API key required", "Send the key as a bearer token or in X-API-Key.")
+				}
+				return
+			}
+			collection := rt.collection(r)
+			if k.role(collection) < rt.role {
+				writeError(w, http.StatusForbidden, "FORBIDDEN", "Not allowed", fmt.Sprintf("Key %s needs the %s role in collection %s.", k.Name, rt.role, collection))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+		})
+	}
+}
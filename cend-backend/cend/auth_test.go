@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeys are a reader and a curator of docs, a curator of another
+// collection only, and an admin of every collection.
+func testKeys(t *testing.T) *apiKeys {
+	t.Helper()
+	keys, err := newAPIKeys([]apiKey{
+		{Name: "reader", Key: "reader-key", Roles: map[string]role{"docs": roleReader}},
+		{Name: "curator", Key: "curator-key", Roles: map[string]role{"docs": roleCurator}},
+		{Name: "other-curator", Key: "other-key", Roles: map[string]role{"other": roleCurator}},
+		{Name: "admin", Key: "admin-key", Roles: map[string]role{"*": roleAdmin}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating keys: %v", err)
+	}
+	return keys
+}
+
+// endpointRoles is the role each endpoint needs, written out apart from
+// the route table so that a change to either is a deliberate one.
+var endpointRoles = map[string]role{
+	"GET /":             rolePublic,
+	"GET /openapi.json": rolePublic,
+
+	"PUT /collections/{collection}":    roleAdmin,
+	"DELETE /collections/{collection}": roleAdmin,
+	"GET /snapshot":                    roleAdmin,
+	"POST /restore":                    roleAdmin,
+
+	"GET /collections/{collection}/documents":               roleReader,
+	"GET /collections/{collection}/documents/{id}":          roleReader,
+	"GET /collections/{collection}/search":                  roleReader,
+	"POST /search":                                          roleReader,
+	"POST /search/page":                                     roleReader,
+	"POST /search/batch":                                    roleReader,
+	"POST /query":                                           roleReader,
+	"POST /get":                                             roleReader,
+	"POST /list":                                            roleReader,
+	"POST /history":                                         roleReader,
+	"POST /suggest":                                         roleReader,
+	"GET /reconcile/{collection}":                           roleReader,
+	"POST /reconcile/{collection}":                          roleReader,
+	"GET /reconcile/{collection}/preview":                   roleReader,
+	"GET /reconcile/{collection}/suggest/{kind}":            roleReader,
+	"POST /collections/{collection}/documents":              roleCurator,
+	"PUT /collections/{collection}/documents/{id}":          roleCurator,
+	"PATCH /collections/{collection}/documents/{id}":        roleCurator,
+	"DELETE /collections/{collection}/documents/{id}":       roleCurator,
+	"POST /collections/{collection}/documents/{id}/restore": roleCurator,
+	"POST /add":                                             roleCurator,
+	"POST /update":                                          roleCurator,
+	"POST /delete":                                          roleCurator,
+	"POST /merge":                                           roleCurator,
+	"POST /split":                                           roleCurator,
+	"POST /undo":                                            roleCurator,
+	"POST /undelete":                                        roleCurator,
+	"POST /batch":                                           roleCurator,
+}
+
+func TestEveryRoleAndEndpoint(t *testing.T) {
+	credentials := []struct {
+		header string
+		known  bool
+		role   role // role in docs
+	}{
+		{"", false, rolePublic},
+		{"Authorization: Bearer wrong-key", false, rolePublic},
+		{"Authorization: Bearer reader-key", true, roleReader},
+		{"X-API-Key: curator-key", true, roleCurator},
+		{"Authorization: Bearer other-key", true, rolePublic},
+		{"Authorization: Bearer admin-key", true, roleAdmin},
+	}
+
+	routes := apiRoutes()
+	if len(routes) != len(endpointRoles) {
+		t.Errorf("Expected the %d endpoints of endpointRoles, got %d routes", len(endpointRoles), len(routes))
+	}
+	for _, rt := range routes {
+		name := rt.method + " " + rt.path
+		want, ok := endpointRoles[name]
+		if !ok {
+			t.Errorf("%s: add the role it needs to endpointRoles", name)
+			continue
+		}
+		if rt.role != want {
+			t.Errorf("%s: expected role %s, got %s", name, want, rt.role)
+		}
+		path := strings.NewReplacer("{collection}", "docs", "{id}", "1", "{kind}", "entity").Replace(rt.path)
+		for _, c := range credentials {
+			t.Run(name+" "+c.header, func(t *testing.T) {
+				rec := serveRequest(newTestRouterWith(t, testKeys(t), nil), rt.method, path, "{}", c.header)
+				switch {
+				case want == rolePublic || c.role >= want:
+					if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+						t.Errorf("Expected to be allowed, got %d %s", rec.Code, rec.Body)
+					}
+				case !c.known:
+					expectError(t, rec, http.StatusUnauthorized, "UNAUTHORIZED")
+					if rec.Header().Get("WWW-Authenticate") == "" {
+						t.Errorf("Expected a WWW-Authenticate challenge")
+					}
+				default:
+					expectError(t, rec, http.StatusForbidden, "FORBIDDEN")
+				}
+			})
+		}
+	}
+}
+
+func TestRoleScopedToCollection(t *testing.T) {
+	router := newTestRouterWith(t, testKeys(t), nil)
+
+	expectError(t, serveRequest(router, http.MethodPut, "/collections/other", "", "Authorization: Bearer other-key"), http.StatusForbidden, "FORBIDDEN")
+	if rec := serveRequest(router, http.MethodPut, "/collections/other", "", "Authorization: Bearer admin-key"); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the admin to create a collection, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := serveRequest(router, http.MethodPut, "/collections/other", "", "Authorization: Bearer admin-key"); rec.Code != http.StatusOK {
+		t.Errorf("Expected creating an existing collection to succeed, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serveRequest(router, http.MethodPut, "/collections/.hidden", "", "Authorization: Bearer admin-key"), http.StatusBadRequest, "VALIDATION_ERROR")
+
+	if rec := serveRequest(router, http.MethodPost, "/collections/other/documents", `{"document": "Apple"}`, "Authorization: Bearer other-key"); rec.Code != http.StatusCreated {
+		t.Errorf("Expected a curator of other to add to it, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serveRequest(router, http.MethodPost, "/collections/other/documents", `{"document": "Pear"}`, "Authorization: Bearer curator-key"), http.StatusForbidden, "FORBIDDEN")
+}
+
+func TestDeleteCollection(t *testing.T) {
+	router := newTestRouterWith(t, testKeys(t), nil)
+
+	expectError(t, serveRequest(router, http.MethodDelete, "/collections/docs", "", "Authorization: Bearer curator-key"), http.StatusForbidden, "FORBIDDEN")
+	if rec := serveRequest(router, http.MethodGet, "/collections/docs/documents/1", "", "Authorization: Bearer curator-key"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the collection to outlive a forbidden delete, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec := serveRequest(router, http.MethodDelete, "/collections/docs", "", "Authorization: Bearer admin-key")
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Fatalf("Expected the admin to delete the collection, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serveRequest(router, http.MethodGet, "/collections/docs/documents/1", "", "Authorization: Bearer admin-key"), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serveRequest(router, http.MethodDelete, "/collections/docs", "", "Authorization: Bearer admin-key"), http.StatusNotFound, "NOT_FOUND")
+
+	// The name can be taken again, by an empty collection.
+	if rec := serveRequest(router, http.MethodPut, "/collections/docs", "", "Authorization: Bearer admin-key"); rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"documents":0`) {
+		t.Errorf("Expected an empty collection to be created, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestActorIsKeyName(t *testing.T) {
+	router := newTestRouterWith(t, testKeys(t), nil)
+
+	req := "Authorization: Bearer curator-key"
+	if rec := serveRequest(router, http.MethodPost, "/add", `{"document": "Apple"}`, req); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the document to be added, got %d %s", rec.Code, rec.Body)
+	}
+	rec := serveRequest(router, http.MethodPost, "/history", `{"id": 3}`, req)
+	var history HistoryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil || len(history.Changes) != 1 || history.Changes[0].Actor != "curator" {
+		t.Errorf("Expected the change to be made by curator, got %s", rec.Body)
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	t.Setenv("CEND_API_KEYS", `[{"name": "ingest", "key": "secret", "roles": {"docs": "curator", "*": "reader"}}]`)
+	keys, err := loadAPIKeys()
+	if err != nil {
+		t.Fatalf("Error loading keys from the environment: %v", err)
+	}
+	k := keys.byHash[sha256.Sum256([]byte("secret"))]
+	if k == nil || k.role("docs") != roleCurator || k.role("other") != roleReader || k.role(allCollections) != roleReader {
+		t.Errorf("Expected a curator of docs and reader of the rest, got %+v", k)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`[{"name": "ops", "key": "s3cret", "roles": {"*": "admin"}}]`), 0600)
+	t.Setenv("CEND_API_KEYS_FILE", path)
+	if keys, err := loadAPIKeys(); err != nil || keys.byHash[sha256.Sum256([]byte("s3cret"))] == nil {
+		t.Errorf("Expected the file to be read before the environment, got %v", err)
+	}
+
+	for _, invalid := range []string{
+		`[{"name": "ops", "key": "s3cret", "roles": {"*": "owner"}}]`,
+		`[{"name": "ops", "roles": {"*": "admin"}}]`,
+		`[{"name": "a", "key": "k"}, {"name": "b", "key": "k"}]`,
+	} {
+		os.WriteFile(path, []byte(invalid), 0600)
+		if _, err := loadAPIKeys(); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
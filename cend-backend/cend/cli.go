@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCommand runs the subcommand named by args, if any, and reports
+// whether there was one. Commands talk to a running server, so they work
+// whatever storage engine it uses.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "snapshot":
+		return true, snapshotCommand(args[1:])
+	case "restore":
+		return true, restoreCommand(args[1:])
+	}
+	return false, nil
+}
+
+// serverURL returns CEND_URL, defaulting to the local server.
+func serverURL() string {
+	if u, exists := os.LookupEnv("CEND_URL"); exists {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:8000"
+}
+
+// send sends a request to the server, with the API key in CEND_API_KEY if
+// it is set.
+func send(method, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if key := os.Getenv("CEND_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// renameFlags collects repeated -rename from:to flags.
+type renameFlags []string
+
+func (f *renameFlags) String() string     { return strings.Join(*f, ",") }
+func (f *renameFlags) Set(v string) error { *f = append(*f, v); return nil }
+
+// snapshotCommand downloads a snapshot of every collection:
+//
+//	cend snapshot [-server URL] [-o FILE]
+func snapshotCommand(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	server := fs.String("server", serverURL(), "URL of the CEND server")
+	out := fs.String("o", fmt.Sprintf("cend-snapshot-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")), "file to write, or - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	resp, err := send(http.MethodGet, *server+"/snapshot", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s\n", n, *out)
+	}
+	return nil
+}
+
+// restoreCommand uploads a snapshot, replacing the collections in it or
+// restoring them under new names:
+//
+//	cend restore [-server URL] [-rename from:to]... FILE
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	server := fs.String("server", serverURL(), "URL of the CEND server")
+	var renames renameFlags
+	fs.Var(&renames, "rename", "restore collection `from:to` under a new name; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: cend restore [-server URL] [-rename from:to]... FILE")
+	}
+	if _, err := parseRenames(renames); err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	query := url.Values{"rename": renames}
+	resp, err := send(http.MethodPost, *server+"/restore?"+query.Encode(), "application/gzip", f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var result RestoreResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Restored collections %s\n", strings.Join(result.Collections, ", "))
+	return nil
+}
+
+// responseError turns an error response from the server into an error.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	var e ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	if e.Details != "" {
+		return fmt.Errorf("%s: %s", e.Message, e.Details)
+	}
+	return fmt.Errorf("%s", e.Message)
+}
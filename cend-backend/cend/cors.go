@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// corsPolicy is which web pages may call the API from a browser, and how.
+type corsPolicy struct {
+	origins []string // origins allowed, or "*" for any
+	methods []string
+	headers []string // request headers allowed, canonicalized
+	maxAge  time.Duration
+}
+
+// exposedHeaders are the response headers of the API that scripts of
+// other origins may read.
+var exposedHeaders = []string{"Deprecation", "ETag", "Link", "Location", "Retry-After", "WWW-Authenticate"}
+
+// loadCORSPolicy reads the CORS policy from the comma-separated lists
+// CEND_CORS_ORIGINS, CEND_CORS_METHODS and CEND_CORS_HEADERS, and how long
+// browsers may cache a preflight, CEND_CORS_MAX_AGE, as in "10m". The UI at
+// http://localhost:3000 is allowed by default; an empty CEND_CORS_ORIGINS
+// allows no origin at all.
+func loadCORSPolicy() (*corsPolicy, error) {
+	list := func(name, fallback string) []string {
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			value = fallback
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+
+	p := &corsPolicy{
+		origins: list("CEND_CORS_ORIGINS", "http://localhost:3000"),
+		methods: list("CEND_CORS_METHODS", "GET, POST, PUT, PATCH, DELETE"),
+		headers: list("CEND_CORS_HEADERS", "Authorization, Content-Type, If-Match, If-None-Match, X-API-Key, X-CEND-Actor"),
+		maxAge:  10 * time.Minute,
+	}
+	for i, m := range p.methods {
+		p.methods[i] = strings.ToUpper(m)
+	}
+	for i, h := range p.headers {
+		p.headers[i] = http.CanonicalHeaderKey(h)
+	}
+	if maxAge, exists := os.LookupEnv("CEND_CORS_MAX_AGE"); exists {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CEND_CORS_MAX_AGE %q", maxAge)
+		}
+		p.maxAge = d
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	return slices.Contains(p.origins, "*") || slices.Contains(p.origins, origin)
+}
+
+// handler applies the policy to every request the router serves. It
+// answers preflight requests itself, for any route of the router that has
+// the method the browser asks for.
+func (p *corsPolicy) handler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !slices.Contains(p.origins, "*") {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		if !p.allowsOrigin(origin) {
+			if preflight {
+				writeError(w, http.StatusForbidden, "FORBIDDEN", "Origin not allowed", fmt.Sprintf("%s may not call this API from a browser.", origin))
+				return
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		allowOrigin := origin
+		if slices.Contains(p.origins, "*") {
+			allowOrigin = "*"
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !slices.Contains(p.methods, method) {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed", fmt.Sprintf("%s may not be used from a browser.", method))
+			return
+		}
+		var match mux.RouteMatch
+		target := r.Clone(r.Context())
+		target.Method = method
+		if !router.Match(target, &match) {
+			notFoundHandler(w, target)
+			return
+		}
+		if match.Route == nil {
+			// The router's handler of a path it has no route for, or
+			// that has none for the method.
+			match.Handler.ServeHTTP(w, target)
+			return
+		}
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if h = http.CanonicalHeaderKey(strings.TrimSpace(h)); h != "" && !slices.Contains(p.headers, h) {
+				writeError(w, http.StatusForbidden, "FORBIDDEN", "Header not allowed", fmt.Sprintf("%s may not be sent from a browser.", h))
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// preflight sends the preflight request a browser at origin sends before
+// calling method on path with the headers.
+func preflight(h http.Handler, origin, method, path, headers string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	t.Setenv("CEND_CORS_ORIGINS", "http://localhost:3000, https://terms.example.org")
+	policy, err := loadCORSPolicy()
+	if err != nil {
+		t.Fatalf("Error loading CORS policy: %v", err)
+	}
+	// Preflights carry no credentials, so they must pass without a key.
+	h := policy.handler(newTestRouterWith(t, testKeys(t), nil).(*mux.Router))
+
+	rec := preflight(h, "https://terms.example.org", http.MethodPatch, "/collections/docs/documents/1", "content-type, if-match")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://terms.example.org" ||
+		rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST, PUT, PATCH, DELETE" || rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Expected the preflight to be allowed, got %d %v", rec.Code, rec.Header())
+	}
+	for _, path := range []string{"/", "/openapi.json", "/collections/docs/search", "/reconcile/docs"} {
+		if rec := preflight(h, "http://localhost:3000", http.MethodGet, path, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("%s: expected the preflight to be allowed, got %d", path, rec.Code)
+		}
+	}
+
+	expectError(t, preflight(h, "http://localhost:3000", http.MethodDelete, "/search", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	expectError(t, preflight(h, "http://localhost:3000", http.MethodGet, "/no-such-endpoint", ""), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, preflight(h, "http://localhost:3000", "TRACE", "/", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	expectError(t, preflight(h, "http://localhost:3000", http.MethodPost, "/search", "X-Secret"), http.StatusForbidden, "FORBIDDEN")
+	rec = preflight(h, "https://evil.example.com", http.MethodPost, "/search", "")
+	expectError(t, rec, http.StatusForbidden, "FORBIDDEN")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for an origin not allowed")
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	policy := &corsPolicy{origins: []string{"http://localhost:3000"}, methods: []string{http.MethodGet, http.MethodPost}}
+	h := policy.handler(newTestRouter(t).(*mux.Router))
+
+	req := httptest.NewRequest(http.MethodGet, "/collections/docs/documents/1", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" ||
+		rec.Header().Get("Access-Control-Expose-Headers") == "" || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected CORS headers, got %d %v", rec.Code, rec.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected the request to be served without CORS headers, got %d %v", rec.Code, rec.Header())
+	}
+
+	policy.origins = []string{"*"}
+	req.Header.Set("Origin", "https://anyone.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Vary") != "" {
+		t.Errorf("Expected any origin to be allowed, got %v", rec.Header())
+	}
+
+	t.Setenv("CEND_CORS_MAX_AGE", "soon")
+	if _, err := loadCORSPolicy(); err == nil {
+		t.Errorf("Expected an invalid max age to be rejected")
+	}
+}
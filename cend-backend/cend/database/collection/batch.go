@@ -0,0 +1,261 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cend/database/storage"
+)
+
+// Operations of a batch.
+const (
+	BatchAdd    = "add"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+	BatchLink   = "link"
+)
+
+// Ref names a document in a batch: by its ID, or as the document added by
+// an earlier operation of the same batch, which has no ID until the batch
+// runs. In JSON it is the ID, or "#n" for the document added by operation
+// n, counting from 0.
+type Ref struct {
+	ID int
+	Op *int
+}
+
+func (r Ref) MarshalJSON() ([]byte, error) {
+	if r.Op != nil {
+		return json.Marshal(fmt.Sprintf("#%d", *r.Op))
+	}
+	return json.Marshal(r.ID)
+}
+
+func (r *Ref) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		*r = Ref{}
+		return json.Unmarshal(data, &r.ID)
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "#"))
+	if !strings.HasPrefix(s, "#") || err != nil {
+		return fmt.Errorf("invalid document reference %q; use an ID or #n for the document added by operation n", s)
+	}
+	*r = Ref{Op: &n}
+	return nil
+}
+
+// BatchOp is one operation of a batch.
+//
+//   - add adds Document with its Fields and preferred links.
+//   - update changes the text and fields of ID as DocumentUpdate does.
+//   - delete deletes ID, leaving a tombstone.
+//   - link sets whether ID is preferred and its preferred documents.
+//
+// Each is checked against ExpectedVersion, which may be AnyVersion.
+type BatchOp struct {
+	Op                 string             `json:"op"`
+	ID                 *Ref               `json:"id,omitempty"`
+	Document           string             `json:"document,omitempty"`
+	Fields             *map[string]string `json:"fields,omitempty"`
+	IsPreferred        bool               `json:"isPreferred,omitempty"`
+	PreferredDocuments []Ref              `json:"preferredDocuments,omitempty"`
+	ExpectedVersion    int                `json:"expectedVersion,omitempty"`
+}
+
+// BatchError reports the operation a batch failed at. None of the batch
+// was applied.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch applies operations in order, all or none of them; see Editor.Batch.
+func (c *Collection) Batch(ops []BatchOp) ([]int, error) {
+	return c.As("").Batch(ops)
+}
+
+// Batch applies operations to the collection in order as a single change
+// that is kept whole or not at all: if any operation fails, those before it
+// are rolled back and a *BatchError says which failed. It returns the ID of
+// the document each operation added or changed. Each operation is recorded
+// in history, once the whole batch has succeeded.
+func (e *Editor) Batch(ops []BatchOp) ([]int, error) {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mark := len(c.history)
+	c.holdLog = true
+	ids, err := e.batch(ops)
+	c.holdLog = false
+	if err != nil {
+		if rollbackErr := c.rollback(c.history[mark:]); rollbackErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("rolling back: %w", rollbackErr))
+		}
+		c.history = c.history[:mark]
+		return nil, err
+	}
+	for _, change := range c.history[mark:] {
+		c.writeChange(change)
+	}
+	return ids, nil
+}
+
+func (e *Editor) batch(ops []BatchOp) ([]int, error) {
+	ids := make([]int, 0, len(ops))
+	resolve := func(ref *Ref) (int, error) {
+		if ref == nil {
+			return 0, fmt.Errorf("missing document ID")
+		}
+		if ref.Op == nil {
+			return ref.ID, nil
+		}
+		if *ref.Op < 0 || *ref.Op >= len(ids) {
+			return 0, fmt.Errorf("#%d does not refer to an earlier operation", *ref.Op)
+		}
+		if ops[*ref.Op].Op != BatchAdd {
+			return 0, fmt.Errorf("#%d refers to an operation that adds no document", *ref.Op)
+		}
+		return ids[*ref.Op], nil
+	}
+
+	for i, op := range ops {
+		id, err := e.batchOp(op, resolve)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (e *Editor) batchOp(op BatchOp, resolve func(*Ref) (int, error)) (int, error) {
+	preferredDocs := []int{}
+	for _, ref := range op.PreferredDocuments {
+		id, err := resolve(&ref)
+		if err != nil {
+			return 0, err
+		}
+		if e.c.documents.Get(id) == nil {
+			return 0, fmt.Errorf("preferred document %d %w", id, ErrNotFound)
+		}
+		preferredDocs = append(preferredDocs, id)
+	}
+
+	switch op.Op {
+	case BatchAdd:
+		if op.Document == "" {
+			return 0, fmt.Errorf("cannot add an empty document")
+		}
+		id, err := e.documentAdd(op.Document)
+		if err != nil {
+			return 0, err
+		}
+		if op.Fields != nil && len(*op.Fields) > 0 {
+			if err := e.documentAddFields(id, op.Fields); err != nil {
+				return 0, err
+			}
+		}
+		if op.IsPreferred || len(preferredDocs) > 0 {
+			if err := e.documentSetPreferred(id, AnyVersion, op.IsPreferred, preferredDocs); err != nil {
+				return 0, err
+			}
+		}
+		return id, nil
+	case BatchUpdate, BatchDelete, BatchLink:
+		id, err := resolve(op.ID)
+		if err != nil {
+			return 0, err
+		}
+		switch op.Op {
+		case BatchUpdate:
+			err = e.documentUpdate(id, op.ExpectedVersion, op.Document, op.Fields)
+		case BatchDelete:
+			err = e.documentDelete(id, op.ExpectedVersion)
+		case BatchLink:
+			err = e.documentSetPreferred(id, op.ExpectedVersion, op.IsPreferred, preferredDocs)
+		}
+		return id, err
+	default:
+		return 0, fmt.Errorf("unknown operation %q; use %s, %s, %s or %s", op.Op, BatchAdd, BatchUpdate, BatchDelete, BatchLink)
+	}
+}
+
+// applyOrRollback runs writes, which change the documents with the given
+// IDs, and if one of them fails puts those documents back as before records
+// them, so that a change made of several writes is made whole or not at
+// all. The caller holds the lock.
+func (c *Collection) applyOrRollback(ids []int, before []storage.Record, writes func() error) error {
+	err := writes()
+	if err == nil {
+		return nil
+	}
+	if rollbackErr := c.rollback([]Change{{Documents: ids, Before: before}}); rollbackErr != nil {
+		return errors.Join(err, fmt.Errorf("rolling back: %w", rollbackErr))
+	}
+	return err
+}
+
+// rollback reverts changes, newest first, putting every document they
+// touched back as it was before them. The caller holds the lock.
+func (c *Collection) rollback(changes []Change) error {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		for _, id := range change.Documents {
+			var before *storage.Record
+			for _, rec := range change.Before {
+				if rec.ID == id {
+					before = &rec
+				}
+			}
+			if err := c.reset(id, before); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reset makes the document with the given ID exactly as rec records it,
+// tombstone and version included, or removes it if rec is nil. The caller
+// holds the lock.
+func (c *Collection) reset(id int, rec *storage.Record) error {
+	if old := c.store.Get(id); old != nil {
+		if old.Deleted().IsZero() {
+			if err := c.unindex(old.String(), id); err != nil {
+				return err
+			}
+		} else {
+			c.tombstones--
+		}
+		if rec == nil {
+			return c.store.RemoveDocument(id)
+		}
+	}
+	if rec == nil {
+		return nil
+	}
+	doc := rec.Doc()
+	x := nGramFrequency(stringNormalize(rec.Document), c.ngram)
+	doc.SetTokenFrequency(&x)
+	if err := c.store.Put(doc); err != nil {
+		return err
+	}
+	if rec.Deleted != nil {
+		c.tombstones++
+		return nil
+	}
+	return c.index(rec.Document, id)
+}
@@ -0,0 +1,174 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func ref(op int) Ref {
+	return Ref{Op: &op}
+}
+
+func TestBatch(t *testing.T) {
+	c := New("companies", "")
+	c.DocumentAdd("Intel")
+	c.DocumentAdd("Apple")
+	intel, apple := *c.DocumentID("Intel"), *c.DocumentID("Apple")
+
+	ids, err := c.As("alice").Batch([]BatchOp{
+		{Op: BatchAdd, Document: "IBM", Fields: &map[string]string{"ticker": "IBM"}, IsPreferred: true},
+		{Op: BatchAdd, Document: "I.B.M.", PreferredDocuments: []Ref{ref(0)}},
+		{Op: BatchUpdate, ID: &Ref{ID: intel}, Document: "Intel Corporation", ExpectedVersion: 1},
+		{Op: BatchDelete, ID: &Ref{ID: apple}},
+		{Op: BatchLink, ID: &Ref{ID: intel}, PreferredDocuments: []Ref{ref(0)}},
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	ibm, variant := *c.DocumentID("IBM"), *c.DocumentID("I.B.M.")
+	if want := []int{ibm, variant, intel, apple, intel}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected IDs %v, got %v", want, ids)
+	}
+	if doc := c.documents.Get(ibm); !doc.Preferred() || (*doc.Fields())["ticker"] != "IBM" {
+		t.Errorf("Expected the added document to be preferred with its fields, got %v", doc)
+	}
+	if got := c.documents.Get(variant).PreferredDocuments(); !slices.Equal(got, []int{ibm}) {
+		t.Errorf("Expected a reference to resolve to the added document, got %v", got)
+	}
+	if doc := c.documents.Get(intel); doc.String() != "Intel Corporation" || !slices.Equal(doc.PreferredDocuments(), []int{ibm}) {
+		t.Errorf("Expected the update and link to apply, got %v", doc)
+	}
+	if c.Tombstone(apple) == nil {
+		t.Errorf("Expected the delete to leave a tombstone")
+	}
+	if feed := c.HistoryFeed(2, 100); len(feed) != 8 || feed[0].Actor != "alice" {
+		t.Errorf("Expected alice's 8 changes to be recorded, got %v", ops(feed))
+	}
+}
+
+func TestBatchRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "companies.jsonl")
+	c := New("companies", "")
+	if err := c.OpenHistory(path); err != nil {
+		t.Fatalf("OpenHistory failed: %v", err)
+	}
+	defer c.Close()
+	c.DocumentAdd("IBM")
+	c.DocumentAdd("Intel")
+	ibm, intel := *c.DocumentID("IBM"), *c.DocumentID("Intel")
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM"})
+	before := savedRecords(c)
+	logged, _ := os.ReadFile(path)
+
+	_, err := c.Batch([]BatchOp{
+		{Op: BatchAdd, Document: "I.B.M.", PreferredDocuments: []Ref{{ID: ibm}}},
+		{Op: BatchUpdate, ID: &Ref{ID: ibm}, Document: "IBM Corp", Fields: &map[string]string{}},
+		{Op: BatchDelete, ID: &Ref{ID: intel}},
+		{Op: BatchLink, ID: &Ref{ID: 42}},
+	})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 3 || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected operation 3 to fail with a missing document, got %v", err)
+	}
+
+	if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+		t.Errorf("Expected documents %v after the rollback, got %v", before, got)
+	}
+	if c.DocumentExists("I.B.M.") || c.DocumentExists("IBM Corp") || !c.DocumentExists("IBM") || !c.DocumentExists("Intel") {
+		t.Errorf("Expected the index to be rolled back, got %v", c.DocumentList())
+	}
+	if results := c.DocumentSearch("intel"); len(results) == 0 || results[0].ID != intel {
+		t.Errorf("Expected the deleted document to be searchable again, got %v", results)
+	}
+	if c.documents.Length() != 2 || c.deleted().Length() != 0 {
+		t.Errorf("Expected 2 documents and no tombstones, got %d and %d", c.documents.Length(), c.deleted().Length())
+	}
+	if feed := c.HistoryFeed(0, 100); len(feed) != 3 {
+		t.Errorf("Expected no changes of the batch in history, got %v", ops(feed))
+	}
+	if got, _ := os.ReadFile(path); !reflect.DeepEqual(got, logged) {
+		t.Errorf("Expected no changes of the batch in the history file")
+	}
+
+	for _, tt := range []struct {
+		name string
+		ops  []BatchOp
+	}{
+		{"unknown operation", []BatchOp{{Op: "merge"}}},
+		{"forward reference", []BatchOp{{Op: BatchDelete, ID: &Ref{Op: new(int)}}}},
+		{"reference to no add", []BatchOp{{Op: BatchDelete, ID: &Ref{ID: ibm}}, {Op: BatchLink, ID: &Ref{Op: new(int)}}}},
+		{"existing document", []BatchOp{{Op: BatchAdd, Document: "Apple"}, {Op: BatchAdd, Document: "Intel"}}},
+	} {
+		if _, err := c.Batch(tt.ops); err == nil {
+			t.Errorf("%s: expected Batch to fail", tt.name)
+		}
+	}
+	if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+		t.Errorf("Expected failed batches to change nothing, got %v", got)
+	}
+}
+
+// TestBatchIsolatedFromSearches checks that searches running beside
+// batches that fail see none of their changes. Run it with -race.
+func TestBatchIsolatedFromSearches(t *testing.T) {
+	c := New("companies", "")
+	c.DocumentAdd("IBM")
+	c.DocumentAdd("Intel")
+	intel := *c.DocumentID("Intel")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				results := c.DocumentSearch("intel corporation")
+				if len(results) != 1 || results[0].ID != intel {
+					t.Errorf("Expected only Intel while batches fail, got %v", results)
+					return
+				}
+			}
+		}()
+	}
+	for range 100 {
+		if _, err := c.Batch([]BatchOp{
+			{Op: BatchAdd, Document: "Intel Corporation"},
+			{Op: BatchDelete, ID: &Ref{ID: intel}},
+			{Op: BatchDelete, ID: &Ref{ID: intel}},
+		}); err == nil {
+			t.Errorf("Expected the batch to fail")
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRefJSON(t *testing.T) {
+	var refs []Ref
+	if err := json.Unmarshal([]byte(`[7, "#0"]`), &refs); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(refs) != 2 || refs[0].ID != 7 || refs[0].Op != nil || refs[1].Op == nil || *refs[1].Op != 0 {
+		t.Errorf("Expected an ID and a reference to operation 0, got %+v", refs)
+	}
+	if data, _ := json.Marshal(refs); string(data) != `[7,"#0"]` {
+		t.Errorf("Expected references to marshal back, got %s", data)
+	}
+	if err := json.Unmarshal([]byte(`"0"`), &refs[0]); err == nil || !strings.Contains(err.Error(), "#n") {
+		t.Errorf("Expected a reference without # to be rejected, got %v", err)
+	}
+}
@@ -2,12 +2,16 @@ package collection
 
 import (
 	"fmt"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"unicode"
 
 	"golang.org/x/text/unicode/norm"
 
 	"cend/database/collection/documents"
+	"cend/database/storage"
 )
 
 // TODOs:
@@ -15,20 +19,21 @@ import (
 // Add lower-case filtration before token storage
 // Add search functionality
 
-// DocumentIDs stores the locations of documents containing a specific token.
-type DocumentIDs struct {
-	count int // number of times the token is found across documents
-	docIDs map[int]struct{} // set of document IDs where the token appears.
-}
-
 // Collection represents a collection of documents and provides methods
 // for managing tokenized entries and tracking document locations.
 type Collection struct {
+	mu           sync.RWMutex // serializes changes to documents and the index
 	Path		 string
 	name         string
 	ngram		int
-	lookupTable  *map[string]*DocumentIDs
-	documents    *documents.DocumentCollection
+	lookupTable  storage.PostingsStore
+	documents    storage.DocumentStore // store without its tombstones
+	store        storage.DocumentStore
+	tombstones   int // number of tombstones in store
+	suggestions  *suggestTrie
+	history      []Change
+	historyLog   *os.File
+	holdLog      bool // changes are written to historyLog when a batch commits
 }
 
 
@@ -37,24 +42,130 @@ type SearchResultScore struct {
 	ID int `json:"id"`
 	Document string  `json:"document"`
 	Score    float64 `json:"score"`
+	Explanation *Explanation `json:"explanation,omitempty"`
+	MatchedVariant *VariantMatch `json:"matchedVariant,omitempty"`
 }
 
-// New creates and returns a new Collection with the specified name.
+// New creates and returns a new in-memory Collection with the specified name.
 func New(name, path string) *Collection {
 
-	return &Collection{
+	c := &Collection{
 		Path:		path,
 		name:        name,
 		ngram:		3,
-		lookupTable: &map[string]*DocumentIDs{},
-		documents:   documents.NewDocumentCollection(),
+		lookupTable: &memoryPostings{},
+		suggestions: newSuggestTrie(),
+	}
+	c.setStore(documents.NewDocumentCollection())
+	return c
+}
+
+// Open creates a collection over the stores engine holds for name. The
+// suggestion trie is always rebuilt, and the n-gram index is reconciled
+// with the documents.
+func Open(name, path string, engine storage.Engine) (*Collection, error) {
+	docs, err := engine.Documents(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening collection %s: %w", name, err)
+	}
+	postings, err := engine.Postings(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening collection %s: %w", name, err)
 	}
+	c := New(name, path)
+	c.setStore(docs)
+	c.lookupTable = postings
+
+	if err := c.reconcileIndex(); err != nil {
+		return nil, fmt.Errorf("indexing collection %s: %w", name, err)
+	}
+	for _, id := range c.documents.IDs() {
+		doc := c.documents.Get(id)
+		c.suggestions.add(stringNormalize(doc.String()), doc)
+	}
+	return c, nil
 }
 
-func (c *Collection) GetDocumentCollection() *documents.DocumentCollection {
+// reconcileIndex makes the n-gram index hold the tokens of every document
+// and nothing else. Engines write a document and its postings one after the
+// other, so a crash in between can leave a document that is never found or
+// postings of a document that is gone. It also builds the index of an
+// engine that has documents but no postings.
+func (c *Collection) reconcileIndex() error {
+	indexed := make(map[int]int)    // number of postings of each document
+	stale := make(map[int][]string) // postings of documents that are gone
+	for _, token := range c.lookupTable.Tokens() {
+		for _, id := range c.lookupTable.DocIDs(token) {
+			if c.documents.Get(id) == nil {
+				stale[id] = append(stale[id], token)
+			} else {
+				indexed[id]++
+			}
+		}
+	}
+	for id, tokens := range stale {
+		if err := c.lookupTable.Remove(id, tokens); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.documents.IDs() {
+		tokens := c.tokens(c.documents.Get(id).String())
+		if indexed[id] == len(tokens) {
+			continue
+		}
+		missing := slices.DeleteFunc(tokens, func(token string) bool {
+			return slices.Contains(c.lookupTable.DocIDs(token), id)
+		})
+		if err := c.lookupTable.Add(id, missing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDocumentCollection returns the store of the collection's documents.
+// It is read without the collection's lock, so it is only safe to use while
+// nothing changes the collection; use Document and Length otherwise.
+func (c *Collection) GetDocumentCollection() storage.DocumentStore {
 	return c.documents
 }
 
+// Document returns a copy of the document with the given ID, or nil if
+// there is no such document.
+func (c *Collection) Document(docId int) *documents.Document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if doc := c.documents.Get(docId); doc != nil {
+		return copyDocument(doc)
+	}
+	return nil
+}
+
+// Length returns the number of documents in the collection, not counting
+// tombstones.
+func (c *Collection) Length() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.documents.Length()
+}
+
+// Name returns the name the collection was created with.
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// NGram returns the length of the n-grams documents are indexed by.
+func (c *Collection) NGram() int {
+	return c.ngram
+}
+
+// IndexSize returns the number of distinct tokens in the n-gram index.
+func (c *Collection) IndexSize() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.lookupTable.Tokens())
+}
+
 func stringNormalize(s string) string {
 	// TODO: Remove stop words
 	
@@ -120,150 +231,486 @@ func nGramFrequency(document string, n int) map[string]int {
 	return nGramFrequency
 }
 
-// removeDocID removes the specified docID from DocumentIDs.
-func (IDs *DocumentIDs) removeDocID(docID int) error {
+// DocumentID retrieves the ID of a document if it exists in the
+// collection.
+func (c *Collection) DocumentID(document string) *int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.documentID(document)
+}
 
-	// Check if docID exists and delete it if found
-	if _, exists := IDs.docIDs[docID]; exists {
-		delete(IDs.docIDs, docID)
-		IDs.count--
-		
-		if IDs.count < 0 {
-			return fmt.Errorf("docCount is negative")
+// documentID retrieves the ID of a document; see DocumentID. The caller
+// holds the lock.
+func (c *Collection) documentID(document string) *int {
+	normalizedDocument := stringNormalize(document)
+	for _, docID := range c.lookupTable.DocIDs(normalizedDocument) {
+		actualDocument := c.documents.Get(docID)
+		if actualDocument != nil && actualDocument.String() == document {
+			return &docID
 		}
-		return nil
 	}
+	return nil
+}
 
-	return fmt.Errorf("docID %d not found", docID)
+// DocumentExists returns true if the document exists, otherwise false.
+func (c *Collection) DocumentExists(document string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.documentExists(document)
 }
 
-// addDocID adds a new docID to DocumentIDs.
-func (IDs *DocumentIDs) addDocID(docID int) error {
-	if _, exists := IDs.docIDs[docID]; exists {
-		return fmt.Errorf("docID %d already exists", docID)
-	}
-	IDs.count++
-	IDs.docIDs[docID] = struct{}{}
-	return nil
+// documentExists reports whether the document exists. The caller holds the
+// lock.
+func (c *Collection) documentExists(document string) bool {
+	return c.documentID(document) != nil
 }
 
-// tableAdd adds the token and associated docID to the collection’s
-// lookupTable.
-func (c *Collection) tableAdd(token string, docID int) {
-	if ids, exists := (*c.lookupTable)[token]; exists {
-		ids.addDocID(docID)
-	} else {
-		(*c.lookupTable)[token] = &DocumentIDs{
-			count: 1,
-			docIDs:   make(map[int]struct{}),
-		}
-		(*c.lookupTable)[token].docIDs[docID] = struct{}{}
+// DocumentAdd adds a document; its n-grams are tokenized and stored in the lookupTable.
+func (c *Collection) DocumentAdd(document string) error {
+	return c.As("").DocumentAdd(document)
+}
+
+// DocumentAdd adds a document; its n-grams are tokenized and stored in the lookupTable.
+func (e *Editor) DocumentAdd(document string) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	_, err := e.documentAdd(document)
+	return err
+}
+
+// documentAdd adds a document and returns its ID. The caller holds the
+// lock.
+func (e *Editor) documentAdd(document string) (int, error) {
+	c := e.c
+	if c.documentExists(document) {
+		return 0, fmt.Errorf("cannot add document that %w: document=%s", ErrExists, document)
+	}
+	normalizedDocument := stringNormalize(document)
+	x := nGramFrequency(normalizedDocument, c.ngram)
+	fields := make(map[string]string)
+	preferredDocs := []int{}
+	isPreferred := false
+	docID := c.documents.NextID()
+	d := documents.NewDocument(document, docID, &x, &isPreferred, &fields, &preferredDocs)
+	if err := c.documents.Put(d); err != nil {
+		return 0, err
+	}
+
+	if err := c.index(document, docID); err != nil {
+		c.documents.RemoveDocument(docID)
+		return 0, err
 	}
+	c.record(e.actor, OpAdd, nil, c.records(docID))
+	return docID, nil
 }
 
-// tableRemove removes the specified docID from the DocumentIDs
-// entry for the token in the lookupTable.
-func (c *Collection) tableRemove(token string, docID int) error {
-	ids, exists := (*c.lookupTable)[token]
-	if !exists {
-		return fmt.Errorf("token not found")
+// DocumentLoad adds a document under the ID and version it was stored
+// with, as when restoring a snapshot. A tombstone is added as one.
+func (c *Collection) DocumentLoad(rec storage.Record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rec.ID < 1 {
+		return fmt.Errorf("invalid document ID %d", rec.ID)
+	}
+	if c.store.Get(rec.ID) != nil {
+		return fmt.Errorf("document %d %w", rec.ID, ErrExists)
+	}
+	if rec.Deleted != nil {
+		if err := c.store.Put(rec.Doc()); err != nil {
+			return err
+		}
+		c.tombstones++
+		return nil
+	}
+	if c.documentExists(rec.Document) {
+		return fmt.Errorf("cannot add document that %w: document=%s", ErrExists, rec.Document)
+	}
+	d := rec.Doc()
+	x := nGramFrequency(stringNormalize(rec.Document), c.ngram)
+	d.SetTokenFrequency(&x)
+	if err := c.documents.Put(d); err != nil {
+		return err
 	}
-	ids.removeDocID(docID)
 
-	if len(ids.docIDs) == 0 {
-		delete(*c.lookupTable, token)
-	} else {
-		(*c.lookupTable)[token] = ids
+	if err := c.index(rec.Document, rec.ID); err != nil {
+		c.documents.RemoveDocument(rec.ID)
+		return err
 	}
 	return nil
-	
 }
 
-// DocumentID retrieves the ID of a document if it exists in the
-// collection.
-func (c *Collection) DocumentID(document string) *int {
-	normalizedDocument := stringNormalize(document)
-	if _, exists := (*c.lookupTable)[normalizedDocument]; !exists {
-		return nil
+// Clear removes every document, tombstones included. IDs already handed
+// out are still not reused.
+func (c *Collection) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range c.documents.IDs() {
+		if err := c.documentRemove(id, AnyVersion); err != nil {
+			return err
+		}
 	}
-	ids := (*c.lookupTable)[normalizedDocument]
-	for docID := range ids.docIDs {
-		actualDocument := (*c.documents).Get(docID)
-		if actualDocument.String() == document {
-			return &docID
+	for _, id := range c.store.IDs() {
+		if err := c.store.RemoveDocument(id); err != nil {
+			return err
 		}
+		c.tombstones--
 	}
 	return nil
 }
 
-// DocumentExists returns true if the document exists, otherwise false.
-func (c *Collection) DocumentExists(document string) bool {
-	return c.DocumentID(document) != nil
+// SetNextID makes the collection give new documents IDs from id on, unless
+// it already would, as when restoring documents whose last IDs were
+// removed.
+func (c *Collection) SetNextID(id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store.SetNextID(id)
 }
 
-// DocumentAdd adds a document; its n-grams are tokenized and stored in the lookupTable.
-func (c *Collection) DocumentAdd(document string) error {
+// Dump is what a collection holds at one moment.
+type Dump struct {
+	Records []storage.Record // stored form of every document and tombstone, in ID order
+	NextID  int
+	Tokens  int // number of distinct tokens in the n-gram index
+}
 
-	if c.DocumentExists(document) {
-		return fmt.Errorf("cannot add document that already exists: document=%s", document)
+// Dump returns the documents of the collection, tombstones included, read
+// under its lock so that no change is half seen.
+func (c *Collection) Dump() Dump {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Dump{
+		Records: c.records(c.store.IDs()...),
+		NextID:  c.documents.NextID(),
+		Tokens:  len(c.lookupTable.Tokens()),
 	}
-	normalizedDocument := stringNormalize(document)
-	x := nGramFrequency(normalizedDocument, c.ngram)
-	fields := make(map[string]string)
-	preferredDocs := []int{}
-	docID := c.documents.AddDocument(document, x, false, fields, preferredDocs)
-	d := c.documents.Get(docID)
-	d.SetTokenFrequency(&x)
+}
 
+// tokens returns the tokens a document is indexed under: its n-grams and,
+// unless it is a single n-gram, its whole normalized form.
+func (c *Collection) tokens(document string) []string {
+	normalizedDocument := stringNormalize(document)
+	tokens := []string{}
 	if len(normalizedDocument) != c.ngram {
-		c.tableAdd(normalizedDocument, docID)
+		tokens = append(tokens, normalizedDocument)
 	}
-	ngrams := nGramSet(document, c.ngram)
+	for ngram := range nGramSet(document, c.ngram) {
+		tokens = append(tokens, ngram)
+	}
+	return tokens
+}
 
-    for ngram := range ngrams {
-        c.tableAdd(ngram, docID)
-    }
+// index stores the tokens of a document in the lookupTable and its
+// normalized form in the suggestion trie. The document must already be
+// stored.
+func (c *Collection) index(document string, docID int) error {
+	if err := c.lookupTable.Add(docID, c.tokens(document)); err != nil {
+		c.lookupTable.Remove(docID, c.tokens(document))
+		return err
+	}
+	if c.suggestions != nil {
+		c.suggestions.add(stringNormalize(document), c.store.Get(docID))
+	}
 	return nil
 }
 
+// resuggest updates the suggestion trie after a stored document became
+// preferred or stopped being so without its text changing.
+func (c *Collection) resuggest(docID int) {
+	if doc := c.documents.Get(docID); doc != nil && c.suggestions != nil {
+		c.suggestions.remove(stringNormalize(doc.String()), docID)
+		c.suggestions.add(stringNormalize(doc.String()), doc)
+	}
+}
 
+// unindex reverses index.
+func (c *Collection) unindex(document string, docID int) error {
+	if err := c.lookupTable.Remove(docID, c.tokens(document)); err != nil {
+		return err
+	}
+	if c.suggestions != nil {
+		c.suggestions.remove(stringNormalize(document), docID)
+	}
+	return nil
+}
 
-// DocumentRemove removes a document from the collection. If the
-// document exists, it is removed from documents and its associated
-// tokens are removed from the lookupTable.
+// DocumentRemove deletes a document from the collection. Its tokens are
+// removed from the lookupTable, so it is no longer found, but the document
+// is kept as a tombstone until it is purged and can be restored with
+// DocumentRestore.
 func (c *Collection) DocumentRemove(docId int) error {
+	return c.As("").DocumentRemove(docId)
+}
+
+// DocumentRemove deletes a document from the collection, leaving a
+// tombstone; see Collection.DocumentRemove.
+func (e *Editor) DocumentRemove(docId int) error {
+	return e.DocumentRemoveIfVersion(docId, AnyVersion)
+}
+
+// DocumentRemoveIfVersion deletes a document if it is still at version,
+// failing with a *VersionConflictError otherwise.
+func (c *Collection) DocumentRemoveIfVersion(docId, version int) error {
+	return c.As("").DocumentRemoveIfVersion(docId, version)
+}
+
+// DocumentRemoveIfVersion deletes a document if it is still at version,
+// failing with a *VersionConflictError otherwise.
+func (e *Editor) DocumentRemoveIfVersion(docId, version int) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	return e.documentDelete(docId, version)
+}
+
+// documentDelete deletes a document, leaving a tombstone. The caller holds
+// the lock.
+func (e *Editor) documentDelete(docId, version int) error {
+	c := e.c
+	doc := c.documents.Get(docId)
+	if doc == nil {
+		return fmt.Errorf("document %d %w", docId, ErrNotFound)
+	}
+	if err := checkVersion(doc, version); err != nil {
+		return err
+	}
+	before := c.records(docId)
+	if err := c.unindex(doc.String(), docId); err != nil {
+		return err
+	}
+	tombstone := copyDocument(doc)
+	tombstone.SetDeleted(now().UTC())
+	tombstone.SetVersion(doc.Version() + 1)
+	if err := c.store.Put(tombstone); err != nil {
+		c.index(doc.String(), docId)
+		return err
+	}
+	c.tombstones++
+	c.record(e.actor, OpRemove, before, nil)
+	return nil
+}
 
+// documentRemove removes a document for good, leaving no tombstone, as
+// when it is merged into another.
+func (c *Collection) documentRemove(docId, version int) error {
 	doc := c.documents.Get(docId)
+	if doc == nil {
+		return fmt.Errorf("document %d %w", docId, ErrNotFound)
+	}
+	if err := checkVersion(doc, version); err != nil {
+		return err
+	}
 	docStr := doc.String()
-	c.documents.RemoveDocument(docId)
-	
-	if len(docStr) != c.ngram {
-		c.tableRemove(docStr, docId)
+	if err := c.unindex(docStr, docId); err != nil {
+		return err
+	}
+	if err := c.documents.RemoveDocument(docId); err != nil {
+		c.index(docStr, docId)
+		return err
 	}
-	ngrams := nGramSet(docStr, c.ngram)
+	return nil
+}
 
-	for ngram := range ngrams {
-		c.tableRemove(ngram, docId)
+// DocumentUpdate changes the text of a document and replaces its fields
+// while keeping its ID, so links to it from preferredDocuments still hold.
+// The document is reindexed under its new text. An empty document keeps
+// the current text and nil fields keep the current fields.
+func (c *Collection) DocumentUpdate(docId int, document string, fields *map[string]string) error {
+	return c.As("").DocumentUpdate(docId, document, fields)
+}
+
+// DocumentUpdate changes the text and fields of a document while keeping
+// its ID; see Collection.DocumentUpdate.
+func (e *Editor) DocumentUpdate(docId int, document string, fields *map[string]string) error {
+	return e.DocumentUpdateIfVersion(docId, AnyVersion, document, fields)
+}
+
+// DocumentUpdateIfVersion updates a document if it is still at version,
+// failing with a *VersionConflictError otherwise.
+func (c *Collection) DocumentUpdateIfVersion(docId, version int, document string, fields *map[string]string) error {
+	return c.As("").DocumentUpdateIfVersion(docId, version, document, fields)
+}
+
+// DocumentUpdateIfVersion updates a document if it is still at version,
+// failing with a *VersionConflictError otherwise.
+func (e *Editor) DocumentUpdateIfVersion(docId, version int, document string, fields *map[string]string) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	return e.documentUpdate(docId, version, document, fields)
+}
+
+// documentUpdate updates a document. The caller holds the lock.
+func (e *Editor) documentUpdate(docId, version int, document string, fields *map[string]string) error {
+	c := e.c
+	doc := c.documents.Get(docId)
+	if doc == nil {
+		return fmt.Errorf("document %d %w", docId, ErrNotFound)
+	}
+	if err := checkVersion(doc, version); err != nil {
+		return err
+	}
+	before := c.records(docId)
+	oldDocument := doc.String()
+	if document == "" {
+		document = oldDocument
 	}
+	if document != oldDocument && c.documentExists(document) {
+		return fmt.Errorf("cannot update to document that %w: document=%s", ErrExists, document)
+	}
+	if fields == nil {
+		fields = doc.Fields()
+	}
+	if fields == nil {
+		fields = &map[string]string{}
+	}
+	isPreferred := doc.Preferred()
+	preferredDocs := doc.PreferredDocuments()
+	x := nGramFrequency(stringNormalize(document), c.ngram)
+	updated := documents.NewDocument(document, docId, &x, &isPreferred, fields, &preferredDocs)
+	updated.SetVersion(doc.Version() + 1)
+
+	if document == oldDocument {
+		if err := c.documents.Put(updated); err != nil {
+			return err
+		}
+		c.record(e.actor, OpUpdate, before, c.records(docId))
+		return nil
+	}
+	if err := c.unindex(oldDocument, docId); err != nil {
+		return err
+	}
+	if err := c.documents.Put(updated); err != nil {
+		c.index(oldDocument, docId)
+		return err
+	}
+	if err := c.index(document, docId); err != nil {
+		c.documents.Put(doc)
+		c.index(oldDocument, docId)
+		return err
+	}
+	c.record(e.actor, OpUpdate, before, c.records(docId))
+	return nil
+}
+
+// DocumentAddFields adds fields to a document, overwriting existing keys.
+func (c *Collection) DocumentAddFields(docId int, fields *map[string]string) error {
+	return c.As("").DocumentAddFields(docId, fields)
+}
+
+// DocumentAddFields adds fields to a document, overwriting existing keys.
+func (e *Editor) DocumentAddFields(docId int, fields *map[string]string) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	return e.documentAddFields(docId, fields)
+}
+
+// documentAddFields adds fields to a document. The caller holds the lock.
+func (e *Editor) documentAddFields(docId int, fields *map[string]string) error {
+	c := e.c
+	doc := c.documents.Get(docId)
+	if doc == nil {
+		return fmt.Errorf("document %d %w", docId, ErrNotFound)
+	}
+	before := c.records(docId)
+	if err := doc.AddFields(fields); err != nil {
+		return err
+	}
+	doc.SetVersion(doc.Version() + 1)
+	if err := c.documents.Put(doc); err != nil {
+		return err
+	}
+	c.record(e.actor, OpFields, before, c.records(docId))
+	return nil
+}
+
+// DocumentSetPreferred marks whether a document is a preferred term and sets
+// the preferred documents it is a variant of.
+func (c *Collection) DocumentSetPreferred(docId int, isPreferred bool, preferredDocuments []int) error {
+	return c.As("").DocumentSetPreferred(docId, isPreferred, preferredDocuments)
+}
+
+// DocumentSetPreferred marks whether a document is a preferred term and sets
+// the preferred documents it is a variant of.
+func (e *Editor) DocumentSetPreferred(docId int, isPreferred bool, preferredDocuments []int) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	return e.documentSetPreferred(docId, AnyVersion, isPreferred, preferredDocuments)
+}
+
+// documentSetPreferred sets whether a document is preferred and its
+// preferred documents if it is still at version. The caller holds the lock.
+func (e *Editor) documentSetPreferred(docId, version int, isPreferred bool, preferredDocuments []int) error {
+	c := e.c
+	doc := c.documents.Get(docId)
+	if doc == nil {
+		return fmt.Errorf("document %d %w", docId, ErrNotFound)
+	}
+	if err := checkVersion(doc, version); err != nil {
+		return err
+	}
+	before := c.records(docId)
+	if preferredDocuments == nil {
+		preferredDocuments = []int{}
+	}
+	doc.SetPreferred(isPreferred)
+	doc.SetPreferredDocuments(preferredDocuments)
+	doc.SetVersion(doc.Version() + 1)
+	if err := c.documents.Put(doc); err != nil {
+		return err
+	}
+	c.resuggest(docId)
+	c.record(e.actor, OpPreferred, before, c.records(docId))
 	return nil
 }
 
 // DocumentList retrieves a list of documents from the collection.
 func (c *Collection) DocumentList() []string {
-	return c.documents.DocumentList()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	documents := []string{}
+	for _, id := range c.documents.IDs() {
+		if doc := c.documents.Get(id); doc != nil {
+			documents = append(documents, doc.String())
+		}
+	}
+	return documents
+}
+
+// DocumentRange returns copies of the documents with IDs in [min, max] in
+// ID order. IDs without a document are skipped.
+func (c *Collection) DocumentRange(min, max int) ([]*documents.Document, error) {
+	// ensure valid range
+	if min < 1 {
+		return nil, fmt.Errorf("invalid min: %v", min)
+	}
+	if max < min {
+		return nil, fmt.Errorf("invalid max: %v", max)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs := []*documents.Document{}
+	for _, id := range c.documents.IDs() {
+		if id >= min && id <= max {
+			if doc := c.documents.Get(id); doc != nil {
+				docs = append(docs, copyDocument(doc))
+			}
+		}
+	}
+	return docs, nil
 }
 
 // RelevantDocumentIDs returns a set of document IDs that contain at least one n-gram from the provided document.
 func (c *Collection) RelevantDocumentIDs(document string) map[int]struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	documentIDs := make(map[int]struct{})
 	ngrams := nGramSet(document, c.ngram)
 	for ngram := range ngrams {
-		if ids, exists := (*c.lookupTable)[ngram]; exists {
-			for docID := range ids.docIDs {
-				documentIDs[docID] = struct{}{}
-			}
+		for _, docID := range c.lookupTable.DocIDs(ngram) {
+			documentIDs[docID] = struct{}{}
 		}
 	}
 	return documentIDs
 }
-
@@ -2,6 +2,8 @@ package collection
 
 import (
 	"cend/database/collection/documents"
+	"maps"
+	"slices"
 	"testing"
 )
 
@@ -28,7 +30,7 @@ func TestDocumentAdd(t *testing.T) {
 	// Verify n-grams are stored in lookup table
 	ngrams := nGrams(document, collection.ngram)
 	for _, ngram := range ngrams {
-		if _, exists := (*collection.lookupTable)[ngram]; !exists {
+		if collection.lookupTable.Count(ngram) == 0 {
 			t.Errorf("Expected n-gram '%s' to be in the lookup table after adding document '%s'", ngram, document)
 		}
 	}
@@ -58,7 +60,7 @@ func TestDocumentRemove(t *testing.T) {
 	// Verify n-grams are removed from lookup table
 	ngrams := nGrams(document, collection.ngram)
 	for _, ngram := range ngrams {
-		if _, exists := (*collection.lookupTable)[ngram]; exists {
+		if collection.lookupTable.Count(ngram) > 0 {
 			t.Errorf("Expected n-gram '%s' to be removed from the lookup table after removing document '%s'", ngram, document)
 		}
 	}
@@ -97,7 +99,7 @@ func overlapCollection1() (*Collection) {
 	return &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"apple": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -148,7 +150,7 @@ func overlapCollection2() (*Collection) {
 	return &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"apple": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -203,7 +205,7 @@ func overlapCollection3() *Collection {
 	return &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"apple": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -307,7 +309,7 @@ func TestAddRemoveSingleDocument(t *testing.T) {
 	expectedCollection := &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 		},
 		documents: documents.NewDocumentCollection(),
 	}
@@ -330,7 +332,7 @@ func TestAddDuplicateDocument(t *testing.T) {
 	expectedCollection := &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"ban": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -375,7 +377,7 @@ func TestAddLengthNDocument(t *testing.T) {
 	expectedCollection := &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"ban": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -412,7 +414,7 @@ func TestAddShortDocument(t *testing.T) {
 	expectedCollection := &Collection{
 		name: "Test Collection",
 		ngram: 3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"hi": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -449,7 +451,7 @@ func TestAddNormalizedDocument(t *testing.T) {
 	expectedCollection := &Collection{
 		name:    "Test Collection with Normalization",
 		ngram:   3,
-		lookupTable: &map[string]*DocumentIDs{
+		lookupTable: &memoryPostings{
 			"hello": {
 				count: 1,
 				docIDs: map[int]struct{}{
@@ -482,4 +484,57 @@ func TestAddNormalizedDocument(t *testing.T) {
 	if !Equal(actualCollection, expectedCollection) {
 		t.Errorf("Collections do not match.\nExpected: %+v\nGot: %+v", expectedCollection, actualCollection)
 	}
-}
\ No newline at end of file
+}
+
+func TestDocumentUpdate(t *testing.T) {
+	actualCollection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"Aple", "Banana"} {
+		actualCollection.DocumentAdd(doc)
+	}
+	banana := *actualCollection.DocumentID("Banana")
+	actualCollection.DocumentSetPreferred(banana, false, []int{1})
+	fields := map[string]string{"colour": "red"}
+	if err := actualCollection.DocumentUpdate(1, "Apple", &fields); err != nil {
+		t.Fatalf("DocumentUpdate failed: %v", err)
+	}
+
+	// The index matches a collection that was given the new text to begin
+	// with, and the ID and links to it are kept.
+	expectedCollection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"Apple", "Banana"} {
+		expectedCollection.DocumentAdd(doc)
+	}
+	if !Equal(actualCollection, expectedCollection) {
+		t.Errorf("Collections do not match.\nExpected: %+v\nGot: %+v", expectedCollection, actualCollection)
+	}
+	if id := actualCollection.DocumentID("Apple"); id == nil || *id != 1 {
+		t.Errorf("Expected Apple to keep ID 1, got %v", id)
+	}
+	if actualCollection.DocumentExists("Aple") {
+		t.Errorf("Expected the old text to be gone")
+	}
+	if got := actualCollection.documents.Get(banana).PreferredDocuments(); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected Banana to still link to 1, got %v", got)
+	}
+	if got := *actualCollection.documents.Get(1).Fields(); !maps.Equal(got, fields) {
+		t.Errorf("Expected fields %v, got %v", fields, got)
+	}
+	if suggestions := actualCollection.DocumentSuggest("app", 10); len(suggestions) != 1 || suggestions[0].ID != 1 {
+		t.Errorf("Expected the suggestion trie to hold the new text, got %v", suggestions)
+	}
+
+	// An empty document keeps the text, nil fields keep the fields.
+	if err := actualCollection.DocumentUpdate(1, "", nil); err != nil {
+		t.Fatalf("DocumentUpdate failed: %v", err)
+	}
+	if doc := actualCollection.documents.Get(1); doc.String() != "Apple" || !maps.Equal(*doc.Fields(), fields) {
+		t.Errorf("Expected Apple with fields %v, got %s with %v", fields, doc.String(), *doc.Fields())
+	}
+
+	if err := actualCollection.DocumentUpdate(1, "Banana", nil); err == nil {
+		t.Errorf("Expected updating to an existing document to fail")
+	}
+	if err := actualCollection.DocumentUpdate(42, "Cherry", nil); err == nil {
+		t.Errorf("Expected updating a missing document to fail")
+	}
+}
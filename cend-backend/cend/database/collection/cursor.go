@@ -0,0 +1,163 @@
+package collection
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+
+	"cend/database/collection/documents"
+	"cend/database/storage"
+)
+
+// scoreEpsilon is the difference below which two scores are treated as
+// equal. Scores are summed over maps, so identical inputs may differ in the
+// last bits between calls.
+const scoreEpsilon = 1e-12
+
+const (
+	cursorKindSearch = "search"
+	cursorKindList   = "list"
+)
+
+// cursor marks the last result of a page. Pages are keyed on (score, ID) for
+// searches and on ID for listings, so inserts and removals elsewhere in the
+// collection do not shift or repeat results.
+type cursor struct {
+	Kind  string  `json:"k"`
+	Query uint64  `json:"q,omitempty"` // fingerprint of the search the cursor belongs to
+	Score float64 `json:"s,omitempty"`
+	ID    int     `json:"i"`
+}
+
+func (cur cursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, kind string) (cursor, error) {
+	var cur cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return cur, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if cur.Kind != kind {
+		return cur, fmt.Errorf("invalid cursor: expected a %s cursor, got %q", kind, cur.Kind)
+	}
+	return cur, nil
+}
+
+// searchFingerprint identifies a search so that a cursor cannot be replayed
+// against a different query or options.
+func searchFingerprint(searchDoc string, opts SearchOptions) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%q|%+v", stringNormalize(searchDoc), opts)
+	return h.Sum64()
+}
+
+// compareScores orders by score descending, then by ID ascending.
+func compareScores(aScore float64, aID int, bScore float64, bID int) int {
+	if math.Abs(aScore-bScore) > scoreEpsilon {
+		if aScore > bScore {
+			return -1
+		}
+		return 1
+	}
+	return aID - bID
+}
+
+// SearchPage is one page of search results.
+type SearchPage struct {
+	Results    []SearchResultScore
+	Total      int    // number of results across all pages
+	NextCursor string // empty on the last page
+}
+
+// DocumentSearchPage returns up to limit results of
+// DocumentSearchWithOptions following the position encoded by after. An
+// empty after starts from the best result.
+func (c *Collection) DocumentSearchPage(searchDoc string, opts SearchOptions, after string, limit int) (SearchPage, error) {
+	fingerprint := searchFingerprint(searchDoc, opts)
+	results := c.DocumentSearchWithOptions(searchDoc, opts)
+
+	start := 0
+	if after != "" {
+		cur, err := decodeCursor(after, cursorKindSearch)
+		if err != nil {
+			return SearchPage{}, err
+		}
+		if cur.Query != fingerprint {
+			return SearchPage{}, fmt.Errorf("invalid cursor: cursor belongs to a different search")
+		}
+		for start < len(results) && compareScores(results[start].Score, results[start].ID, cur.Score, cur.ID) <= 0 {
+			start++
+		}
+	}
+
+	end := len(results)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	page := SearchPage{Results: results[start:end], Total: len(results)}
+	if end < len(results) {
+		last := results[end-1]
+		page.NextCursor = cursor{Kind: cursorKindSearch, Query: fingerprint, Score: last.Score, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// ListPage is one page of copies of documents in ID order.
+type ListPage struct {
+	Documents  []*documents.Document
+	Total      int    // number of documents listed across all pages
+	NextCursor string // empty on the last page
+}
+
+// DocumentListPage returns up to limit documents following the position
+// encoded by after. An empty after starts from the first document.
+func (c *Collection) DocumentListPage(after string, limit int) (ListPage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return listPage(c.documents, after, limit)
+}
+
+// TombstoneListPage pages through the collection's tombstones as
+// DocumentListPage pages through its documents.
+func (c *Collection) TombstoneListPage(after string, limit int) (ListPage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return listPage(c.deleted(), after, limit)
+}
+
+func listPage(docs storage.DocumentStore, after string, limit int) (ListPage, error) {
+	afterID := 0
+	if after != "" {
+		cur, err := decodeCursor(after, cursorKindList)
+		if err != nil {
+			return ListPage{}, err
+		}
+		afterID = cur.ID
+	}
+
+	ids := docs.IDs()
+	start, _ := slices.BinarySearch(ids, afterID+1)
+	end := len(ids)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	page := ListPage{Documents: make([]*documents.Document, 0, end-start), Total: len(ids)}
+	for _, id := range ids[start:end] {
+		if doc := docs.Get(id); doc != nil {
+			page.Documents = append(page.Documents, copyDocument(doc))
+		}
+	}
+	if end < len(ids) {
+		page.NextCursor = cursor{Kind: cursorKindList, ID: ids[end-1]}.encode()
+	}
+	return page, nil
+}
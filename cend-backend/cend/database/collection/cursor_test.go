@@ -0,0 +1,97 @@
+package collection
+
+import (
+	"testing"
+)
+
+func TestDocumentSearchPage(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"NZXT Hue", "NZXT Hue+", "NZXT Kraken", "NZXT H510", "Hue Lighting", "Corsair"} {
+		collection.DocumentAdd(doc)
+	}
+	searchDoc := "nzxt hue"
+	all := collection.DocumentSearch(searchDoc)
+
+	seen := []SearchResultScore{}
+	after := ""
+	for pages := 0; ; pages++ {
+		if pages > len(all) {
+			t.Fatalf("Pagination did not terminate after %d pages", pages)
+		}
+		page, err := collection.DocumentSearchPage(searchDoc, SearchOptions{}, after, 2)
+		if err != nil {
+			t.Fatalf("Unexpected error paging search results: %v", err)
+		}
+		if page.Total != len(all) {
+			t.Errorf("Expected total %d, got %d", len(all), page.Total)
+		}
+		seen = append(seen, page.Results...)
+		if page.NextCursor == "" {
+			break
+		}
+		after = page.NextCursor
+	}
+
+	if len(seen) != len(all) {
+		t.Fatalf("Expected %d results across pages, got %d", len(all), len(seen))
+	}
+	for i := range all {
+		if seen[i].ID != all[i].ID {
+			t.Errorf("Result %d: expected document %d, got %d", i, all[i].ID, seen[i].ID)
+		}
+	}
+
+	if _, err := collection.DocumentSearchPage("corsair", SearchOptions{}, after, 2); err == nil {
+		t.Errorf("Expected a cursor from a different search to be rejected")
+	}
+	if _, err := collection.DocumentSearchPage(searchDoc, SearchOptions{}, "not-a-cursor", 2); err == nil {
+		t.Errorf("Expected a malformed cursor to be rejected")
+	}
+}
+
+func TestDocumentListPageWithGaps(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"alpha", "bravo", "charlie", "delta", "echo"} {
+		collection.DocumentAdd(doc)
+	}
+	collection.DocumentRemove(*collection.DocumentID("bravo"))
+	collection.DocumentRemove(*collection.DocumentID("delta"))
+
+	// IDs are not reused after removal.
+	collection.DocumentAdd("foxtrot")
+	if id := collection.DocumentID("foxtrot"); id == nil || *id != 6 {
+		t.Errorf("Expected 'foxtrot' to get ID 6, got %v", id)
+	}
+
+	page, err := collection.DocumentListPage("", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error listing documents: %v", err)
+	}
+	if page.Total != 4 || len(page.Documents) != 2 || page.Documents[0].String() != "alpha" || page.Documents[1].String() != "charlie" {
+		t.Fatalf("Unexpected first page: total=%d documents=%v", page.Total, page.Documents)
+	}
+
+	// Documents added after the first page was read still appear.
+	collection.DocumentAdd("golf")
+
+	page, err = collection.DocumentListPage(page.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error listing documents: %v", err)
+	}
+	if len(page.Documents) != 2 || page.Documents[0].String() != "echo" || page.Documents[1].String() != "foxtrot" {
+		t.Fatalf("Unexpected second page: %v", page.Documents)
+	}
+
+	page, err = collection.DocumentListPage(page.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error listing documents: %v", err)
+	}
+	if len(page.Documents) != 1 || page.Documents[0].String() != "golf" || page.NextCursor != "" {
+		t.Errorf("Expected a final page of [golf] without a cursor, got %v (cursor %q)", page.Documents, page.NextCursor)
+	}
+
+	docs, err := collection.DocumentRange(1, 6)
+	if err != nil || len(docs) != 4 {
+		t.Errorf("Expected DocumentRange to skip removed IDs and return 4 documents, got %d (err %v)", len(docs), err)
+	}
+}
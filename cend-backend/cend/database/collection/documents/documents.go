@@ -3,6 +3,8 @@ package documents
 import (
 	"fmt"
 	"log"
+	"slices"
+	"time"
 )
 
 type Document struct {
@@ -12,15 +14,19 @@ type Document struct {
 	tokenFrequency     *map[string]int    // Optional
 	isPreferred        *bool              // Optional
 	preferredDocuments *[]int             // Optional
+	version            int                // Incremented on every change
+	deleted            time.Time          // Zero unless the document is a tombstone
 }
 
 type DocumentCollection struct {
 	documents map[int]*Document
+	nextID    int // IDs are never reused, so removals leave gaps
 }
 
 func NewDocumentCollection() *DocumentCollection {
 	return &DocumentCollection{
 		documents: make(map[int]*Document),
+		nextID:    1,
 	}
 }
 
@@ -36,22 +42,14 @@ func (dc *DocumentCollection) Documents() map[int]*Document {
 	return dc.documents
 }
 
-func (dc *DocumentCollection) GetDocuments(min, max int) ([]*Document, error) {
-	docs := []*Document{}
-	dcLen := dc.Length()
-
-	// ensure valid range
-	if min < 1 {
-		return nil, fmt.Errorf("invalid min: %v", min)
-	}
-	if max >= dcLen {
-		return nil, fmt.Errorf("invalid max: %v", max)
-	}
-
-	for id := min; id <= max; id++ {
-		docs = append(docs, dc.Get(id))
+// IDs returns the IDs of all documents in ascending order.
+func (dc *DocumentCollection) IDs() []int {
+	ids := make([]int, 0, len(dc.documents))
+	for id := range dc.documents {
+		ids = append(ids, id)
 	}
-	return docs, nil
+	slices.Sort(ids)
+	return ids
 }
 
 func (dc *DocumentCollection) AddDocument(
@@ -61,7 +59,7 @@ func (dc *DocumentCollection) AddDocument(
 		fields map[string]string,
 		preferredDocuments []int,
 	) int {
-	docID := len(dc.documents) + 1
+	docID := dc.nextID
 	document := NewDocument(doc, docID, &tokenFrequency, &isPreferred, &fields, &preferredDocuments)
 	if document == nil {
 		log.Printf("Error creating document, document is nil: %v", doc)
@@ -69,17 +67,44 @@ func (dc *DocumentCollection) AddDocument(
 	}
 	log.Printf("Adding document to collection: %v", doc)
 	dc.documents[docID] = document
+	dc.nextID++
 	return docID
 }
 
+// Put inserts or replaces a document under its own ID. IDs at or below
+// the document's ID will not be assigned again.
+func (dc *DocumentCollection) Put(document *Document) error {
+	if document == nil {
+		return fmt.Errorf("cannot put nil document")
+	}
+	dc.documents[document.id] = document
+	dc.nextID = max(dc.nextID, document.id+1)
+	return nil
+}
+
+// NextID returns the ID the next added document will get.
+func (dc *DocumentCollection) NextID() int {
+	return dc.nextID
+}
+
+// SetNextID advances the ID the next added document will get, as when
+// loading a collection whose last documents were removed. It never moves
+// backwards.
+func (dc *DocumentCollection) SetNextID(id int) error {
+	dc.nextID = max(dc.nextID, id)
+	return nil
+}
+
 func (dc *DocumentCollection) AddDocumentFromStr(doc string) int {
-	docID := len(dc.documents) + 1
+	docID := dc.nextID
 	dc.documents[docID] = NewDocument(doc, docID, nil, nil, nil, nil)
+	dc.nextID++
 	return docID
 }
 
-func (dc *DocumentCollection) RemoveDocument(id int) {
+func (dc *DocumentCollection) RemoveDocument(id int) error {
 	delete(dc.documents, id)
+	return nil
 }
 
 func (dc *DocumentCollection) Get(id int) *Document {
@@ -105,9 +130,11 @@ func NewDocument(
 	return &Document{
 		doc:                doc,
 		id:                 id,
+		fields:             fields,
 		tokenFrequency:     tokenFrequency,
 		isPreferred:        isPreferred,
 		preferredDocuments: preferredDocuments,
+		version:            1,
 	}
 }
 
@@ -127,8 +154,30 @@ func (d *Document) ID() int {
 	return d.id
 }
 
+// Version returns the document's version, which starts at 1 and is
+// incremented on every change so stale edits can be detected.
+func (d *Document) Version() int {
+	return d.version
+}
+
+func (d *Document) SetVersion(version int) {
+	d.version = version
+}
+
+// Deleted returns when the document was deleted, or the zero time if it
+// was not. A deleted document is kept as a tombstone until it is purged.
+func (d *Document) Deleted() time.Time {
+	return d.deleted
+}
+
+func (d *Document) SetDeleted(deleted time.Time) {
+	d.deleted = deleted
+}
+
+// Preferred reports whether the document is a preferred term. Documents
+// created without a preferred flag are not.
 func (d *Document) Preferred() bool {
-	return *d.isPreferred
+	return d.isPreferred != nil && *d.isPreferred
 }
 
 func (d *Document) SetPreferred(b bool) {
@@ -137,6 +186,9 @@ func (d *Document) SetPreferred(b bool) {
 
 
 func (d *Document) PreferredDocuments() []int {
+	if d.preferredDocuments == nil {
+		return nil
+	}
 	return *d.preferredDocuments
 }
 
@@ -162,7 +214,7 @@ func (d *Document) AddFields(newFields *map[string]string) error {
 	}
 
 	// If current fields are nil, initialize them
-	if d.fields == nil {
+	if d.fields == nil || *d.fields == nil {
 		d.fields = &map[string]string{}
 	}
 
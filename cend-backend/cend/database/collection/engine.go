@@ -0,0 +1,75 @@
+package collection
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"cend/database/collection/documents"
+	"cend/database/storage"
+)
+
+// MemoryEngine is the storage.Engine that keeps collections in process
+// memory. Nothing survives a restart.
+type MemoryEngine struct {
+	mu       sync.Mutex
+	docs     map[string]*documents.DocumentCollection
+	postings map[string]*memoryPostings
+}
+
+// NewMemoryEngine returns an empty MemoryEngine.
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{
+		docs:     make(map[string]*documents.DocumentCollection),
+		postings: make(map[string]*memoryPostings),
+	}
+}
+
+func (e *MemoryEngine) CreateCollection(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.docs[name]; !exists {
+		e.docs[name] = documents.NewDocumentCollection()
+		e.postings[name] = &memoryPostings{}
+	}
+	return nil
+}
+
+func (e *MemoryEngine) DropCollection(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.docs, name)
+	delete(e.postings, name)
+	return nil
+}
+
+func (e *MemoryEngine) Collections() ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Sorted(maps.Keys(e.docs)), nil
+}
+
+func (e *MemoryEngine) Documents(collection string) (storage.DocumentStore, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	docs, exists := e.docs[collection]
+	if !exists {
+		return nil, fmt.Errorf("collection %s not found", collection)
+	}
+	return docs, nil
+}
+
+func (e *MemoryEngine) Postings(collection string) (storage.PostingsStore, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	postings, exists := e.postings[collection]
+	if !exists {
+		return nil, fmt.Errorf("collection %s not found", collection)
+	}
+	return postings, nil
+}
+
+func (e *MemoryEngine) Close() error {
+	return nil
+}
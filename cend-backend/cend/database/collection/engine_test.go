@@ -0,0 +1,153 @@
+package collection
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cend/database/storage"
+	"cend/database/storage/segment"
+	"cend/database/storage/storagetest"
+)
+
+func TestMemoryEngine(t *testing.T) {
+	storagetest.TestEngine(t, func(t *testing.T) storage.Engine { return NewMemoryEngine() }, nil)
+}
+
+// TestOpenBoltEngine checks that a collection over a file-backed engine
+// keeps its documents and index across reopening.
+func TestOpenBoltEngine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cend.db")
+	engine, err := storage.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+	if err := engine.CreateCollection("companies"); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	companies, err := Open("companies", "", engine)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, doc := range []string{"IBM", "I.B.M.", "Intel", "Apple"} {
+		if err := companies.DocumentAdd(doc); err != nil {
+			t.Fatalf("DocumentAdd(%s) failed: %v", doc, err)
+		}
+	}
+	ibm := *companies.DocumentID("IBM")
+	if err := companies.DocumentSetPreferred(ibm, true, nil); err != nil {
+		t.Fatalf("DocumentSetPreferred failed: %v", err)
+	}
+	if err := companies.DocumentRemove(*companies.DocumentID("Apple")); err != nil {
+		t.Fatalf("DocumentRemove failed: %v", err)
+	}
+	expected := companies.DocumentSearch("intel")
+	tokens := companies.lookupTable.Tokens()
+	engine.Close()
+
+	engine, err = storage.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+	defer engine.Close()
+	reopened, err := Open("companies", "", engine)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if got := reopened.DocumentList(); !reflect.DeepEqual(got, []string{"IBM", "I.B.M.", "Intel"}) {
+		t.Errorf("Expected documents [IBM I.B.M. Intel] after reopening, got %v", got)
+	}
+	if got := reopened.lookupTable.Tokens(); !reflect.DeepEqual(got, tokens) {
+		t.Errorf("Expected tokens %v after reopening, got %v", tokens, got)
+	}
+	if doc := reopened.GetDocumentCollection().Get(ibm); doc == nil || !doc.Preferred() {
+		t.Errorf("Expected 'IBM' to stay preferred after reopening, got %v", doc)
+	}
+	results := reopened.DocumentSearch("intel")
+	if len(results) != len(expected) || results[0].Document != "Intel" || math.Abs(results[0].Score-expected[0].Score) > 1e-9 {
+		t.Errorf("Expected search after reopening to match %v, got %v", expected, results)
+	}
+	if got := suggestDocuments(reopened.DocumentSuggest("int", 10)); len(got) != 1 || got[0] != "Intel" {
+		t.Errorf("Expected suggestions to be rebuilt after reopening, got %v", got)
+	}
+}
+
+// TestSegmentEngineSemantics checks that RelevantDocumentIDs and IDF are
+// the same whether postings are in memory or in segment files.
+func TestSegmentEngineSemantics(t *testing.T) {
+	engine := storage.NewSegmentEngine(NewMemoryEngine(), t.TempDir(), segment.Options{FlushThreshold: 16, MergeFactor: 2})
+	defer engine.Close()
+	if err := engine.CreateCollection("companies"); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	segmented, err := Open("companies", "", engine)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	memory := New("companies", "")
+
+	docs := []string{"IBM", "I.B.M. Corp", "IBM Corporation", "IBEX Systems", "Intel", "Intel Corporation", "Apple", "Applied Materials"}
+	for _, c := range []*Collection{memory, segmented} {
+		for _, doc := range docs {
+			if err := c.DocumentAdd(doc); err != nil {
+				t.Fatalf("DocumentAdd(%s) failed: %v", doc, err)
+			}
+		}
+		if err := c.DocumentRemove(*c.DocumentID("Apple")); err != nil {
+			t.Fatalf("DocumentRemove failed: %v", err)
+		}
+	}
+
+	for _, query := range []string{"ibm corp", "intel", "apple", "materials"} {
+		if want, got := memory.RelevantDocumentIDs(query), segmented.RelevantDocumentIDs(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected relevant documents %v for %q, got %v", want, query, got)
+		}
+	}
+	for _, token := range memory.lookupTable.Tokens() {
+		if want, got := memory.IDF(token), segmented.IDF(token); want != got {
+			t.Errorf("Expected IDF %v for %q, got %v", want, token, got)
+		}
+	}
+	if want, got := memory.lookupTable.Tokens(), segmented.lookupTable.Tokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected tokens %v, got %v", want, got)
+	}
+}
+
+// TestOpenReconcilesIndex checks that opening a collection repairs an index
+// left behind by a crash between writing documents and their postings.
+func TestOpenReconcilesIndex(t *testing.T) {
+	engine := NewMemoryEngine()
+	if err := engine.CreateCollection("companies"); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	companies, err := Open("companies", "", engine)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, doc := range []string{"IBM", "Intel", "Apple"} {
+		companies.DocumentAdd(doc)
+	}
+	tokens := companies.lookupTable.Tokens()
+
+	// Intel was stored but its postings were not, and a document that was
+	// never stored left its postings behind.
+	intel := *companies.DocumentID("Intel")
+	postings, _ := engine.Postings("companies")
+	postings.Remove(intel, companies.tokens("Intel"))
+	postings.Add(99, companies.tokens("Ghost Corp"))
+
+	reopened, err := Open("companies", "", engine)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if got := reopened.lookupTable.Tokens(); !reflect.DeepEqual(got, tokens) {
+		t.Errorf("Expected tokens %v after reopening, got %v", tokens, got)
+	}
+	if results := reopened.DocumentSearch("intel"); len(results) == 0 || results[0].ID != intel {
+		t.Errorf("Expected Intel to be found after reopening, got %v", results)
+	}
+	if ids := reopened.RelevantDocumentIDs("ghost"); len(ids) != 0 {
+		t.Errorf("Expected no postings of a document that is gone, got %v", ids)
+	}
+}
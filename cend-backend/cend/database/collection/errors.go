@@ -0,0 +1,10 @@
+package collection
+
+import "errors"
+
+// Changes to a collection fail with errors wrapping these, so that callers
+// can tell a missing document from a clash with an existing one.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
@@ -0,0 +1,132 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Explanation describes how a search score was computed, in the shape of
+// Lucene's explain output: a value, what it means, and the values it was
+// derived from.
+type Explanation struct {
+	Value       float64       `json:"value"`
+	Description string        `json:"description"`
+	Details     []Explanation `json:"details,omitempty"`
+}
+
+// DocumentExplain explains the score DocumentSearch gives the document with
+// docID for searchDoc. Each shared n-gram contributes the product of its
+// normalized TF-IDF weight in the query and in the document.
+func (c *Collection) DocumentExplain(searchDoc string, docID int) *Explanation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.documentExplain(searchDoc, docID)
+}
+
+// documentExplain explains a score; see DocumentExplain. The caller holds
+// the lock.
+func (c *Collection) documentExplain(searchDoc string, docID int) *Explanation {
+	doc := c.documents.Get(docID)
+	if doc == nil {
+		return nil
+	}
+	queryVector := c.termVector(searchDoc)
+	docVector := c.termVector(doc.String())
+
+	details := []Explanation{}
+	var score float64
+	for ngram, queryTFIDF := range queryVector.weights {
+		docTFIDF, exists := docVector.weights[ngram]
+		if !exists || queryVector.norm == 0 || docVector.norm == 0 {
+			continue
+		}
+		queryWeight := c.explainWeight("query", ngram, queryVector.tf[ngram], queryTFIDF, queryVector.norm)
+		docWeight := c.explainWeight("document", ngram, docVector.tf[ngram], docTFIDF, docVector.norm)
+		value := queryWeight.Value * docWeight.Value
+		score += value
+		details = append(details, Explanation{
+			Value:       value,
+			Description: fmt.Sprintf("weight(ngram=%q), product of:", ngram),
+			Details:     []Explanation{queryWeight, docWeight},
+		})
+	}
+	slices.SortFunc(details, func(a, b Explanation) int {
+		if a.Value != b.Value {
+			if a.Value > b.Value {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Description, b.Description)
+	})
+
+	return &Explanation{
+		Value:       score,
+		Description: fmt.Sprintf("score(doc=%d), cosine similarity of TF-IDF vectors, sum of:", docID),
+		Details:     details,
+	}
+}
+
+// ExplainResult explains the score of a result returned by
+// DocumentSearchWithOptions, including the variant it was collapsed from
+// and any preferred-term boost.
+func (c *Collection) ExplainResult(searchDoc string, result SearchResultScore, opts SearchOptions) *Explanation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.explainResult(searchDoc, result, opts)
+}
+
+// explainResult explains a result; see ExplainResult. The caller holds the
+// lock.
+func (c *Collection) explainResult(searchDoc string, result SearchResultScore, opts SearchOptions) *Explanation {
+	matchedID := result.ID
+	if result.MatchedVariant != nil {
+		matchedID = result.MatchedVariant.ID
+	}
+	explanation := c.documentExplain(searchDoc, matchedID)
+	if explanation == nil {
+		return nil
+	}
+
+	if result.MatchedVariant != nil {
+		explanation = &Explanation{
+			Value:       explanation.Value,
+			Description: fmt.Sprintf("collapsed into preferred document %d, best score among its variants, matched via variant %d:", result.ID, matchedID),
+			Details:     []Explanation{*explanation},
+		}
+	}
+	if doc := c.documents.Get(result.ID); opts.PreferredBoost > 0 && doc != nil && doc.Preferred() {
+		explanation = &Explanation{
+			Value:       explanation.Value * (1 + opts.PreferredBoost),
+			Description: "boosted score, product of:",
+			Details: []Explanation{
+				*explanation,
+				{Value: 1 + opts.PreferredBoost, Description: "boost, 1 + preferredBoost for a preferred document"},
+			},
+		}
+	}
+	return explanation
+}
+
+// explainWeight explains the normalized TF-IDF weight of ngram on one side
+// of the comparison.
+func (c *Collection) explainWeight(side, ngram string, tf int, tfidf, norm float64) Explanation {
+	docFreq := c.lookupTable.Count(ngram)
+	return Explanation{
+		Value:       tfidf / norm,
+		Description: fmt.Sprintf("%s weight, tf * idf / norm, computed from:", side),
+		Details: []Explanation{
+			{Value: float64(tf), Description: fmt.Sprintf("tf, occurrences of ngram in %s", side)},
+			{
+				Value:       c.idf(ngram),
+				Description: "idf, computed as log(docCount / docFreq) from:",
+				Details: []Explanation{
+					{Value: float64(c.documents.Length()), Description: "docCount, number of documents in collection"},
+					{Value: float64(docFreq), Description: "docFreq, number of documents containing ngram"},
+				},
+			},
+			{Value: norm, Description: fmt.Sprintf("norm, euclidean length of %s TF-IDF vector", side)},
+		},
+	}
+}
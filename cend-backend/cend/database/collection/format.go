@@ -0,0 +1,240 @@
+package collection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cend/database/storage"
+)
+
+// A saved collection is a text file. The first line is the header
+// "CEND-COLLECTION <version>", the second a JSON object of collection
+// metadata, and every following line one document as a JSON object, in ID
+// order. Tombstones are saved with the other documents.
+const (
+	// FormatVersion is the version Save writes.
+	FormatVersion = 3
+
+	formatMagic = "CEND-COLLECTION"
+)
+
+// rawCollection is a saved collection decoded without a schema, so that
+// migrations can reshape it freely.
+type rawCollection struct {
+	Meta      map[string]any
+	Documents []map[string]any
+}
+
+// migration upgrades a decoded collection by one format version.
+type migration func(c *rawCollection) error
+
+// migrations[v] upgrades a collection from format version v to v+1. A
+// change to the format bumps FormatVersion, registers the migration from
+// the previous version here and adds a golden file for that version under
+// testdata, so every version ever written can still be loaded.
+var migrations = map[int]migration{
+	// Version 2 added document versions.
+	1: func(c *rawCollection) error {
+		for _, doc := range c.Documents {
+			doc["version"] = 1
+		}
+		return nil
+	},
+	// Version 3 added tombstones, which older collections never have.
+	2: func(c *rawCollection) error {
+		return nil
+	},
+}
+
+// collectionMeta is the metadata line of the current format version.
+type collectionMeta struct {
+	Name   string `json:"name"`
+	NGram  int    `json:"ngram"`
+	NextID int    `json:"nextId"`
+}
+
+// Save writes the collection to its Path, replacing any earlier save.
+func (c *Collection) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := c.Encode(w); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
+
+// Encode writes the collection in the current format version.
+func (c *Collection) Encode(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, err := fmt.Fprintf(w, "%s %d\n", formatMagic, FormatVersion); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(collectionMeta{Name: c.name, NGram: c.ngram, NextID: c.store.NextID()}); err != nil {
+		return err
+	}
+	for _, id := range c.store.IDs() {
+		if doc := c.store.Get(id); doc != nil {
+			if err := enc.Encode(storage.RecordOf(doc)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IsSaved reports whether the file at path is a saved collection.
+func IsSaved(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(formatMagic)+1)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return string(header) == formatMagic+" "
+}
+
+// Load reads the collection saved at its Path into the collection, which
+// must be empty, upgrading it from older format versions.
+func (c *Collection) Load() error {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.Decode(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("loading %s: %w", c.Path, err)
+	}
+	return nil
+}
+
+// Decode reads a saved collection from r into the collection, which must
+// be empty. Documents keep their IDs, and IDs handed out before the save
+// are not reused.
+func (c *Collection) Decode(r io.Reader) error {
+	if c.store.Length() != 0 {
+		return fmt.Errorf("collection %s is not empty", c.name)
+	}
+	raw, version, err := decodeRaw(r)
+	if err != nil {
+		return err
+	}
+	if err := migrate(raw, version, FormatVersion, migrations); err != nil {
+		return err
+	}
+
+	var meta collectionMeta
+	if err := remarshal(raw.Meta, &meta); err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	if meta.NGram != c.ngram {
+		return fmt.Errorf("unsupported n-gram length %d", meta.NGram)
+	}
+	for i, fields := range raw.Documents {
+		var rec storage.Record
+		if err := remarshal(fields, &rec); err != nil {
+			return fmt.Errorf("document %d: %w", i+1, err)
+		}
+		if err := c.DocumentLoad(rec); err != nil {
+			return fmt.Errorf("document %d: %w", i+1, err)
+		}
+	}
+	return c.store.SetNextID(meta.NextID)
+}
+
+// decodeRaw reads the header and the JSON lines of a saved collection.
+func decodeRaw(r io.Reader) (*rawCollection, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("missing header")
+	}
+	magic, versionText, _ := strings.Cut(scanner.Text(), " ")
+	version, err := strconv.Atoi(versionText)
+	if magic != formatMagic || err != nil {
+		return nil, 0, fmt.Errorf("not a saved collection: header %q", scanner.Text())
+	}
+	if version < 1 || version > FormatVersion {
+		return nil, 0, fmt.Errorf("unsupported format version %d, newest supported is %d", version, FormatVersion)
+	}
+
+	raw := &rawCollection{Documents: []map[string]any{}}
+	for line := 2; scanner.Scan(); line++ {
+		var object map[string]any
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&object); err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if raw.Meta == nil {
+			raw.Meta = object
+		} else {
+			raw.Documents = append(raw.Documents, object)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if raw.Meta == nil {
+		return nil, 0, fmt.Errorf("missing metadata")
+	}
+	return raw, version, nil
+}
+
+// migrate upgrades raw from version from to version to using registry.
+func migrate(raw *rawCollection, from, to int, registry map[int]migration) error {
+	for v := from; v < to; v++ {
+		m, exists := registry[v]
+		if !exists {
+			return fmt.Errorf("no migration from format version %d", v)
+		}
+		if err := m(raw); err != nil {
+			return fmt.Errorf("migrating from format version %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// remarshal converts a decoded JSON object into v, rejecting fields v does
+// not have so that a missing migration is caught rather than data dropped.
+func remarshal(object map[string]any, v any) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
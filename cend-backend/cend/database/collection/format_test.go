@@ -0,0 +1,212 @@
+package collection
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cend/database/storage"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden file of the current format version")
+
+// formatCollection returns a collection exercising every saved field: a
+// document with fields, preferred links and a deleted last document.
+func formatCollection(t *testing.T) *Collection {
+	clock := now
+	t.Cleanup(func() { now = clock })
+	now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "I.B.M.", "Intel", "Apple"} {
+		if err := c.DocumentAdd(doc); err != nil {
+			t.Fatalf("DocumentAdd(%s) failed: %v", doc, err)
+		}
+	}
+	ibm := *c.DocumentID("IBM")
+	fields := map[string]string{"ticker": "IBM"}
+	c.DocumentAddFields(ibm, &fields)
+	c.DocumentSetPreferred(ibm, true, nil)
+	c.DocumentSetPreferred(*c.DocumentID("I.B.M."), false, []int{ibm})
+	c.DocumentRemove(*c.DocumentID("Apple"))
+	return c
+}
+
+func savedRecords(c *Collection) []storage.Record {
+	recs := []storage.Record{}
+	for _, id := range c.store.IDs() {
+		recs = append(recs, storage.RecordOf(c.store.Get(id)))
+	}
+	return recs
+}
+
+// TestFormatGolden checks that the current format is written byte for byte
+// as its golden file, so that any change to it is a deliberate one.
+func TestFormatGolden(t *testing.T) {
+	var buf bytes.Buffer
+	if err := formatCollection(t).Encode(&buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	golden := filepath.Join("testdata", fmt.Sprintf("collection-v%d.golden", FormatVersion))
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Error writing golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Error reading golden file: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Encode does not match %s; bump FormatVersion and add a migration if the format changed.\ngot:\n%s\nwant:\n%s", golden, buf.Bytes(), want)
+	}
+}
+
+// historical[v] adjusts the documents of formatCollection to what loading
+// the golden file of format version v or older gives, for data version v
+// did not record.
+var historical = map[int]func(recs []storage.Record) []storage.Record{
+	// Every document starts at version 1.
+	1: func(recs []storage.Record) []storage.Record {
+		for i := range recs {
+			recs[i].Version = 1
+		}
+		return recs
+	},
+	// Deleted documents were removed outright.
+	2: func(recs []storage.Record) []storage.Record {
+		return slices.DeleteFunc(recs, func(rec storage.Record) bool { return rec.Deleted != nil })
+	},
+}
+
+// TestFormatHistoricalVersions loads the golden file of every format
+// version ever written and checks it upgrades to the same collection.
+func TestFormatHistoricalVersions(t *testing.T) {
+	want := formatCollection(t)
+	for version := 1; version <= FormatVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := filepath.Join("testdata", fmt.Sprintf("collection-v%d.golden", version))
+			c := New("companies", path)
+			if err := c.Load(); err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			wantRecords := savedRecords(want)
+			for v := version; v < FormatVersion; v++ {
+				if adjust, exists := historical[v]; exists {
+					wantRecords = adjust(wantRecords)
+				}
+			}
+			if got := savedRecords(c); !reflect.DeepEqual(got, wantRecords) {
+				t.Errorf("Expected documents %v, got %v", wantRecords, got)
+			}
+			if !Equal(c, want) {
+				t.Errorf("Expected the index to be rebuilt on load")
+			}
+			if got := c.documents.NextID(); got != want.documents.NextID() {
+				t.Errorf("Expected next ID %d, got %d", want.documents.NextID(), got)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "companies")
+	c := formatCollection(t)
+	c.Path = path
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := New("companies", path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if results := loaded.DocumentSearch("intel"); len(results) == 0 || results[0].Document != "Intel" {
+		t.Errorf("Expected a loaded collection to be searchable, got %v", results)
+	}
+	// The removed last document's ID is not handed out again.
+	loaded.DocumentAdd("Apple")
+	if id := *loaded.DocumentID("Apple"); id != 5 {
+		t.Errorf("Expected a new document to get ID 5, got %d", id)
+	}
+	if err := loaded.Load(); err == nil {
+		t.Errorf("Expected loading into a non-empty collection to fail")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// A synthetic history: v1 called the document "text", v2 nested the
+	// n-gram length under "index".
+	registry := map[int]migration{
+		1: func(c *rawCollection) error {
+			for _, doc := range c.Documents {
+				doc["document"] = doc["text"]
+				delete(doc, "text")
+			}
+			return nil
+		},
+		2: func(c *rawCollection) error {
+			index, ok := c.Meta["index"].(map[string]any)
+			if !ok {
+				return fmt.Errorf("missing index")
+			}
+			c.Meta["ngram"] = index["ngram"]
+			delete(c.Meta, "index")
+			return nil
+		},
+	}
+	raw := &rawCollection{
+		Meta:      map[string]any{"index": map[string]any{"ngram": 3}},
+		Documents: []map[string]any{{"id": 1, "text": "IBM"}},
+	}
+	if err := migrate(raw, 1, 3, registry); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	wantMeta := map[string]any{"ngram": 3}
+	wantDocs := []map[string]any{{"id": 1, "document": "IBM"}}
+	if !reflect.DeepEqual(raw.Meta, wantMeta) || !reflect.DeepEqual(raw.Documents, wantDocs) {
+		t.Errorf("Expected %v %v, got %v %v", wantMeta, wantDocs, raw.Meta, raw.Documents)
+	}
+
+	if err := migrate(raw, 3, 4, registry); err == nil || !strings.Contains(err.Error(), "no migration from format version 3") {
+		t.Errorf("Expected a missing migration to fail, got %v", err)
+	}
+	if err := migrate(&rawCollection{Meta: map[string]any{}}, 2, 3, registry); err == nil || !strings.Contains(err.Error(), "missing index") {
+		t.Errorf("Expected a failed migration to fail, got %v", err)
+	}
+}
+
+func TestDecodeRejectsBadFiles(t *testing.T) {
+	var buf bytes.Buffer
+	formatCollection(t).Encode(&buf)
+	saved := buf.String()
+	header := fmt.Sprintf("%s %d\n", formatMagic, FormatVersion)
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"empty", "", "missing header"},
+		{"not a collection", "hello\n", "not a saved collection"},
+		{"newer version", strings.Replace(saved, header, fmt.Sprintf("%s %d\n", formatMagic, FormatVersion+1), 1), "unsupported format version"},
+		{"missing metadata", header, "missing metadata"},
+		{"corrupt line", saved + "{\n", fmt.Sprintf("line %d", strings.Count(saved, "\n")+1)},
+		{"unknown field", strings.Replace(saved, `"id":1,`, `"id":1,"colour":"blue",`, 1), "unknown field"},
+		{"duplicate document", saved + saved[strings.Index(saved, `{"id":1`):], "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New("companies", "").Decode(strings.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
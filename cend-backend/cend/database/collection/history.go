@@ -0,0 +1,201 @@
+package collection
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"cend/database/storage"
+)
+
+// Operations recorded in a collection's history.
+const (
+	OpAdd       = "add"
+	OpRemove    = "remove"
+	OpUpdate    = "update"
+	OpFields    = "fields"
+	OpPreferred = "preferred"
+	OpMerge     = "merge"
+	OpSplit     = "split"
+	OpRestore   = "restore"
+	OpPurge     = "purge"
+)
+
+// Change is one entry of a collection's history: an operation, who made
+// it and when, and the documents it touched before and after. A document
+// missing from Before was created by the change, and one missing from After
+// was removed by it.
+type Change struct {
+	Seq       int              `json:"seq"`
+	Time      time.Time        `json:"time"`
+	Actor     string           `json:"actor"`
+	Op        string           `json:"op"`
+	Documents []int            `json:"documents"`
+	Before    []storage.Record `json:"before"`
+	After     []storage.Record `json:"after"`
+	// Undoes is the Seq of the change an undo reverted.
+	Undoes int `json:"undoes,omitempty"`
+}
+
+// now is the clock changes are stamped with.
+var now = time.Now
+
+// Editor changes a collection on behalf of an actor, who is recorded with
+// every change in the collection's history.
+type Editor struct {
+	c     *Collection
+	actor string
+}
+
+// As returns an editor making changes on behalf of actor. The Collection's
+// own methods record changes with no actor.
+func (c *Collection) As(actor string) *Editor {
+	return &Editor{c: c, actor: actor}
+}
+
+// OpenHistory loads the history kept in the file at path and appends every
+// later change to it, so the history outlives the process whatever storage
+// engine keeps the documents. Without it, history is kept only in memory.
+func (c *Collection) OpenHistory(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.historyLog != nil || len(c.history) > 0 {
+		return fmt.Errorf("collection %s already has a history", c.name)
+	}
+
+	history := []Change{}
+	f, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			var change Change
+			if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+				f.Close()
+				return fmt.Errorf("reading history %s: line %d: %w", path, line, err)
+			}
+			history = append(history, change)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading history %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.history = history
+	c.historyLog = log
+	return nil
+}
+
+// Close closes the collection's history file, if any.
+func (c *Collection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.historyLog == nil {
+		return nil
+	}
+	err := c.historyLog.Close()
+	c.historyLog = nil
+	return err
+}
+
+// History returns the changes that touched a document, oldest first.
+func (c *Collection) History(docId int) []Change {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	changes := []Change{}
+	for _, change := range c.history {
+		if slices.Contains(change.Documents, docId) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// HistoryFeed returns up to limit changes to the collection made after the
+// change numbered after, oldest first. Pass the Seq of the last change seen
+// to read on from it.
+func (c *Collection) HistoryFeed(after, limit int) []Change {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	start, _ := slices.BinarySearchFunc(c.history, after+1, func(change Change, seq int) int {
+		return change.Seq - seq
+	})
+	end := min(start+limit, len(c.history))
+	return slices.Clone(c.history[start:end])
+}
+
+// record appends a change to the history. The caller holds the lock.
+func (c *Collection) record(actor, op string, before, after []storage.Record) {
+	c.recordChange(Change{Actor: actor, Op: op, Before: before, After: after})
+}
+
+// recordChange numbers, stamps and appends a change to the history, and
+// returns it. The caller holds the lock.
+func (c *Collection) recordChange(change Change) Change {
+	if change.Before == nil {
+		change.Before = []storage.Record{}
+	}
+	if change.After == nil {
+		change.After = []storage.Record{}
+	}
+	ids := make(map[int]struct{})
+	for _, rec := range slices.Concat(change.Before, change.After) {
+		ids[rec.ID] = struct{}{}
+	}
+	change.Seq = len(c.history) + 1
+	change.Time = now().UTC()
+	change.Documents = slices.Sorted(maps.Keys(ids))
+	c.history = append(c.history, change)
+	if !c.holdLog {
+		c.writeChange(change)
+	}
+	return change
+}
+
+// writeChange appends a change to the history file, if any.
+func (c *Collection) writeChange(change Change) {
+	if c.historyLog == nil {
+		return
+	}
+	data, err := json.Marshal(change)
+	if err == nil {
+		_, err = c.historyLog.Write(append(data, '\n'))
+	}
+	if err != nil {
+		slog.Error("Could not write history", "collection", c.name, "seq", change.Seq, "error", err)
+	}
+}
+
+// records returns copies of the stored form of the documents with the given
+// IDs that exist, tombstones included, sharing nothing with the documents
+// themselves.
+func (c *Collection) records(ids ...int) []storage.Record {
+	recs := []storage.Record{}
+	for _, id := range ids {
+		doc := c.store.Get(id)
+		if doc == nil {
+			continue
+		}
+		rec := storage.RecordOf(doc)
+		rec.Fields = maps.Clone(rec.Fields)
+		rec.PreferredDocuments = slices.Clone(rec.PreferredDocuments)
+		recs = append(recs, rec)
+	}
+	return recs
+}
@@ -0,0 +1,94 @@
+package collection
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// ops returns the operations of changes in order.
+func ops(changes []Change) []string {
+	names := []string{}
+	for _, change := range changes {
+		names = append(names, change.Op)
+	}
+	return names
+}
+
+func TestHistory(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	stamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return stamp }
+
+	c := New("companies", "")
+	alice := c.As("alice")
+	alice.DocumentAdd("IBM")
+	alice.DocumentAdd("I.B.M.")
+	ibm, variant := *c.DocumentID("IBM"), *c.DocumentID("I.B.M.")
+	alice.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM"})
+	c.As("bob").DocumentSetPreferred(variant, false, []int{ibm})
+	c.As("bob").DocumentUpdate(ibm, "IBM Corp", nil)
+	c.As("carol").DocumentMerge(variant, ibm, AnyVersion, AnyVersion)
+
+	feed := c.HistoryFeed(0, 100)
+	if want := []string{OpAdd, OpAdd, OpFields, OpPreferred, OpUpdate, OpMerge}; !reflect.DeepEqual(ops(feed), want) {
+		t.Fatalf("Expected operations %v, got %v", want, ops(feed))
+	}
+	for i, change := range feed {
+		if change.Seq != i+1 || !change.Time.Equal(stamp) {
+			t.Errorf("Expected change %d stamped %v, got seq %d at %v", i+1, stamp, change.Seq, change.Time)
+		}
+	}
+
+	update := feed[4]
+	if update.Actor != "bob" || update.Before[0].Document != "IBM" || update.After[0].Document != "IBM Corp" {
+		t.Errorf("Expected bob's update from IBM to IBM Corp, got %+v", update)
+	}
+	// Later changes do not reach back into recorded states.
+	if fields := feed[2].After[0].Fields; !reflect.DeepEqual(fields, map[string]string{"ticker": "IBM"}) {
+		t.Errorf("Expected recorded fields to be kept, got %v", fields)
+	}
+	merge := feed[5]
+	if !slices.Equal(merge.Documents, []int{ibm, variant}) || len(merge.Before) != 2 || len(merge.After) != 1 {
+		t.Errorf("Expected the merge to record both documents before and the target after, got %+v", merge)
+	}
+
+	if got := ops(c.History(variant)); !reflect.DeepEqual(got, []string{OpAdd, OpPreferred, OpMerge}) {
+		t.Errorf("Expected the variant's history [add preferred merge], got %v", got)
+	}
+	if page := c.HistoryFeed(4, 1); len(page) != 1 || page[0].Seq != 5 {
+		t.Errorf("Expected the page after change 4 to hold change 5, got %v", page)
+	}
+	if page := c.HistoryFeed(6, 10); len(page) != 0 {
+		t.Errorf("Expected no changes after the last, got %v", page)
+	}
+}
+
+func TestOpenHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "companies.jsonl")
+	c := New("companies", "")
+	if err := c.OpenHistory(path); err != nil {
+		t.Fatalf("OpenHistory failed: %v", err)
+	}
+	c.As("alice").DocumentAdd("IBM")
+	c.As("alice").DocumentAdd("Intel")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reopened := New("companies", "")
+	if err := reopened.OpenHistory(path); err != nil {
+		t.Fatalf("OpenHistory failed: %v", err)
+	}
+	defer reopened.Close()
+	reopened.DocumentAdd("Apple")
+	feed := reopened.HistoryFeed(0, 10)
+	if len(feed) != 3 || feed[0].Actor != "alice" || feed[1].After[0].Document != "Intel" || feed[2].Seq != 3 {
+		t.Errorf("Expected the saved history to be read back and continued, got %+v", feed)
+	}
+	if err := reopened.OpenHistory(path); err == nil {
+		t.Errorf("Expected opening a second history to fail")
+	}
+}
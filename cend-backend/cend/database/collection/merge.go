@@ -0,0 +1,216 @@
+package collection
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"cend/database/collection/documents"
+)
+
+// DocumentMerge folds the source document into the target, as when two
+// documents turn out to name the same entity. The target gains the fields
+// of the source it does not already have and the source's preferred
+// documents, and becomes preferred if the source was. Documents that listed
+// the source as a preferred document list the target instead. The source
+// is then removed. Each document is checked against its expected version,
+// which may be AnyVersion.
+func (c *Collection) DocumentMerge(sourceId, targetId, sourceVersion, targetVersion int) error {
+	return c.As("").DocumentMerge(sourceId, targetId, sourceVersion, targetVersion)
+}
+
+// DocumentMerge folds the source document into the target; see
+// Collection.DocumentMerge.
+func (e *Editor) DocumentMerge(sourceId, targetId, sourceVersion, targetVersion int) error {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sourceId == targetId {
+		return fmt.Errorf("cannot merge document %d into itself", sourceId)
+	}
+	source := c.documents.Get(sourceId)
+	if source == nil {
+		return fmt.Errorf("document %d %w", sourceId, ErrNotFound)
+	}
+	target := c.documents.Get(targetId)
+	if target == nil {
+		return fmt.Errorf("document %d %w", targetId, ErrNotFound)
+	}
+	if err := checkVersion(source, sourceVersion); err != nil {
+		return err
+	}
+	if err := checkVersion(target, targetVersion); err != nil {
+		return err
+	}
+
+	fields := make(map[string]string)
+	if f := source.Fields(); f != nil {
+		maps.Copy(fields, *f)
+	}
+	if f := target.Fields(); f != nil {
+		maps.Copy(fields, *f)
+	}
+	preferredDocs := []int{}
+	for _, id := range slices.Concat(target.PreferredDocuments(), source.PreferredDocuments()) {
+		if id != sourceId && id != targetId && !slices.Contains(preferredDocs, id) {
+			preferredDocs = append(preferredDocs, id)
+		}
+	}
+	variants := []int{}
+	for _, id := range c.documents.IDs() {
+		if id == sourceId || id == targetId {
+			continue
+		}
+		if doc := c.documents.Get(id); doc != nil && slices.Contains(doc.PreferredDocuments(), sourceId) {
+			variants = append(variants, id)
+		}
+	}
+	ids := slices.Concat([]int{sourceId, targetId}, variants)
+	before := c.records(ids...)
+
+	err := c.applyOrRollback(ids, before, func() error {
+		for _, id := range variants {
+			doc := copyDocument(c.documents.Get(id))
+			doc.SetPreferredDocuments(replaceLink(doc.PreferredDocuments(), sourceId, targetId))
+			doc.SetVersion(doc.Version() + 1)
+			if err := c.documents.Put(doc); err != nil {
+				return err
+			}
+		}
+
+		merged := copyDocument(target)
+		merged.SetFields(&fields)
+		merged.SetPreferred(target.Preferred() || source.Preferred())
+		merged.SetPreferredDocuments(preferredDocs)
+		merged.SetVersion(target.Version() + 1)
+		if err := c.documents.Put(merged); err != nil {
+			return err
+		}
+		c.resuggest(targetId)
+		return c.documentRemove(sourceId, AnyVersion)
+	})
+	if err != nil {
+		return err
+	}
+	c.record(e.actor, OpMerge, before, c.records(slices.Concat([]int{targetId}, variants)...))
+	return nil
+}
+
+// DocumentSplit splits a new document off the source, as when one document
+// turns out to name two entities, and returns the new document's ID. The
+// new document gets the text document and the source's fields named in
+// fieldKeys, which the source loses. It is preferred if the source is and
+// keeps the source's preferred documents. The variants, documents that list
+// the source as a preferred document, list the new document instead. The
+// source is checked against its expected version, which may be AnyVersion.
+func (c *Collection) DocumentSplit(sourceId, version int, document string, fieldKeys []string, variants []int) (int, error) {
+	return c.As("").DocumentSplit(sourceId, version, document, fieldKeys, variants)
+}
+
+// DocumentSplit splits a new document off the source; see
+// Collection.DocumentSplit.
+func (e *Editor) DocumentSplit(sourceId, version int, document string, fieldKeys []string, variants []int) (int, error) {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	source := c.documents.Get(sourceId)
+	if source == nil {
+		return 0, fmt.Errorf("document %d %w", sourceId, ErrNotFound)
+	}
+	if err := checkVersion(source, version); err != nil {
+		return 0, err
+	}
+	if document == "" {
+		return 0, fmt.Errorf("cannot split off an empty document")
+	}
+	if c.documentExists(document) {
+		return 0, fmt.Errorf("cannot add document that %w: document=%s", ErrExists, document)
+	}
+	sourceFields := make(map[string]string)
+	if f := source.Fields(); f != nil {
+		maps.Copy(sourceFields, *f)
+	}
+	fields := make(map[string]string)
+	for _, key := range fieldKeys {
+		value, exists := sourceFields[key]
+		if !exists {
+			return 0, fmt.Errorf("document %d has no field %s", sourceId, key)
+		}
+		fields[key] = value
+		delete(sourceFields, key)
+	}
+	for _, id := range variants {
+		doc := c.documents.Get(id)
+		if doc == nil || id == sourceId || !slices.Contains(doc.PreferredDocuments(), sourceId) {
+			return 0, fmt.Errorf("document %d is not a variant of document %d", id, sourceId)
+		}
+	}
+	before := c.records(slices.Concat([]int{sourceId}, variants)...)
+
+	x := nGramFrequency(stringNormalize(document), c.ngram)
+	isPreferred := source.Preferred()
+	preferredDocs := slices.Clone(source.PreferredDocuments())
+	docID := c.documents.NextID()
+	err := c.applyOrRollback(slices.Concat([]int{sourceId, docID}, variants), before, func() error {
+		split := documents.NewDocument(document, docID, &x, &isPreferred, &fields, &preferredDocs)
+		if err := c.documents.Put(split); err != nil {
+			return err
+		}
+		if err := c.index(document, docID); err != nil {
+			return err
+		}
+
+		remaining := copyDocument(source)
+		remaining.SetFields(&sourceFields)
+		remaining.SetVersion(source.Version() + 1)
+		if err := c.documents.Put(remaining); err != nil {
+			return err
+		}
+		for _, id := range variants {
+			doc := copyDocument(c.documents.Get(id))
+			doc.SetPreferredDocuments(replaceLink(doc.PreferredDocuments(), sourceId, docID))
+			doc.SetVersion(doc.Version() + 1)
+			if err := c.documents.Put(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.record(e.actor, OpSplit, before, c.records(slices.Concat([]int{sourceId, docID}, variants)...))
+	return docID, nil
+}
+
+// replaceLink returns links with from replaced by to, keeping the first
+// occurrence of to.
+func replaceLink(links []int, from, to int) []int {
+	replaced := []int{}
+	for _, id := range links {
+		if id == from {
+			id = to
+		}
+		if !slices.Contains(replaced, id) {
+			replaced = append(replaced, id)
+		}
+	}
+	return replaced
+}
+
+// copyDocument returns a copy of doc that shares none of its fields,
+// preferred flag or links, so that changing either leaves the other intact.
+func copyDocument(doc *documents.Document) *documents.Document {
+	fields := make(map[string]string)
+	if f := doc.Fields(); f != nil {
+		maps.Copy(fields, *f)
+	}
+	isPreferred := doc.Preferred()
+	preferredDocs := slices.Clone(doc.PreferredDocuments())
+	copied := documents.NewDocument(doc.String(), doc.ID(), doc.TokenFrequency(), &isPreferred, &fields, &preferredDocs)
+	copied.SetVersion(doc.Version())
+	copied.SetDeleted(doc.Deleted())
+	return copied
+}
@@ -0,0 +1,207 @@
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	"cend/database/collection/documents"
+	"cend/database/storage"
+)
+
+// failingStore is a document store that fails the one Put made after puts
+// more have succeeded.
+type failingStore struct {
+	storage.DocumentStore
+	puts int
+}
+
+func (s *failingStore) Put(doc *documents.Document) error {
+	s.puts--
+	if s.puts == -1 {
+		return fmt.Errorf("disk full")
+	}
+	return s.DocumentStore.Put(doc)
+}
+
+// failPut makes the Put to c after puts more fail, and returns a function
+// that puts c's own store back.
+func failPut(c *Collection, puts int) func() {
+	store := c.store
+	c.setStore(&failingStore{DocumentStore: store, puts: puts})
+	return func() { c.setStore(store) }
+}
+
+func TestDocumentMerge(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "International Business Machines", "I.B.M.", "Big Blue", "Intel"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, longName := *c.DocumentID("IBM"), *c.DocumentID("International Business Machines")
+	variant, nickname, intel := *c.DocumentID("I.B.M."), *c.DocumentID("Big Blue"), *c.DocumentID("Intel")
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM", "country": "US"})
+	c.DocumentAddFields(longName, &map[string]string{"country": "USA", "founded": "1911"})
+	c.DocumentSetPreferred(longName, true, []int{intel})
+	c.DocumentSetPreferred(variant, false, []int{longName})
+	c.DocumentSetPreferred(nickname, false, []int{ibm, longName})
+
+	if err := c.DocumentMerge(longName, ibm, AnyVersion, AnyVersion); err != nil {
+		t.Fatalf("DocumentMerge failed: %v", err)
+	}
+
+	if c.documents.Get(longName) != nil || c.DocumentExists("International Business Machines") {
+		t.Errorf("Expected the source to be removed")
+	}
+	target := c.documents.Get(ibm)
+	wantFields := map[string]string{"ticker": "IBM", "country": "US", "founded": "1911"}
+	if got := *target.Fields(); !maps.Equal(got, wantFields) {
+		t.Errorf("Expected target fields %v, got %v", wantFields, got)
+	}
+	if !target.Preferred() || !slices.Equal(target.PreferredDocuments(), []int{intel}) {
+		t.Errorf("Expected the target to be preferred with links [%d], got %v %v", intel, target.Preferred(), target.PreferredDocuments())
+	}
+	if got := c.documents.Get(variant).PreferredDocuments(); !slices.Equal(got, []int{ibm}) {
+		t.Errorf("Expected links to the source to point at the target, got %v", got)
+	}
+	if got := c.documents.Get(nickname).PreferredDocuments(); !slices.Equal(got, []int{ibm}) {
+		t.Errorf("Expected repointed links not to repeat the target, got %v", got)
+	}
+	if v := c.documents.Get(variant).Version(); v != 3 {
+		t.Errorf("Expected a repointed document at version 3, got %v", v)
+	}
+	if results := c.DocumentSearch("international business machines"); len(results) != 0 && results[0].ID == longName {
+		t.Errorf("Expected the source to be unindexed, got %v", results)
+	}
+}
+
+func TestDocumentMergeRejects(t *testing.T) {
+	c := New("companies", "")
+	c.DocumentAdd("IBM")
+	c.DocumentAdd("I.B.M.")
+	ibm, variant := *c.DocumentID("IBM"), *c.DocumentID("I.B.M.")
+
+	var conflict *VersionConflictError
+	if err := c.DocumentMerge(variant, ibm, AnyVersion, 2); !errors.As(err, &conflict) || conflict.ID != ibm {
+		t.Errorf("Expected a version conflict on the target, got %v", err)
+	}
+	if err := c.DocumentMerge(variant, ibm, 2, AnyVersion); !errors.As(err, &conflict) || conflict.ID != variant {
+		t.Errorf("Expected a version conflict on the source, got %v", err)
+	}
+	if err := c.DocumentMerge(ibm, ibm, AnyVersion, AnyVersion); err == nil {
+		t.Errorf("Expected merging a document into itself to fail")
+	}
+	if err := c.DocumentMerge(42, ibm, AnyVersion, AnyVersion); err == nil {
+		t.Errorf("Expected merging a missing document to fail")
+	}
+	if c.documents.Length() != 2 {
+		t.Errorf("Expected rejected merges to change nothing")
+	}
+}
+
+func TestDocumentSplit(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"Mercury", "Mercury Records", "Mercury Planet", "Hermes"} {
+		c.DocumentAdd(doc)
+	}
+	mercury, records, planet, hermes := *c.DocumentID("Mercury"), *c.DocumentID("Mercury Records"), *c.DocumentID("Mercury Planet"), *c.DocumentID("Hermes")
+	c.DocumentAddFields(mercury, &map[string]string{"label": "Universal", "orbit": "88 days"})
+	c.DocumentSetPreferred(mercury, true, []int{hermes})
+	c.DocumentSetPreferred(records, false, []int{mercury})
+	c.DocumentSetPreferred(planet, false, []int{mercury})
+
+	id, err := c.DocumentSplit(mercury, 3, "Mercury (planet)", []string{"orbit"}, []int{planet})
+	if err != nil {
+		t.Fatalf("DocumentSplit failed: %v", err)
+	}
+	split := c.documents.Get(id)
+	if split == nil || split.String() != "Mercury (planet)" || !c.DocumentExists("Mercury (planet)") {
+		t.Fatalf("Expected the new document to be added, got %v", split)
+	}
+	if got := *split.Fields(); !maps.Equal(got, map[string]string{"orbit": "88 days"}) {
+		t.Errorf("Expected the split fields on the new document, got %v", got)
+	}
+	if !split.Preferred() || !slices.Equal(split.PreferredDocuments(), []int{hermes}) {
+		t.Errorf("Expected the new document to keep the source's preferred flag and links, got %v %v", split.Preferred(), split.PreferredDocuments())
+	}
+	source := c.documents.Get(mercury)
+	if got := *source.Fields(); !maps.Equal(got, map[string]string{"label": "Universal"}) || source.Version() != 4 {
+		t.Errorf("Expected the source to lose the split fields at version 4, got %v at %d", got, source.Version())
+	}
+	if got := c.documents.Get(planet).PreferredDocuments(); !slices.Equal(got, []int{id}) {
+		t.Errorf("Expected the moved variant to link to the new document, got %v", got)
+	}
+	if got := c.documents.Get(records).PreferredDocuments(); !slices.Equal(got, []int{mercury}) {
+		t.Errorf("Expected other variants to keep their links, got %v", got)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		version  int
+		document string
+		fields   []string
+		variants []int
+	}{
+		{"stale version", 3, "Mercury (god)", nil, nil},
+		{"existing document", AnyVersion, "Hermes", nil, nil},
+		{"missing field", AnyVersion, "Mercury (god)", []string{"orbit"}, nil},
+		{"not a variant", AnyVersion, "Mercury (god)", nil, []int{hermes}},
+	} {
+		if _, err := c.DocumentSplit(mercury, tt.version, tt.document, tt.fields, tt.variants); err == nil {
+			t.Errorf("%s: expected DocumentSplit to fail", tt.name)
+		}
+	}
+	if c.documents.Length() != 5 {
+		t.Errorf("Expected rejected splits to change nothing")
+	}
+}
+
+// TestMergeSplitRollBack checks that a merge or split whose writes fail
+// partway changes nothing.
+func TestMergeSplitRollBack(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "International Business Machines", "I.B.M.", "Big Blue"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, longName := *c.DocumentID("IBM"), *c.DocumentID("International Business Machines")
+	variant, nickname := *c.DocumentID("I.B.M."), *c.DocumentID("Big Blue")
+	c.DocumentAddFields(longName, &map[string]string{"founded": "1911"})
+	c.DocumentSetPreferred(variant, false, []int{longName})
+	c.DocumentSetPreferred(nickname, false, []int{longName})
+	before := savedRecords(c)
+	list := c.DocumentList()
+
+	for puts := range 3 {
+		restore := failPut(c, puts)
+		err := c.DocumentMerge(longName, ibm, AnyVersion, AnyVersion)
+		restore()
+		if err == nil {
+			t.Fatalf("Expected the merge to fail after %d writes", puts)
+		}
+		if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+			t.Errorf("Expected a merge failing after %d writes to change nothing, got %v", puts, got)
+		}
+	}
+	for puts := range 3 {
+		restore := failPut(c, puts)
+		_, err := c.DocumentSplit(longName, AnyVersion, "IBM (1911)", []string{"founded"}, []int{variant, nickname})
+		restore()
+		if err == nil {
+			t.Fatalf("Expected the split to fail after %d writes", puts)
+		}
+		if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+			t.Errorf("Expected a split failing after %d writes to change nothing, got %v", puts, got)
+		}
+	}
+	if got := c.DocumentList(); !reflect.DeepEqual(got, list) || c.DocumentExists("IBM (1911)") {
+		t.Errorf("Expected the index to be rolled back to %v, got %v", list, got)
+	}
+	if results := c.DocumentSearch("international business"); len(results) == 0 || results[0].ID != longName {
+		t.Errorf("Expected the source to be searchable again, got %v", results)
+	}
+	if len(c.HistoryFeed(0, 100)) != 7 {
+		t.Errorf("Expected failed merges and splits not to be recorded, got %v", ops(c.HistoryFeed(0, 100)))
+	}
+}
@@ -0,0 +1,89 @@
+package collection
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// DocumentIDs stores the locations of documents containing a specific token.
+type DocumentIDs struct {
+	count  int              // number of times the token is found across documents
+	docIDs map[int]struct{} // set of document IDs where the token appears.
+}
+
+// memoryPostings is the in-memory storage.PostingsStore, mapping each token
+// to the documents containing it.
+type memoryPostings map[string]*DocumentIDs
+
+// removeDocID removes the specified docID from DocumentIDs.
+func (IDs *DocumentIDs) removeDocID(docID int) error {
+
+	// Check if docID exists and delete it if found
+	if _, exists := IDs.docIDs[docID]; exists {
+		delete(IDs.docIDs, docID)
+		IDs.count--
+
+		if IDs.count < 0 {
+			return fmt.Errorf("docCount is negative")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("docID %d not found", docID)
+}
+
+// addDocID adds a new docID to DocumentIDs.
+func (IDs *DocumentIDs) addDocID(docID int) error {
+	if _, exists := IDs.docIDs[docID]; exists {
+		return fmt.Errorf("docID %d already exists", docID)
+	}
+	IDs.count++
+	IDs.docIDs[docID] = struct{}{}
+	return nil
+}
+
+func (p *memoryPostings) Add(docID int, tokens []string) error {
+	for _, token := range tokens {
+		ids, exists := (*p)[token]
+		if !exists {
+			ids = &DocumentIDs{docIDs: make(map[int]struct{})}
+			(*p)[token] = ids
+		}
+		ids.addDocID(docID)
+	}
+	return nil
+}
+
+func (p *memoryPostings) Remove(docID int, tokens []string) error {
+	for _, token := range tokens {
+		ids, exists := (*p)[token]
+		if !exists {
+			continue
+		}
+		ids.removeDocID(docID)
+		if len(ids.docIDs) == 0 {
+			delete(*p, token)
+		}
+	}
+	return nil
+}
+
+func (p *memoryPostings) DocIDs(token string) []int {
+	ids, exists := (*p)[token]
+	if !exists {
+		return []int{}
+	}
+	return slices.Collect(maps.Keys(ids.docIDs))
+}
+
+func (p *memoryPostings) Count(token string) int {
+	if ids, exists := (*p)[token]; exists {
+		return ids.count
+	}
+	return 0
+}
+
+func (p *memoryPostings) Tokens() []string {
+	return slices.Sorted(maps.Keys(*p))
+}
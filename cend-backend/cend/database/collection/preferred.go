@@ -0,0 +1,64 @@
+package collection
+
+// VariantMatch records the variant that matched a search when the result
+// was collapsed into the variant's preferred document.
+type VariantMatch struct {
+	ID       int     `json:"id"`
+	Document string  `json:"document"`
+	Score    float64 `json:"score"`
+}
+
+// preferredTarget returns the ID of the preferred document a variant should
+// be collapsed into, or docID itself if it is preferred, has no preferred
+// documents, or all of them have been removed.
+func (c *Collection) preferredTarget(docID int) int {
+	doc := c.documents.Get(docID)
+	if doc == nil || doc.Preferred() {
+		return docID
+	}
+	for _, preferredID := range doc.PreferredDocuments() {
+		if c.documents.Get(preferredID) != nil {
+			return preferredID
+		}
+	}
+	return docID
+}
+
+// collapseVariants merges results for variants into a single result for
+// their preferred document. The collapsed result keeps the best score among
+// the preferred document and its variants, and records the variant when one
+// of them scored best.
+func (c *Collection) collapseVariants(searchResult []SearchResultScore) []SearchResultScore {
+	collapsed := make(map[int]int) // preferred document ID -> index in results
+	results := []SearchResultScore{}
+	for _, r := range searchResult {
+		target := c.preferredTarget(r.ID)
+		candidate := r
+		if target != r.ID {
+			candidate = SearchResultScore{
+				ID:             target,
+				Document:       c.documents.Get(target).String(),
+				Score:          r.Score,
+				MatchedVariant: &VariantMatch{ID: r.ID, Document: r.Document, Score: r.Score},
+			}
+		}
+
+		i, exists := collapsed[target]
+		if !exists {
+			collapsed[target] = len(results)
+			results = append(results, candidate)
+		} else if candidate.Score > results[i].Score {
+			results[i] = candidate
+		}
+	}
+	return results
+}
+
+// boostPreferred multiplies the score of every preferred result by 1+boost.
+func (c *Collection) boostPreferred(searchResult []SearchResultScore, boost float64) {
+	for i := range searchResult {
+		if doc := c.documents.Get(searchResult[i].ID); doc != nil && doc.Preferred() {
+			searchResult[i].Score *= 1 + boost
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package collection
+
+import (
+	"math"
+	"testing"
+)
+
+// preferredCollection holds "IBM" as the preferred term with two variants
+// pointing at it, plus an unrelated document.
+func preferredCollection() *Collection {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"IBM", "I.B.M. Corp", "IBM Corporation", "IBEX Systems"} {
+		collection.DocumentAdd(doc)
+	}
+	ibm := *collection.DocumentID("IBM")
+	collection.documents.Get(ibm).SetPreferred(true)
+	for _, variant := range []string{"I.B.M. Corp", "IBM Corporation"} {
+		collection.documents.Get(*collection.DocumentID(variant)).SetPreferredDocuments([]int{ibm})
+	}
+	return collection
+}
+
+func TestDocumentSearchCollapseVariants(t *testing.T) {
+	collection := preferredCollection()
+	ibm := *collection.DocumentID("IBM")
+
+	searchDoc := "ibm corporation"
+	results := collection.DocumentSearchWithOptions(searchDoc, SearchOptions{CollapseVariants: true})
+
+	seen := make(map[int]int)
+	for _, result := range results {
+		seen[result.ID]++
+		if result.ID != ibm && result.Document != "IBEX Systems" {
+			t.Errorf("Expected variants to be collapsed, got result '%s'", result.Document)
+		}
+	}
+	if seen[ibm] != 1 {
+		t.Fatalf("Expected exactly one result for the preferred document, got %d", seen[ibm])
+	}
+
+	top := results[0]
+	if top.ID != ibm || top.MatchedVariant == nil || top.MatchedVariant.Document != "IBM Corporation" {
+		t.Fatalf("Expected 'IBM' matched via 'IBM Corporation' to rank first, got %+v", top)
+	}
+	uncollapsed := collection.DocumentSearch(searchDoc)
+	if math.Abs(top.Score-uncollapsed[0].Score) > scoreEpsilon {
+		t.Errorf("Expected collapsed score %v to equal best variant score %v", top.Score, uncollapsed[0].Score)
+	}
+}
+
+func TestDocumentSearchPreferredBoost(t *testing.T) {
+	collection := preferredCollection()
+	ibm := *collection.DocumentID("IBM")
+
+	plain := collection.DocumentSearch("ibm corp")
+	boosted := collection.DocumentSearchWithOptions("ibm corp", SearchOptions{PreferredBoost: 4})
+
+	plainScores := make(map[int]float64)
+	for _, result := range plain {
+		plainScores[result.ID] = result.Score
+	}
+	for _, result := range boosted {
+		expected := plainScores[result.ID]
+		if result.ID == ibm {
+			expected *= 5
+		}
+		if math.Abs(result.Score-expected) > scoreEpsilon {
+			t.Errorf("Expected '%s' to score %v, got %v", result.Document, expected, result.Score)
+		}
+	}
+	if len(boosted) != 3 || boosted[1].ID != ibm {
+		t.Errorf("Expected boosted preferred term to move up to second place, got %+v", boosted)
+	}
+}
+
+func TestExplainResultCollapsedAndBoosted(t *testing.T) {
+	collection := preferredCollection()
+	opts := SearchOptions{CollapseVariants: true, PreferredBoost: 0.5}
+
+	for _, result := range collection.DocumentSearchWithOptions("ibm corporation", opts) {
+		explanation := collection.ExplainResult("ibm corporation", result, opts)
+		if explanation == nil {
+			t.Fatalf("Expected an explanation for '%s'", result.Document)
+		}
+		if math.Abs(explanation.Value-result.Score) > 1e-9 {
+			t.Errorf("Explanation value %v does not match score %v for '%s'", explanation.Value, result.Score, result.Document)
+		}
+	}
+}
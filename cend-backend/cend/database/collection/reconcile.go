@@ -0,0 +1,132 @@
+package collection
+
+import (
+	"maps"
+	"slices"
+)
+
+// PropertyValue is what a reconciled document is expected to have in one
+// of its fields: any of Values.
+type PropertyValue struct {
+	Field  string
+	Values []string
+}
+
+// Feature is one of the similarities, between 0 and 1, that make up the
+// score of a reconciliation candidate.
+type Feature struct {
+	ID    string  `json:"id"`
+	Value float64 `json:"value"`
+}
+
+// NameFeature is the similarity of a candidate's text to the query. The
+// feature of each property is named after its field.
+const NameFeature = "name"
+
+// Candidate is a document a reconciled value may refer to.
+type Candidate struct {
+	ID       int
+	Document string
+	Score    float64 // mean of Features
+	Match    bool    // the value can be taken to refer to this document
+	Features []Feature
+}
+
+// Reconcile finds the documents query may refer to, best first, keeping at
+// most limit of them unless limit is 0. Candidates are found by searching
+// for query with variants collapsed into their preferred document, and are
+// scored by the mean similarity of their text to query and of their fields
+// to properties. A candidate is a match if its text or the variant that
+// matched is query once normalized, its fields have every property, and no
+// other candidate qualifies as well.
+func (c *Collection) Reconcile(query string, properties []PropertyValue, limit int) []Candidate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	normalized := stringNormalize(query)
+	candidates := []Candidate{}
+	exact := []int{}
+	for _, r := range c.documentSearch(query, SearchOptions{CollapseVariants: true}) {
+		doc := c.documents.Get(r.ID)
+		features := []Feature{{NameFeature, min(r.Score, 1)}}
+		qualifies := stringNormalize(r.Document) == normalized ||
+			(r.MatchedVariant != nil && stringNormalize(r.MatchedVariant.Document) == normalized)
+		for _, p := range properties {
+			field := ""
+			if fields := doc.Fields(); fields != nil {
+				field = (*fields)[p.Field]
+			}
+			similarity := 0.0
+			for _, value := range p.Values {
+				similarity = max(similarity, c.fieldSimilarity(field, value))
+			}
+			features = append(features, Feature{p.Field, similarity})
+			qualifies = qualifies && similarity == 1
+		}
+
+		score := 0.0
+		for _, f := range features {
+			score += f.Value
+		}
+		if qualifies {
+			exact = append(exact, r.ID)
+		}
+		candidates = append(candidates, Candidate{ID: r.ID, Document: r.Document, Score: score / float64(len(features)), Features: features})
+	}
+
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if len(exact) == 1 {
+		for i := range candidates {
+			candidates[i].Match = candidates[i].ID == exact[0]
+		}
+	}
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// FieldNames returns the names of the fields documents of the collection
+// have, sorted.
+func (c *Collection) FieldNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, id := range c.documents.IDs() {
+		if fields := c.documents.Get(id).Fields(); fields != nil {
+			for name := range *fields {
+				seen[name] = true
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// fieldSimilarity returns 1 if a field has the expected value once both
+// are normalized, and otherwise the share of n-grams they have in common.
+func (c *Collection) fieldSimilarity(field, expected string) float64 {
+	field, expected = stringNormalize(field), stringNormalize(expected)
+	if field == expected {
+		return 1
+	}
+	a, b := nGramSet(field, c.ngram), nGramSet(expected, c.ngram)
+	shared := 0
+	for ngram := range a {
+		if _, ok := b[ngram]; ok {
+			shared++
+		}
+	}
+	if union := len(a) + len(b) - shared; union > 0 {
+		return float64(shared) / float64(union)
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package collection
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "I.B.M. Corp", "IBM Research", "Intel"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, variant, research := *c.DocumentID("IBM"), *c.DocumentID("I.B.M. Corp"), *c.DocumentID("IBM Research")
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM", "country": "United States"})
+	c.DocumentAddFields(research, &map[string]string{"country": "Switzerland"})
+	c.DocumentSetPreferred(ibm, true, nil)
+	c.DocumentSetPreferred(variant, false, []int{ibm})
+
+	if got := c.FieldNames(); !slices.Equal(got, []string{"country", "ticker"}) {
+		t.Errorf("Expected the fields country and ticker, got %v", got)
+	}
+
+	candidates := c.Reconcile("ibm", nil, 0)
+	if len(candidates) != 2 || candidates[0].ID != ibm || !candidates[0].Match || candidates[1].Match {
+		t.Fatalf("Expected IBM to match ahead of IBM Research, got %+v", candidates)
+	}
+	if f := candidates[0].Features; len(f) != 1 || f[0].ID != NameFeature || f[0].Value < 0.99 {
+		t.Errorf("Expected a name feature of 1, got %+v", f)
+	}
+
+	if candidates := c.Reconcile("i.b.m. corp", nil, 1); len(candidates) != 1 || candidates[0].ID != ibm || !candidates[0].Match {
+		t.Errorf("Expected a variant to match its preferred document, got %+v", candidates)
+	}
+
+	properties := []PropertyValue{{Field: "country", Values: []string{"Switzerland"}}}
+	candidates = c.Reconcile("ibm", properties, 0)
+	if candidates[0].ID != research || candidates[0].Match {
+		t.Errorf("Expected the property to rank IBM Research first without matching it, got %+v", candidates)
+	}
+	if f := candidates[0].Features; len(f) != 2 || f[1].ID != "country" || f[1].Value != 1 {
+		t.Errorf("Expected a country feature of 1, got %+v", f)
+	}
+
+	properties = []PropertyValue{{Field: "country", Values: []string{"Canada", "united states"}}, {Field: "ticker", Values: []string{"IBM"}}}
+	if candidates := c.Reconcile("IBM", properties, 0); !candidates[0].Match || candidates[0].Score < 0.99 {
+		t.Errorf("Expected IBM to match on any of a property's values, got %+v", candidates)
+	}
+	properties = []PropertyValue{{Field: "ticker", Values: []string{"IBMX"}}}
+	if candidates := c.Reconcile("IBM", properties, 0); candidates[0].Match || candidates[0].Features[1].Value == 0 {
+		t.Errorf("Expected a similar ticker to score without matching, got %+v", candidates)
+	}
+
+	c.DocumentSetPreferred(variant, false, nil)
+	c.DocumentAdd("I.B.M.")
+	if candidates := c.Reconcile("ibm", nil, 0); candidates[0].Match || candidates[1].Match {
+		t.Errorf("Expected no match between two documents reading IBM, got %+v", candidates)
+	}
+}
@@ -3,22 +3,150 @@ package collection
 import (
 	"math"
 	"slices"
+	"sync"
 )
 
+// SearchOptions narrows the results of DocumentSearchWithOptions. The zero
+// value returns every document sharing at least one n-gram with the query.
+type SearchOptions struct {
+	MinScore       float64 // drop documents scoring below this value
+	RelativeCutoff float64 // drop documents scoring below this fraction of the best score
+	MinShouldMatch float64 // fraction of the query's n-grams a document must share
+	CollapseVariants bool    // report variants under the preferred document they point to
+	PreferredBoost   float64 // multiply the score of preferred documents by 1+PreferredBoost
+}
+
 // DocumentSearch finds similar documents
 func (c *Collection) DocumentSearch(searchDoc string) []SearchResultScore {
+	return c.DocumentSearchWithOptions(searchDoc, SearchOptions{})
+}
+
+// DocumentSearchWithOptions finds similar documents, skipping candidates
+// that cannot satisfy opts before they are scored.
+func (c *Collection) DocumentSearchWithOptions(searchDoc string, opts SearchOptions) []SearchResultScore {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.documentSearch(searchDoc, opts)
+}
+
+// documentSearch finds similar documents; see DocumentSearchWithOptions.
+// The caller holds the lock.
+func (c *Collection) documentSearch(searchDoc string, opts SearchOptions) []SearchResultScore {
 	searchVector := c.vectorTFIDF(searchDoc)
-   
+
 	searchResult := []SearchResultScore{}
-	for docID := range c.RelevantDocumentIDs(searchDoc) {
+	for docID := range c.candidateDocumentIDs(searchDoc, searchVector, opts) {
 		matchDoc := c.documents.Get(docID).String()
 		matchVector := c.vectorTFIDF(matchDoc)
-		searchResult = append(searchResult, SearchResultScore{docID, matchDoc, dotProduct(searchVector, matchVector)})
+		score := dotProduct(searchVector, matchVector)
+		searchResult = append(searchResult, SearchResultScore{ID: docID, Document: matchDoc, Score: score})
+	}
+	if opts.CollapseVariants {
+		searchResult = c.collapseVariants(searchResult)
+	}
+	if opts.PreferredBoost > 0 {
+		c.boostPreferred(searchResult, opts.PreferredBoost)
 	}
+	searchResult = slices.DeleteFunc(searchResult, func(r SearchResultScore) bool {
+		return r.Score < opts.MinScore
+	})
 	sortSearchResult(searchResult)
+
+	if opts.RelativeCutoff > 0 && len(searchResult) > 0 {
+		cutoff := searchResult[0].Score * opts.RelativeCutoff
+		end := len(searchResult)
+		for end > 0 && searchResult[end-1].Score < cutoff {
+			end--
+		}
+		searchResult = searchResult[:end]
+	}
 	return searchResult
 }
 
+// Query is one search of a SearchBatch.
+type Query struct {
+	Text       string
+	MaxResults int // keep only the best MaxResults results, unless 0
+	Explain    bool
+	Options    SearchOptions
+}
+
+// SearchBatch runs queries on up to workers goroutines and returns their
+// results in the order of queries. The collection is not changed while they
+// run, so every query searches the same documents.
+func (c *Collection) SearchBatch(queries []Query, workers int) [][]SearchResultScore {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := make([][]SearchResultScore, len(queries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(workers, len(queries))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				q := queries[i]
+				result := c.documentSearch(q.Text, q.Options)
+				if q.MaxResults > 0 && len(result) > q.MaxResults {
+					result = result[:q.MaxResults]
+				}
+				if q.Explain {
+					for j := range result {
+						result[j].Explanation = c.explainResult(q.Text, result[j], q.Options)
+					}
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := range queries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// candidateDocumentIDs returns the documents sharing enough n-grams with
+// searchDoc to satisfy opts.MinShouldMatch and whose best possible score
+// reaches opts.MinScore. Document vectors are unit length, so a document's
+// score is bounded by the norm of the query vector restricted to the
+// n-grams it shares.
+func (c *Collection) candidateDocumentIDs(searchDoc string, searchVector map[string]float64, opts SearchOptions) map[int]struct{} {
+	ngrams := nGramSet(searchDoc, c.ngram)
+	minMatch := int(math.Ceil(opts.MinShouldMatch * float64(len(ngrams))))
+	if minMatch < 1 {
+		minMatch = 1
+	}
+
+	matches := make(map[int]int)
+	bounds := make(map[int]float64)
+	for ngram := range ngrams {
+		weight := searchVector[ngram]
+		for _, docID := range c.lookupTable.DocIDs(ngram) {
+			matches[docID]++
+			bounds[docID] += weight * weight
+		}
+	}
+
+	// Boosting can lift a score above its bound, so prune against the
+	// lowest score that could still reach MinScore once boosted.
+	minScore := opts.MinScore / (1 + opts.PreferredBoost)
+
+	candidates := make(map[int]struct{})
+	for docID, matched := range matches {
+		if matched < minMatch {
+			continue
+		}
+		if minScore > 0 && math.Sqrt(bounds[docID])+1e-9 < minScore {
+			continue
+		}
+		candidates[docID] = struct{}{}
+	}
+	return candidates
+}
+
 func dotProduct(v1, v2 map[string]float64) float64 {
 	dotProduct := 0.0
 	for ngram, searchTFIDF := range v1 {
@@ -31,55 +159,77 @@ func dotProduct(v1, v2 map[string]float64) float64 {
 
 
 
+// sortSearchResult orders results by score descending, breaking ties by ID
+// so that the order is stable across calls.
 func sortSearchResult(searchResult []SearchResultScore) {
 	compareByScoreDesc := func(a, b SearchResultScore) int {
-		if a.Score > b.Score {
-			return -1
-		}
-		if a.Score < b.Score {
-			return 1
-		}
-		return 0
+		return compareScores(a.Score, a.ID, b.Score, b.ID)
 	}
 	slices.SortFunc(searchResult, compareByScoreDesc)
 }
 
+// IDF returns the inverse document frequency of token, 0 if no document
+// has it.
 func (c *Collection) IDF(token string) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idf(token)
+}
+
+// idf returns the inverse document frequency of token. The caller holds
+// the lock.
+func (c *Collection) idf(token string) float64 {
 	docCount := c.documents.Length()
 	if docCount == 0 {
 		return 0
 	}
-	ids, exists := (*c.lookupTable)[token]
-	if !exists || ids.count == 0 {
+	docFreq := c.lookupTable.Count(token)
+	if docFreq == 0 {
 		return 0
 	}
-	return math.Log(float64(docCount) / float64(ids.count))
+	return math.Log(float64(docCount) / float64(docFreq))
 }
 
-func (c *Collection) vectorTFIDF(document string) map[string]float64 {
-	docIDptr := c.DocumentID(document)
+// termVector holds the unnormalized TF-IDF weights of a document together
+// with the term frequencies and norm they were computed from.
+type termVector struct {
+	tf      map[string]int
+	weights map[string]float64
+	norm    float64
+}
+
+func (c *Collection) termVector(document string) termVector {
+	docIDptr := c.documentID(document)
 	var tokenFrequency map[string]int
-	if docIDptr == nil {
+	if docIDptr != nil {
+		// Stores that do not keep token frequencies return documents without them.
+		if tf := c.documents.Get(*docIDptr).TokenFrequency(); tf != nil {
+			tokenFrequency = *tf
+		}
+	}
+	if tokenFrequency == nil {
 		tokenFrequency = nGramFrequency(document, c.ngram)
-	} else {
-		docID := *docIDptr
-		tokenFrequency = *c.documents.Get(docID).TokenFrequency()
 	}
 
-	vector := make(map[string]float64)
+	weights := make(map[string]float64)
 	var norm float64
 	for token, tf := range tokenFrequency {
-		idf := c.IDF(token)
+		idf := c.idf(token)
 		tokenTFIDF := float64(tf) * idf
-		vector[token] = tokenTFIDF
+		weights[token] = tokenTFIDF
 		norm += tokenTFIDF * tokenTFIDF
 	}
-	norm = math.Sqrt(norm)
-	if norm > 0 {
+	return termVector{tf: tokenFrequency, weights: weights, norm: math.Sqrt(norm)}
+}
+
+func (c *Collection) vectorTFIDF(document string) map[string]float64 {
+	tv := c.termVector(document)
+	vector := tv.weights
+	if tv.norm > 0 {
 		for token := range vector {
-			vector[token] /= norm
+			vector[token] /= tv.norm
 		}
 	}
 
 	return vector
-}
\ No newline at end of file
+}
@@ -2,6 +2,8 @@ package collection
 
 import (
 	"fmt"
+	"math"
+	"slices"
 	"testing"
 )
 
@@ -64,4 +66,131 @@ func TestTechnicalDocumentSearch(t *testing.T) {
 	}
 	LogInfo("Search Results for: " + searchDoc + logMsg)
 	t.Errorf("Test")
-}
\ No newline at end of file
+}
+func TestDocumentExplain(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"NZXT Hue", "NZXT Hue+", "NZXT Kraken", "Corsair"} {
+		collection.DocumentAdd(doc)
+	}
+
+	searchDoc := "nzxt hue"
+	for _, result := range collection.DocumentSearch(searchDoc) {
+		explanation := collection.DocumentExplain(searchDoc, result.ID)
+		if explanation == nil {
+			t.Fatalf("Expected an explanation for document %d", result.ID)
+		}
+		if math.Abs(explanation.Value-result.Score) > 1e-9 {
+			t.Errorf("Explanation value %v does not match score %v for '%s'", explanation.Value, result.Score, result.Document)
+		}
+
+		var sum float64
+		for _, detail := range explanation.Details {
+			if len(detail.Details) != 2 {
+				t.Fatalf("Expected query and document weights for %s, got %d details", detail.Description, len(detail.Details))
+			}
+			sum += detail.Value
+		}
+		if math.Abs(sum-explanation.Value) > 1e-9 {
+			t.Errorf("Explanation details sum to %v, expected %v", sum, explanation.Value)
+		}
+	}
+
+	if explanation := collection.DocumentExplain(searchDoc, 99); explanation != nil {
+		t.Errorf("Expected no explanation for a missing document, got %+v", explanation)
+	}
+}
+
+func TestDocumentSearchThresholds(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	docs := []string{
+		"international business machines",
+		"international business",
+		"business machines",
+		"international paper",
+		"machine learning",
+	}
+	for _, doc := range docs {
+		collection.DocumentAdd(doc)
+	}
+	searchDoc := "international business machines"
+	all := collection.DocumentSearch(searchDoc)
+	if len(all) != len(docs) {
+		t.Fatalf("Expected every document to share an n-gram with the query, got %d results", len(all))
+	}
+
+	minScore := all[2].Score - 1e-9
+	results := collection.DocumentSearchWithOptions(searchDoc, SearchOptions{MinScore: minScore})
+	if len(results) != 3 {
+		t.Errorf("Expected 3 results with minScore %v, got %d", minScore, len(results))
+	}
+	for _, result := range results {
+		if result.Score < minScore {
+			t.Errorf("Result '%s' scored %v, below minScore %v", result.Document, result.Score, minScore)
+		}
+	}
+
+	results = collection.DocumentSearchWithOptions(searchDoc, SearchOptions{RelativeCutoff: 0.5})
+	for _, result := range results {
+		if result.Score < all[0].Score*0.5 {
+			t.Errorf("Result '%s' scored %v, below half the best score %v", result.Document, result.Score, all[0].Score)
+		}
+	}
+	if len(results) == 0 || len(results) == len(all) {
+		t.Errorf("Expected relativeCutoff to drop some but not all results, got %d of %d", len(results), len(all))
+	}
+
+	results = collection.DocumentSearchWithOptions(searchDoc, SearchOptions{MinShouldMatch: 0.6})
+	queryNGrams := nGramSet(searchDoc, collection.ngram)
+	for _, result := range results {
+		shared := 0
+		for ngram := range nGramSet(result.Document, collection.ngram) {
+			if _, exists := queryNGrams[ngram]; exists {
+				shared++
+			}
+		}
+		if float64(shared) < 0.6*float64(len(queryNGrams)) {
+			t.Errorf("Result '%s' shares %d of %d query n-grams, below minShouldMatch", result.Document, shared, len(queryNGrams))
+		}
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results with minShouldMatch 0.6, got %d", len(results))
+	}
+}
+
+func TestSearchBatch(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"international business machines", "international business", "business machines", "international paper", "machine learning"} {
+		collection.DocumentAdd(doc)
+	}
+	queries := []Query{}
+	for i := range 50 {
+		queries = append(queries, Query{Text: []string{"business", "international", "machine", "paper"}[i%4], MaxResults: i % 3})
+	}
+	queries = append(queries, Query{Text: "business machines", Options: SearchOptions{MinScore: 0.5}, Explain: true})
+
+	results := collection.SearchBatch(queries, 4)
+	if len(results) != len(queries) {
+		t.Fatalf("Expected %d results, got %d", len(queries), len(results))
+	}
+	for i, q := range queries[:len(queries)-1] {
+		want := collection.DocumentSearchWithOptions(q.Text, q.Options)
+		if q.MaxResults > 0 && len(want) > q.MaxResults {
+			want = want[:q.MaxResults]
+		}
+		if !sameResults(results[i], want) {
+			t.Errorf("Expected results %v for query %d, got %v", want, i, results[i])
+		}
+	}
+	explained := results[len(results)-1]
+	if len(explained) == 0 || explained[0].Explanation == nil || explained[len(explained)-1].Score < 0.5 {
+		t.Errorf("Expected explained results scoring at least 0.5, got %v", explained)
+	}
+}
+
+// sameResults reports whether two searches found the same documents with the
+// same scores, up to the rounding of summing in a different order.
+func sameResults(a, b []SearchResultScore) bool {
+	return slices.EqualFunc(a, b, func(x, y SearchResultScore) bool {
+		return x.ID == y.ID && x.Document == y.Document && math.Abs(x.Score-y.Score) < 1e-9
+	})
+}
@@ -0,0 +1,246 @@
+package collection
+
+import (
+	"container/heap"
+	"strings"
+
+	"cend/database/collection/documents"
+)
+
+// suggestPreferredBoost multiplies the score of preferred terms so that
+// canonical names are completed before their variants.
+const suggestPreferredBoost = 2.0
+
+// Suggestion is a single autocomplete completion for a prefix.
+type Suggestion struct {
+	ID        int     `json:"id"`
+	Document  string  `json:"document"`
+	Score     float64 `json:"score"`
+	Preferred bool    `json:"isPreferred"`
+}
+
+// suggestEntry is a document indexed under a key of the trie, and the word
+// position the key starts at.
+type suggestEntry struct {
+	id        int
+	document  string
+	preferred bool
+	position  int
+}
+
+// score ranks terms matching at their first word above those matching a
+// later word, and boosts preferred terms.
+func (e suggestEntry) score() float64 {
+	score := 1.0 / float64(1+e.position)
+	if e.preferred {
+		score *= suggestPreferredBoost
+	}
+	return score
+}
+
+// compareSuggestEntries orders entries best first: by score, then shorter
+// terms first, then alphabetically.
+func compareSuggestEntries(a, b suggestEntry) int {
+	if sa, sb := a.score(), b.score(); sa != sb {
+		if sa > sb {
+			return -1
+		}
+		return 1
+	}
+	if len(a.document) != len(b.document) {
+		return len(a.document) - len(b.document)
+	}
+	if c := strings.Compare(a.document, b.document); c != 0 {
+		return c
+	}
+	return a.id - b.id
+}
+
+// suggestNode is a node of the prefix trie. entries holds the documents
+// whose key ends at this node, and best the best entry of the node and its
+// descendants, so that the trie can be walked best first.
+type suggestNode struct {
+	children map[rune]*suggestNode
+	entries  map[int]suggestEntry
+	best     *suggestEntry
+}
+
+// suggestTrie indexes normalized document strings by prefix. Every word
+// suffix of a document is inserted so that "hue" completes "nzxt hue".
+type suggestTrie struct {
+	root *suggestNode
+}
+
+func newSuggestNode() *suggestNode {
+	return &suggestNode{children: make(map[rune]*suggestNode)}
+}
+
+func newSuggestTrie() *suggestTrie {
+	return &suggestTrie{root: newSuggestNode()}
+}
+
+// suggestKeys returns the keys under which a normalized document is indexed,
+// one per word, in word order.
+func suggestKeys(normalizedDocument string) []string {
+	words := strings.Split(normalizedDocument, " ")
+	keys := make([]string, 0, len(words))
+	for i := range words {
+		keys = append(keys, strings.Join(words[i:], " "))
+	}
+	return keys
+}
+
+// add indexes a document under each word suffix of its normalized form.
+func (t *suggestTrie) add(normalizedDocument string, doc *documents.Document) {
+	if normalizedDocument == "" {
+		return
+	}
+	for position, key := range suggestKeys(normalizedDocument) {
+		e := suggestEntry{id: doc.ID(), document: doc.String(), preferred: doc.Preferred(), position: position}
+		node := t.root
+		node.offer(e)
+		for _, r := range key {
+			child, exists := node.children[r]
+			if !exists {
+				child = newSuggestNode()
+				node.children[r] = child
+			}
+			node = child
+			node.offer(e)
+		}
+		if node.entries == nil {
+			node.entries = make(map[int]suggestEntry)
+		}
+		node.entries[e.id] = e
+	}
+}
+
+// offer makes e the best entry of the node if it is better than the best.
+func (n *suggestNode) offer(e suggestEntry) {
+	if n.best == nil || compareSuggestEntries(e, *n.best) < 0 {
+		n.best = &e
+	}
+}
+
+// remove deletes a document from the trie and prunes nodes left empty.
+func (t *suggestTrie) remove(normalizedDocument string, docID int) {
+	for _, key := range suggestKeys(normalizedDocument) {
+		t.removeKey(t.root, []rune(key), docID)
+	}
+}
+
+// removeKey removes docID from the node reached by key, and reports whether
+// node is now empty and can be pruned by its parent. The best entries of the
+// nodes along the way are found again from their entries and children.
+func (t *suggestTrie) removeKey(node *suggestNode, key []rune, docID int) bool {
+	if len(key) == 0 {
+		delete(node.entries, docID)
+	} else if child, exists := node.children[key[0]]; exists {
+		if t.removeKey(child, key[1:], docID) {
+			delete(node.children, key[0])
+		}
+	}
+	if node.best != nil && node.best.id == docID {
+		node.best = nil
+		for _, e := range node.entries {
+			node.offer(e)
+		}
+		for _, child := range node.children {
+			if child.best != nil {
+				node.offer(*child.best)
+			}
+		}
+	}
+	return len(node.children) == 0 && len(node.entries) == 0
+}
+
+// suggestItem is an entry, or a node whose entries are yet to be walked,
+// in the queue of a best-first walk.
+type suggestItem struct {
+	entry suggestEntry
+	node  *suggestNode
+}
+
+// find returns up to n of the entries under keys starting with prefix,
+// best first, each document once at the best position it matched at; all of
+// them if n is 0. It walks the trie best first, so it reads little more
+// than the nodes leading to the entries it returns.
+func (t *suggestTrie) find(prefix string, n int) []suggestEntry {
+	node := t.root
+	for _, r := range prefix {
+		child, exists := node.children[r]
+		if !exists {
+			return []suggestEntry{}
+		}
+		node = child
+	}
+
+	found := []suggestEntry{}
+	seen := make(map[int]bool)
+	queue := &suggestQueue{}
+	if node.best != nil {
+		heap.Push(queue, suggestItem{entry: *node.best, node: node})
+	}
+	for queue.Len() > 0 && (n <= 0 || len(found) < n) {
+		item := heap.Pop(queue).(suggestItem)
+		if item.node == nil {
+			if !seen[item.entry.id] {
+				seen[item.entry.id] = true
+				found = append(found, item.entry)
+			}
+			continue
+		}
+		for _, e := range item.node.entries {
+			heap.Push(queue, suggestItem{entry: e})
+		}
+		for _, child := range item.node.children {
+			if child.best != nil {
+				heap.Push(queue, suggestItem{entry: *child.best, node: child})
+			}
+		}
+	}
+	return found
+}
+
+// suggestQueue is a heap of suggestItems, best first. A node is ordered by
+// its best entry, which comes out before the node itself, so that no entry
+// leaves the queue before a better one is found.
+type suggestQueue []suggestItem
+
+func (q suggestQueue) Len() int { return len(q) }
+
+func (q suggestQueue) Less(i, j int) bool {
+	if c := compareSuggestEntries(q[i].entry, q[j].entry); c != 0 {
+		return c < 0
+	}
+	// An entry goes before a node whose best entry it is.
+	return q[i].node == nil && q[j].node != nil
+}
+
+func (q suggestQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *suggestQueue) Push(x any) { *q = append(*q, x.(suggestItem)) }
+
+func (q *suggestQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// DocumentSuggest returns up to n completions for prefix, all of them if n
+// is 0. Terms matching at their first word rank above those matching a
+// later word, and preferred terms are boosted; ties go to the shorter term.
+func (c *Collection) DocumentSuggest(prefix string, n int) []Suggestion {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	prefix = stringNormalize(prefix)
+	if prefix == "" || c.suggestions == nil {
+		return []Suggestion{}
+	}
+	suggestions := []Suggestion{}
+	for _, e := range c.suggestions.find(prefix, n) {
+		suggestions = append(suggestions, Suggestion{e.id, e.document, e.score(), e.preferred})
+	}
+	return suggestions
+}
@@ -0,0 +1,101 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func suggestDocuments(suggestions []Suggestion) []string {
+	docs := make([]string, 0, len(suggestions))
+	for _, s := range suggestions {
+		docs = append(docs, s.Document)
+	}
+	return docs
+}
+
+func TestDocumentSuggest(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for _, doc := range []string{"NZXT Hue", "NZXT Hue+", "Nike", "Hue Lighting", "Apple"} {
+		collection.DocumentAdd(doc)
+	}
+
+	got := suggestDocuments(collection.DocumentSuggest("nzxt h", 10))
+	if len(got) != 2 || got[0] != "NZXT Hue" || got[1] != "NZXT Hue+" {
+		t.Errorf("Expected [NZXT Hue NZXT Hue+], got %v", got)
+	}
+
+	// Matches at the first word rank above matches at a later word.
+	got = suggestDocuments(collection.DocumentSuggest("HUE", 10))
+	if len(got) != 3 || got[0] != "Hue Lighting" {
+		t.Errorf("Expected 'Hue Lighting' first of 3 suggestions, got %v", got)
+	}
+
+	got = suggestDocuments(collection.DocumentSuggest("n", 1))
+	if len(got) != 1 || got[0] != "Nike" {
+		t.Errorf("Expected maxResults to truncate to [Nike], got %v", got)
+	}
+
+	if got := collection.DocumentSuggest("zzz", 10); len(got) != 0 {
+		t.Errorf("Expected no suggestions for unknown prefix, got %v", got)
+	}
+}
+
+func TestDocumentSuggestPreferredBoost(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	collection.DocumentAdd("IBM Corp")
+	collection.DocumentAdd("IBM")
+
+	collection.DocumentSetPreferred(*collection.DocumentID("IBM Corp"), true, nil)
+
+	got := suggestDocuments(collection.DocumentSuggest("ibm", 10))
+	if len(got) != 2 || got[0] != "IBM Corp" {
+		t.Errorf("Expected preferred 'IBM Corp' first, got %v", got)
+	}
+}
+
+func TestDocumentSuggestAfterRemove(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	collection.DocumentAdd("NZXT Hue")
+	collection.DocumentAdd("NZXT Kraken")
+
+	collection.DocumentRemove(*collection.DocumentID("NZXT Hue"))
+
+	got := suggestDocuments(collection.DocumentSuggest("nzxt", 10))
+	if len(got) != 1 || got[0] != "NZXT Kraken" {
+		t.Errorf("Expected [NZXT Kraken] after removal, got %v", got)
+	}
+	if got := collection.DocumentSuggest("hue", 10); len(got) != 0 {
+		t.Errorf("Expected removed document to leave no suggestions, got %v", got)
+	}
+}
+
+func TestDocumentSuggestBestFirst(t *testing.T) {
+	collection := New("Test Collection", "./test-data/test-collection")
+	for i := range 200 {
+		collection.DocumentAdd(fmt.Sprintf("Term %d of %d", i, i%7))
+	}
+	collection.DocumentSetPreferred(*collection.DocumentID("Term 150 of 3"), true, nil)
+	collection.DocumentRemove(*collection.DocumentID("Term 1 of 1"))
+
+	all := collection.DocumentSuggest("t", 0)
+	if len(all) != 199 {
+		t.Fatalf("Expected 199 suggestions, got %d", len(all))
+	}
+	if !slices.IsSortedFunc(all, func(a, b Suggestion) int {
+		return compareSuggestEntries(suggestEntry{a.ID, a.Document, a.Preferred, 0}, suggestEntry{b.ID, b.Document, b.Preferred, 0})
+	}) || all[0].Document != "Term 150 of 3" {
+		t.Errorf("Expected suggestions best first, got %v", suggestDocuments(all[:5]))
+	}
+	for _, n := range []int{1, 5, 42} {
+		if got := collection.DocumentSuggest("t", n); !slices.Equal(got, all[:n]) {
+			t.Errorf("Expected the best %d suggestions, got %v", n, suggestDocuments(got))
+		}
+	}
+
+	// Later words rank below first words, whatever their length.
+	got := suggestDocuments(collection.DocumentSuggest("of 3", 2))
+	if len(got) != 2 || got[0] != "Term 150 of 3" || got[1] != "Term 3 of 3" {
+		t.Errorf("Expected [Term 150 of 3 Term 3 of 3], got %v", got)
+	}
+}
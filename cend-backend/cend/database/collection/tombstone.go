@@ -0,0 +1,139 @@
+package collection
+
+import (
+	"fmt"
+	"time"
+
+	"cend/database/collection/documents"
+	"cend/database/storage"
+)
+
+// documentView is the part of a collection's store holding either its live
+// documents or its tombstones, deleted documents kept until they are
+// purged. Each view sees nothing of the other, so that deleted documents
+// are not found, listed or changed.
+type documentView struct {
+	storage.DocumentStore
+	deleted    bool // view the tombstones rather than live documents
+	tombstones *int // number of tombstones in the store
+}
+
+// Get returns the document with the given ID if it is in the view, or nil.
+func (v documentView) Get(id int) *documents.Document {
+	doc := v.DocumentStore.Get(id)
+	if doc == nil || doc.Deleted().IsZero() == v.deleted {
+		return nil
+	}
+	return doc
+}
+
+// IDs returns the IDs of the documents in the view in ascending order.
+func (v documentView) IDs() []int {
+	ids := []int{}
+	for _, id := range v.DocumentStore.IDs() {
+		if v.Get(id) != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Length returns the number of documents in the view without reading them,
+// as it is needed for every IDF.
+func (v documentView) Length() int {
+	if v.deleted {
+		return *v.tombstones
+	}
+	return v.DocumentStore.Length() - *v.tombstones
+}
+
+// setStore makes store the collection's document store and counts its
+// tombstones.
+func (c *Collection) setStore(store storage.DocumentStore) {
+	c.store = store
+	c.tombstones = len(c.deleted().IDs())
+	c.documents = documentView{DocumentStore: store, tombstones: &c.tombstones}
+}
+
+// deleted returns the view of the collection's tombstones.
+func (c *Collection) deleted() documentView {
+	return documentView{DocumentStore: c.store, deleted: true, tombstones: &c.tombstones}
+}
+
+// Tombstone returns a copy of the deleted document with the given ID, or
+// nil if there is no such tombstone.
+func (c *Collection) Tombstone(docId int) *documents.Document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if doc := c.deleted().Get(docId); doc != nil {
+		return copyDocument(doc)
+	}
+	return nil
+}
+
+// DocumentRestore brings a deleted document back under its ID, with the
+// fields and links it had, if its tombstone is still at version, which may
+// be AnyVersion. It fails if the document was purged or its text has since
+// been given to another document.
+func (c *Collection) DocumentRestore(docId, version int) error {
+	return c.As("").DocumentRestore(docId, version)
+}
+
+// DocumentRestore brings a deleted document back; see
+// Collection.DocumentRestore.
+func (e *Editor) DocumentRestore(docId, version int) error {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc := c.deleted().Get(docId)
+	if doc == nil {
+		return fmt.Errorf("deleted document %d %w", docId, ErrNotFound)
+	}
+	if err := checkVersion(doc, version); err != nil {
+		return err
+	}
+	if id := c.documentID(doc.String()); id != nil {
+		return fmt.Errorf("cannot restore document %d: %q %w as document %d", docId, doc.String(), ErrExists, *id)
+	}
+	before := c.records(docId)
+	x := nGramFrequency(stringNormalize(doc.String()), c.ngram)
+	doc.SetTokenFrequency(&x)
+	doc.SetDeleted(time.Time{})
+	doc.SetVersion(doc.Version() + 1)
+	if err := c.store.Put(doc); err != nil {
+		return err
+	}
+	c.tombstones--
+	if err := c.index(doc.String(), docId); err != nil {
+		c.store.Put(before[0].Doc())
+		c.tombstones++
+		return err
+	}
+	c.record(e.actor, OpRestore, nil, c.records(docId))
+	return nil
+}
+
+// Purge removes the tombstones of documents deleted before the given time
+// for good and returns their IDs. Purged documents cannot be restored, and
+// their IDs are still not reused.
+func (c *Collection) Purge(before time.Time) ([]int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := []int{}
+	tombstones := c.deleted()
+	for _, id := range tombstones.IDs() {
+		if !tombstones.Get(id).Deleted().Before(before) {
+			continue
+		}
+		tombstone := c.records(id)
+		if err := c.store.RemoveDocument(id); err != nil {
+			return purged, err
+		}
+		c.tombstones--
+		c.record("", OpPurge, tombstone, nil)
+		purged = append(purged, id)
+	}
+	return purged, nil
+}
@@ -0,0 +1,155 @@
+package collection
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDocumentRemoveLeavesTombstone(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	deleted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return deleted }
+
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "Intel", "Apple"} {
+		c.DocumentAdd(doc)
+	}
+	ibm := *c.DocumentID("IBM")
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM"})
+	if err := c.DocumentRemove(ibm); err != nil {
+		t.Fatalf("DocumentRemove failed: %v", err)
+	}
+
+	if c.documents.Get(ibm) != nil || c.DocumentExists("IBM") || c.documents.Length() != 2 {
+		t.Errorf("Expected the document to be gone from the collection")
+	}
+	if results := c.DocumentSearch("ibm"); len(results) != 0 {
+		t.Errorf("Expected a deleted document not to be found, got %v", results)
+	}
+	if page, _ := c.DocumentListPage("", 10); page.Total != 2 {
+		t.Errorf("Expected a deleted document not to be listed, got %d documents", page.Total)
+	}
+	tombstone := c.Tombstone(ibm)
+	if tombstone == nil || !tombstone.Deleted().Equal(deleted) || tombstone.Version() != 3 {
+		t.Fatalf("Expected a tombstone deleted at %v at version 3, got %v", deleted, tombstone)
+	}
+	if page, _ := c.TombstoneListPage("", 10); page.Total != 1 || page.Documents[0].ID() != ibm {
+		t.Errorf("Expected the tombstone to be listed, got %v", page.Documents)
+	}
+	if err := c.DocumentAddFields(ibm, &map[string]string{"country": "US"}); err == nil {
+		t.Errorf("Expected a deleted document not to be changed")
+	}
+	if err := c.DocumentRemove(ibm); err == nil {
+		t.Errorf("Expected deleting a deleted document to fail")
+	}
+}
+
+func TestDocumentRemoveRollsBack(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "Intel"} {
+		c.DocumentAdd(doc)
+	}
+	ibm := *c.DocumentID("IBM")
+	before := savedRecords(c)
+
+	restore := failPut(c, 0)
+	err := c.DocumentRemove(ibm)
+	restore()
+	if err == nil {
+		t.Fatalf("Expected the delete to fail")
+	}
+	if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+		t.Errorf("Expected a failed delete to change nothing, got %v", got)
+	}
+	if doc := c.Document(ibm); doc == nil || !doc.Deleted().IsZero() || doc.Version() != 1 {
+		t.Errorf("Expected IBM to stay live at version 1, got %v", doc)
+	}
+	if results := c.DocumentSearch("ibm"); len(results) == 0 || results[0].ID != ibm {
+		t.Errorf("Expected IBM to be found after a failed delete, got %v", results)
+	}
+}
+
+func TestDocumentRestore(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "I.B.M.", "Apple"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, variant, apple := *c.DocumentID("IBM"), *c.DocumentID("I.B.M."), *c.DocumentID("Apple")
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM"})
+	c.DocumentSetPreferred(ibm, true, nil)
+	c.DocumentSetPreferred(variant, false, []int{ibm})
+	c.DocumentRemove(ibm)
+
+	var conflict *VersionConflictError
+	if err := c.DocumentRestore(ibm, 3); !errors.As(err, &conflict) {
+		t.Errorf("Expected a version conflict, got %v", err)
+	}
+	if err := c.As("alice").DocumentRestore(ibm, 4); err != nil {
+		t.Fatalf("DocumentRestore failed: %v", err)
+	}
+	doc := c.documents.Get(ibm)
+	if doc == nil || !doc.Deleted().IsZero() || doc.Version() != 5 {
+		t.Fatalf("Expected the document back at version 5, got %v", doc)
+	}
+	if got := *doc.Fields(); !maps.Equal(got, map[string]string{"ticker": "IBM"}) || !doc.Preferred() {
+		t.Errorf("Expected the document to keep its fields and preferred flag, got %v %v", got, doc.Preferred())
+	}
+	if results := c.DocumentSearchWithOptions("i.b.m.", SearchOptions{CollapseVariants: true}); len(results) == 0 || results[0].ID != ibm {
+		t.Errorf("Expected variants to collapse into the restored document again, got %v", results)
+	}
+	if change := c.History(ibm); change[len(change)-1].Op != OpRestore || change[len(change)-1].Actor != "alice" {
+		t.Errorf("Expected alice's restore to be recorded, got %+v", change[len(change)-1])
+	}
+	if err := c.DocumentRestore(ibm, AnyVersion); err == nil {
+		t.Errorf("Expected restoring a live document to fail")
+	}
+
+	c.DocumentRemove(apple)
+	c.DocumentAdd("Apple")
+	if err := c.DocumentRestore(apple, AnyVersion); err == nil {
+		t.Errorf("Expected restoring a document whose text was reused to fail")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "Intel", "Apple"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, intel := *c.DocumentID("IBM"), *c.DocumentID("Intel")
+	now = func() time.Time { return day }
+	c.DocumentRemove(ibm)
+	now = func() time.Time { return day.AddDate(0, 0, 10) }
+	c.DocumentRemove(intel)
+
+	purged, err := c.Purge(day.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if !reflect.DeepEqual(purged, []int{ibm}) {
+		t.Errorf("Expected only the older tombstone to be purged, got %v", purged)
+	}
+	if c.Tombstone(ibm) != nil || c.Tombstone(intel) == nil {
+		t.Errorf("Expected the newer tombstone to be kept")
+	}
+	if err := c.DocumentRestore(ibm, AnyVersion); err == nil {
+		t.Errorf("Expected a purged document not to be restored")
+	}
+	if got := ops(c.History(ibm)); !slices.Equal(got, []string{OpAdd, OpRemove, OpPurge}) {
+		t.Errorf("Expected the purge to be recorded, got %v", got)
+	}
+	if c.documents.Length() != 1 || c.deleted().Length() != 1 {
+		t.Errorf("Expected 1 document and 1 tombstone, got %d and %d", c.documents.Length(), c.deleted().Length())
+	}
+	c.DocumentAdd("IBM")
+	if id := *c.DocumentID("IBM"); id != 4 {
+		t.Errorf("Expected a purged ID not to be reused, got %d", id)
+	}
+}
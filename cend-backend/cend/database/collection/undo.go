@@ -0,0 +1,144 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"cend/database/storage"
+)
+
+// OpUndo is the operation recorded when a change is undone.
+const OpUndo = "undo"
+
+// UndoConflict is a reason a change cannot be undone.
+type UndoConflict struct {
+	ID     int    `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// UndoConflictError reports that documents touched by a change have
+// changed since, so undoing it would lose later work.
+type UndoConflictError struct {
+	Seq       int
+	Conflicts []UndoConflict
+}
+
+func (e *UndoConflictError) Error() string {
+	reasons := []string{}
+	for _, conflict := range e.Conflicts {
+		reasons = append(reasons, fmt.Sprintf("document %d %s", conflict.ID, conflict.Reason))
+	}
+	return fmt.Sprintf("cannot undo change %d: %s", e.Seq, strings.Join(reasons, "; "))
+}
+
+// Undo reverts the merge or split recorded in history as change seq; see
+// Editor.Undo.
+func (c *Collection) Undo(seq int) (Change, error) {
+	return c.As("").Undo(seq)
+}
+
+// Undo reverts the merge or split recorded in history as change seq,
+// restoring the documents it touched, with their IDs, fields and links, as
+// they were before it. Documents it removed are added back and documents it
+// created are removed. The undo is itself recorded, and returned. It fails
+// with an *UndoConflictError if any of those documents has changed since.
+func (e *Editor) Undo(seq int) (Change, error) {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seq < 1 || seq > len(c.history) {
+		return Change{}, fmt.Errorf("change %d %w", seq, ErrNotFound)
+	}
+	change := c.history[seq-1]
+	if change.Op != OpMerge && change.Op != OpSplit {
+		return Change{}, fmt.Errorf("change %d is a %s; only merges and splits can be undone", seq, change.Op)
+	}
+	for _, later := range c.history[seq:] {
+		if later.Op == OpUndo && later.Undoes == seq {
+			return Change{}, fmt.Errorf("change %d was already undone by change %d", seq, later.Seq)
+		}
+	}
+
+	conflicts := []UndoConflict{}
+	for _, after := range change.After {
+		doc := c.documents.Get(after.ID)
+		if doc == nil {
+			conflicts = append(conflicts, UndoConflict{after.ID, "was removed"})
+		} else if doc.Version() != after.Version {
+			conflicts = append(conflicts, UndoConflict{after.ID, fmt.Sprintf("was changed to version %d", doc.Version())})
+		}
+	}
+	for _, before := range change.Before {
+		if containsRecord(change.After, before.ID) {
+			continue
+		}
+		if id := c.documentID(before.Document); id != nil {
+			conflicts = append(conflicts, UndoConflict{before.ID, fmt.Sprintf("cannot be restored as document %d is now %q", *id, before.Document)})
+		}
+	}
+	if len(conflicts) > 0 {
+		return Change{}, &UndoConflictError{Seq: seq, Conflicts: conflicts}
+	}
+
+	ids := []int{}
+	for _, rec := range slices.Concat(change.Before, change.After) {
+		if !slices.Contains(ids, rec.ID) {
+			ids = append(ids, rec.ID)
+		}
+	}
+	current := c.records(ids...)
+	err := c.applyOrRollback(ids, current, func() error {
+		for _, after := range change.After {
+			if !containsRecord(change.Before, after.ID) {
+				if err := c.documentRemove(after.ID, AnyVersion); err != nil {
+					return err
+				}
+			}
+		}
+		for _, before := range change.Before {
+			if err := c.restore(before); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Change{}, err
+	}
+	return c.recordChange(Change{Actor: e.actor, Op: OpUndo, Before: current, After: c.records(ids...), Undoes: seq}), nil
+}
+
+// restore puts a document back as rec records it, reindexing it if its
+// text differs. Its version moves on from its current one, or from the
+// recorded one if it no longer exists, so that edits made against any
+// version in between are rejected.
+func (c *Collection) restore(rec storage.Record) error {
+	version := rec.Version + 1
+	old := c.documents.Get(rec.ID)
+	if old != nil {
+		version = old.Version() + 1
+		if old.String() != rec.Document {
+			if err := c.unindex(old.String(), rec.ID); err != nil {
+				return err
+			}
+		}
+	}
+	doc := rec.Doc()
+	x := nGramFrequency(stringNormalize(rec.Document), c.ngram)
+	doc.SetTokenFrequency(&x)
+	doc.SetVersion(version)
+	if err := c.documents.Put(doc); err != nil {
+		return err
+	}
+	if old == nil || old.String() != rec.Document {
+		return c.index(rec.Document, rec.ID)
+	}
+	c.resuggest(rec.ID)
+	return nil
+}
+
+func containsRecord(recs []storage.Record, id int) bool {
+	return slices.ContainsFunc(recs, func(rec storage.Record) bool { return rec.ID == id })
+}
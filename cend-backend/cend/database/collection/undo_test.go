@@ -0,0 +1,153 @@
+package collection
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestUndoMerge(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "International Business Machines", "I.B.M.", "Intel"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, longName := *c.DocumentID("IBM"), *c.DocumentID("International Business Machines")
+	variant, intel := *c.DocumentID("I.B.M."), *c.DocumentID("Intel")
+	c.DocumentAddFields(longName, &map[string]string{"founded": "1911"})
+	c.DocumentSetPreferred(longName, true, []int{intel})
+	c.DocumentSetPreferred(variant, false, []int{longName})
+	c.DocumentMerge(longName, ibm, AnyVersion, AnyVersion)
+	merge := len(c.HistoryFeed(0, 100))
+
+	undo, err := c.As("alice").Undo(merge)
+	if err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if undo.Op != OpUndo || undo.Undoes != merge || undo.Actor != "alice" {
+		t.Errorf("Expected alice's undo of change %d to be recorded, got %+v", merge, undo)
+	}
+
+	source := c.documents.Get(longName)
+	if source == nil || !c.DocumentExists("International Business Machines") {
+		t.Fatalf("Expected the source to be restored under its ID")
+	}
+	if got := *source.Fields(); !maps.Equal(got, map[string]string{"founded": "1911"}) {
+		t.Errorf("Expected the source's fields to be restored, got %v", got)
+	}
+	if !source.Preferred() || !slices.Equal(source.PreferredDocuments(), []int{intel}) {
+		t.Errorf("Expected the source's links to be restored, got %v %v", source.Preferred(), source.PreferredDocuments())
+	}
+	target := c.documents.Get(ibm)
+	if got := *target.Fields(); len(got) != 0 || target.Preferred() || len(target.PreferredDocuments()) != 0 {
+		t.Errorf("Expected the target to lose what it gained, got %v %v %v", got, target.Preferred(), target.PreferredDocuments())
+	}
+	if got := c.documents.Get(variant).PreferredDocuments(); !slices.Equal(got, []int{longName}) {
+		t.Errorf("Expected the variant to link to the source again, got %v", got)
+	}
+	if results := c.DocumentSearch("international business machines"); len(results) == 0 || results[0].ID != longName {
+		t.Errorf("Expected the source to be searchable again, got %v", results)
+	}
+
+	if _, err := c.Undo(merge); err == nil {
+		t.Errorf("Expected undoing a change twice to fail")
+	}
+	if _, err := c.Undo(1); err == nil {
+		t.Errorf("Expected undoing an add to fail")
+	}
+	if _, err := c.Undo(42); err == nil {
+		t.Errorf("Expected undoing a missing change to fail")
+	}
+}
+
+func TestUndoSplit(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"Mercury", "Mercury Planet"} {
+		c.DocumentAdd(doc)
+	}
+	mercury, planet := *c.DocumentID("Mercury"), *c.DocumentID("Mercury Planet")
+	c.DocumentAddFields(mercury, &map[string]string{"label": "Universal", "orbit": "88 days"})
+	c.DocumentSetPreferred(planet, false, []int{mercury})
+	id, _ := c.DocumentSplit(mercury, AnyVersion, "Mercury (planet)", []string{"orbit"}, []int{planet})
+	split := len(c.HistoryFeed(0, 100))
+
+	if _, err := c.Undo(split); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if c.documents.Get(id) != nil || c.DocumentExists("Mercury (planet)") {
+		t.Errorf("Expected the new document to be removed")
+	}
+	source := c.documents.Get(mercury)
+	if got := *source.Fields(); !maps.Equal(got, map[string]string{"label": "Universal", "orbit": "88 days"}) || source.Version() != 4 {
+		t.Errorf("Expected the source's fields back at version 4, got %v at %d", got, source.Version())
+	}
+	if got := c.documents.Get(planet).PreferredDocuments(); !slices.Equal(got, []int{mercury}) {
+		t.Errorf("Expected the variant to link to the source again, got %v", got)
+	}
+}
+
+func TestUndoRollBack(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "International Business Machines", "I.B.M.", "Mercury", "Mercury Planet"} {
+		c.DocumentAdd(doc)
+	}
+	ibm, longName := *c.DocumentID("IBM"), *c.DocumentID("International Business Machines")
+	variant, mercury, planet := *c.DocumentID("I.B.M."), *c.DocumentID("Mercury"), *c.DocumentID("Mercury Planet")
+	c.DocumentAddFields(mercury, &map[string]string{"orbit": "88 days"})
+	c.DocumentSetPreferred(variant, false, []int{longName})
+	c.DocumentSetPreferred(planet, false, []int{mercury})
+	c.DocumentMerge(longName, ibm, AnyVersion, AnyVersion)
+	merge := len(c.HistoryFeed(0, 100))
+	c.DocumentSplit(mercury, AnyVersion, "Mercury (planet)", []string{"orbit"}, []int{planet})
+	split := len(c.HistoryFeed(0, 100))
+	before := savedRecords(c)
+	list := c.DocumentList()
+
+	for _, seq := range []int{merge, split} {
+		for puts := range 2 {
+			restore := failPut(c, puts)
+			_, err := c.Undo(seq)
+			restore()
+			if err == nil {
+				t.Fatalf("Expected the undo of change %d to fail after %d writes", seq, puts)
+			}
+			if got := savedRecords(c); !reflect.DeepEqual(got, before) {
+				t.Errorf("Expected an undo of change %d failing after %d writes to change nothing, got %v", seq, puts, got)
+			}
+		}
+	}
+	if got := c.DocumentList(); !reflect.DeepEqual(got, list) {
+		t.Errorf("Expected the index to be rolled back to %v, got %v", list, got)
+	}
+	if len(c.HistoryFeed(0, 100)) != split {
+		t.Errorf("Expected failed undos not to be recorded, got %v", ops(c.HistoryFeed(0, 100)))
+	}
+	if _, err := c.Undo(merge); err != nil {
+		t.Errorf("Expected the merge to be undone once writes succeed, got %v", err)
+	}
+}
+
+func TestUndoConflicts(t *testing.T) {
+	c := New("companies", "")
+	for _, doc := range []string{"IBM", "I.B.M."} {
+		c.DocumentAdd(doc)
+	}
+	ibm, variant := *c.DocumentID("IBM"), *c.DocumentID("I.B.M.")
+	c.DocumentMerge(variant, ibm, AnyVersion, AnyVersion)
+	merge := len(c.HistoryFeed(0, 100))
+	c.DocumentAddFields(ibm, &map[string]string{"ticker": "IBM"})
+	c.DocumentAdd("I.B.M.")
+
+	_, err := c.Undo(merge)
+	var conflict *UndoConflictError
+	if !errors.As(err, &conflict) || conflict.Seq != merge || len(conflict.Conflicts) != 2 {
+		t.Fatalf("Expected conflicts on the changed target and the re-added source, got %v", err)
+	}
+	if conflict.Conflicts[0].ID != ibm || conflict.Conflicts[1].ID != variant {
+		t.Errorf("Expected conflicts on documents %d and %d, got %+v", ibm, variant, conflict.Conflicts)
+	}
+	if got := *c.documents.Get(ibm).Fields(); !maps.Equal(got, map[string]string{"ticker": "IBM"}) {
+		t.Errorf("Expected a conflicting undo to change nothing, got %v", got)
+	}
+}
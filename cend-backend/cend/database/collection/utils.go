@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"runtime"
 	"fmt"
+	"slices"
 )
 
 
@@ -19,26 +20,26 @@ func LogInfo(message string) {
 
 func Equal(actual *Collection, expected *Collection) bool {
 	// Compare each key in the lookup table
-	for key, expectedValue := range *expected.lookupTable {
-		actualValue, exists := (*actual.lookupTable)[key]
-		if !exists {
+	for _, key := range expected.lookupTable.Tokens() {
+		if actual.lookupTable.Count(key) == 0 {
 			LogInfo(fmt.Sprintf("Expected key %v in lookup table but was not found", key))
 			return false
 		}
 
 		// Check frequency
-		if actualValue.count != expectedValue.count {
-			LogInfo(fmt.Sprintf("Frequency mismatch for key %v: expected %v, got %v", key, expectedValue.count, actualValue.count))
+		if actualCount, expectedCount := actual.lookupTable.Count(key), expected.lookupTable.Count(key); actualCount != expectedCount {
+			LogInfo(fmt.Sprintf("Frequency mismatch for key %v: expected %v, got %v", key, expectedCount, actualCount))
 			return false
 		}
 
 		// Check document IDs
-		if len(actualValue.docIDs) != len(expectedValue.docIDs) {
-			LogInfo(fmt.Sprintf("DocIDs mismatch for key %v: expected %v, got %v", key, expectedValue.docIDs, actualValue.docIDs))
+		actualIDs, expectedIDs := actual.lookupTable.DocIDs(key), expected.lookupTable.DocIDs(key)
+		if len(actualIDs) != len(expectedIDs) {
+			LogInfo(fmt.Sprintf("DocIDs mismatch for key %v: expected %v, got %v", key, expectedIDs, actualIDs))
 			return false
 		}
-		for docID := range expectedValue.docIDs {
-			if _, exists := actualValue.docIDs[docID]; !exists {
+		for _, docID := range expectedIDs {
+			if !slices.Contains(actualIDs, docID) {
 				LogInfo(fmt.Sprintf("Expected docID %v for key %v not found in actual docIDs", docID, key))
 				return false
 			}
@@ -47,11 +48,12 @@ func Equal(actual *Collection, expected *Collection) bool {
 
 	// Compare documents
 	if expected.documents.Length() != actual.documents.Length() {
-		LogInfo(fmt.Sprintf("Documents mismatch. actual=%v, expected=%v", *expected.documents,*actual.documents ))
+		LogInfo(fmt.Sprintf("Documents mismatch. actual=%v, expected=%v", expected.documents.IDs(), actual.documents.IDs()))
 		return false
 	}
 	
-	for docID, expectedDoc := range expected.documents.Documents() {
+	for _, docID := range expected.documents.IDs() {
+		expectedDoc := expected.documents.Get(docID)
 		actualDoc := actual.documents.Get(docID)
 		if actualDoc == nil {
 			LogInfo(fmt.Sprintf("Expected docID %v not found in actual documents", docID))
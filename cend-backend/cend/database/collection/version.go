@@ -0,0 +1,31 @@
+package collection
+
+import (
+	"fmt"
+
+	"cend/database/collection/documents"
+)
+
+// AnyVersion may be passed as the expected version of a document to skip
+// the version check.
+const AnyVersion = 0
+
+// VersionConflictError reports a change made against a version of a
+// document that is no longer current, as when two curators edit it at once.
+type VersionConflictError struct {
+	ID       int
+	Expected int
+	Actual   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("document %d is at version %d, not %d", e.ID, e.Actual, e.Expected)
+}
+
+// checkVersion returns a *VersionConflictError unless doc is at version.
+func checkVersion(doc *documents.Document, version int) error {
+	if version != AnyVersion && doc.Version() != version {
+		return &VersionConflictError{ID: doc.ID(), Expected: version, Actual: doc.Version()}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package collection
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentVersions(t *testing.T) {
+	c := New("companies", "")
+	c.DocumentAdd("IBM")
+	ibm := *c.DocumentID("IBM")
+	version := func() int { return c.documents.Get(ibm).Version() }
+	if v := version(); v != 1 {
+		t.Fatalf("Expected a new document at version 1, got %d", v)
+	}
+
+	// Every change increments the version.
+	fields := map[string]string{"ticker": "IBM"}
+	c.DocumentAddFields(ibm, &fields)
+	c.DocumentSetPreferred(ibm, true, nil)
+	c.DocumentUpdate(ibm, "I.B.M.", nil)
+	if v := version(); v != 4 {
+		t.Errorf("Expected version 4 after three changes, got %d", v)
+	}
+
+	// A stale version is rejected and nothing changes.
+	err := c.DocumentUpdateIfVersion(ibm, 3, "IBM Corp", nil)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Expected != 3 || conflict.Actual != 4 {
+		t.Fatalf("Expected a version conflict, got %v", err)
+	}
+	if c.DocumentExists("IBM Corp") || version() != 4 {
+		t.Errorf("Expected a conflicting update to change nothing")
+	}
+	if err := c.DocumentRemoveIfVersion(ibm, 3); !errors.As(err, &conflict) {
+		t.Errorf("Expected a version conflict, got %v", err)
+	}
+
+	if err := c.DocumentUpdateIfVersion(ibm, 4, "IBM Corp", nil); err != nil {
+		t.Fatalf("DocumentUpdateIfVersion failed: %v", err)
+	}
+	if err := c.DocumentRemoveIfVersion(ibm, AnyVersion); err != nil {
+		t.Fatalf("DocumentRemoveIfVersion failed: %v", err)
+	}
+	if c.documents.Length() != 0 {
+		t.Errorf("Expected the document to be removed")
+	}
+}
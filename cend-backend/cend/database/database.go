@@ -2,17 +2,43 @@ package database
 
 import (
 	"cend/database/collection"
+	"cend/database/storage"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
 )
 
 type DB struct {
+	mu sync.RWMutex // guards collections; held exclusively by Restore
 	name string
 	path string
 	collections map[string]*collection.Collection
+	engine storage.Engine
+	historyDir string // where collection histories are kept, if anywhere
 } 
 
+// collectionName is the form of the name of a collection, which also names
+// its files under the database's path.
+var collectionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidCollectionName reports whether name can name a new collection.
+func ValidCollectionName(name string) bool {
+	return collectionName.MatchString(name)
+}
+
+// checkCollectionName fails unless name can name a new collection.
+func checkCollectionName(name string) error {
+	if !ValidCollectionName(name) {
+		return fmt.Errorf("invalid collection name %q: must be up to 64 letters, digits, '_', '.' and '-', starting with a letter or digit", name)
+	}
+	return nil
+}
+
 func New(name string) *DB {
 	// TODO: Make this a folder of collections
 	collections := make(map[string]*collection.Collection)
@@ -22,18 +48,191 @@ func New(name string) *DB {
 	if !exists {
 		dbPath = "./cend-db"
 	}
-	return &DB{name: name, path: dbPath, collections: collections}
+	return &DB{name: name, path: dbPath, collections: collections, engine: collection.NewMemoryEngine()}
 }
 
-func (db *DB) AddCollection(name string) {
-	collectionPath := filepath.Join(db.path, name)
-	c := collection.New(name, collectionPath)
+// Open creates a database whose collections are kept by engine, opening
+// every collection the engine already holds.
+func Open(name string, engine storage.Engine) (*DB, error) {
+	names, err := engine.Collections()
+	if err != nil {
+		return nil, fmt.Errorf("listing collections: %w", err)
+	}
+
+	db := New(name)
+	db.engine = engine
+	for _, collectionName := range names {
+		c, err := collection.Open(collectionName, filepath.Join(db.path, collectionName), engine)
+		if err != nil {
+			return nil, err
+		}
+		db.collections[collectionName] = c
+	}
+	return db, nil
+}
+
+// AddCollection creates an empty collection. Adding a collection that
+// already exists leaves it untouched.
+func (db *DB) AddCollection(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err := db.addCollection(name)
+	return err
+}
+
+// addCollection creates a collection unless it exists and returns it. The
+// caller holds the write lock.
+func (db *DB) addCollection(name string) (*collection.Collection, error) {
+	if c, exists := db.collections[name]; exists {
+		return c, nil
+	}
+	if err := checkCollectionName(name); err != nil {
+		return nil, err
+	}
+	if err := db.engine.CreateCollection(name); err != nil {
+		return nil, fmt.Errorf("creating collection %s: %w", name, err)
+	}
+	c, err := collection.Open(name, filepath.Join(db.path, name), db.engine)
+	if err != nil {
+		return nil, err
+	}
+	if db.historyDir != "" {
+		if err := c.OpenHistory(db.historyPath(name)); err != nil {
+			return nil, err
+		}
+	}
 	db.collections[name] = c
+	return c, nil
+}
+
+// OpenHistory keeps the history of every collection in a file under dir,
+// so that it outlives the process.
+func (db *DB) OpenHistory(dir string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.historyDir = dir
+	for name, c := range db.collections {
+		if err := c.OpenHistory(db.historyPath(name)); err != nil {
+			return fmt.Errorf("opening history of collection %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) historyPath(name string) string {
+	return filepath.Join(db.historyDir, url.PathEscape(name)+".jsonl")
 }
 
 func (db *DB) GetCollection(name string)  (*collection.Collection, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	if collection, exists := db.collections[name]; exists {
 		return collection, nil
 	}
 	return nil, fmt.Errorf("collection %s not found", name)
 }
+
+// DropCollection removes a collection with its documents, its history file
+// and the file it was saved to. Requests still holding the collection
+// write to stores that are no longer read.
+func (db *DB) DropCollection(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, exists := db.collections[name]
+	if !exists {
+		return fmt.Errorf("collection %s %w", name, collection.ErrNotFound)
+	}
+	if err := c.Close(); err != nil {
+		return fmt.Errorf("closing history of collection %s: %w", name, err)
+	}
+	if err := db.engine.DropCollection(name); err != nil {
+		return fmt.Errorf("dropping collection %s: %w", name, err)
+	}
+	delete(db.collections, name)
+	files := []string{c.Path}
+	if db.historyDir != "" {
+		files = append(files, db.historyPath(name))
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("dropping collection %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Save writes every collection to its Path in the collection file format.
+// It is how collections of the memory engine outlive a restart.
+func (db *DB) Save() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for name, c := range db.collections {
+		if err := c.Save(); err != nil {
+			return fmt.Errorf("saving collection %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Purge removes the tombstones of documents deleted before the given time
+// from every collection for good and returns how many were purged.
+func (db *DB) Purge(before time.Time) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	purged := 0
+	for name, c := range db.collections {
+		ids, err := c.Purge(before)
+		purged += len(ids)
+		if err != nil {
+			return purged, fmt.Errorf("purging collection %s: %w", name, err)
+		}
+	}
+	return purged, nil
+}
+
+// Load adds every collection saved under the database's path, upgrading
+// files written by older versions, and returns their names. Collections
+// that already have documents are left alone.
+func (db *DB) Load() ([]string, error) {
+	entries, err := os.ReadDir(db.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !ValidCollectionName(name) || !collection.IsSaved(filepath.Join(db.path, name)) {
+			continue
+		}
+		c, err := db.addCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		if c.Length() != 0 {
+			continue
+		}
+		if err := c.Load(); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Close releases the database's storage engine and history files.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	errs := []error{}
+	for _, c := range db.collections {
+		errs = append(errs, c.Close())
+	}
+	errs = append(errs, db.engine.Close())
+	return errors.Join(errs...)
+}
@@ -1,8 +1,15 @@
 package database
 
 import (
+	"cend/database/collection"
+	"cend/database/storage"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDatabaseImplementation(t *testing.T) {
@@ -41,3 +48,154 @@ func TestDatabaseImplementation(t *testing.T) {
 	}
 
 }
+
+func TestOpenRestoresCollections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cend.db")
+	engine, err := storage.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Error opening engine: %s", err)
+	}
+	db, err := Open("test-db", engine)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	if err := db.AddCollection("companies"); err != nil {
+		t.Fatalf("Error adding collection: %s", err)
+	}
+	companies, _ := db.GetCollection("companies")
+	for _, doc := range []string{"IBM", "I.B.M.", "Intel", "Apple"} {
+		if err := companies.DocumentAdd(doc); err != nil {
+			t.Fatalf("Error adding document: %s", err)
+		}
+	}
+	ibm := *companies.DocumentID("IBM")
+	variant := *companies.DocumentID("I.B.M.")
+	fields := map[string]string{"ticker": "IBM"}
+	companies.DocumentAddFields(ibm, &fields)
+	companies.DocumentSetPreferred(ibm, true, nil)
+	companies.DocumentSetPreferred(variant, false, []int{ibm})
+	companies.DocumentRemove(*companies.DocumentID("Apple"))
+
+	db.Close()
+
+	engine, err = storage.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Error reopening engine: %s", err)
+	}
+	reopened, err := Open("test-db", engine)
+	if err != nil {
+		t.Fatalf("Error reopening database: %s", err)
+	}
+	defer reopened.Close()
+	restored, err := reopened.GetCollection("companies")
+	if err != nil {
+		t.Fatalf("Error getting restored collection: %s", err)
+	}
+
+	dc := restored.GetDocumentCollection()
+	if dc.Length() != 3 || restored.DocumentExists("Apple") {
+		t.Errorf("Expected 3 documents without 'Apple', got %v", restored.DocumentList())
+	}
+	if id := restored.DocumentID("IBM"); id == nil || *id != ibm {
+		t.Fatalf("Expected 'IBM' to keep ID %d, got %v", ibm, id)
+	}
+	doc := dc.Get(ibm)
+	if !doc.Preferred() || (*doc.Fields())["ticker"] != "IBM" {
+		t.Errorf("Expected 'IBM' to be preferred with its fields, got preferred=%v fields=%v", doc.Preferred(), doc.Fields())
+	}
+	if links := dc.Get(variant).PreferredDocuments(); len(links) != 1 || links[0] != ibm {
+		t.Errorf("Expected 'I.B.M.' to link to %d, got %v", ibm, links)
+	}
+
+	// The n-gram index is kept by the engine.
+	results := restored.DocumentSearch("intel")
+	if len(results) == 0 || results[0].Document != "Intel" {
+		t.Errorf("Expected search on the restored collection to find 'Intel', got %v", results)
+	}
+
+	// Removed IDs are not reused after reopening.
+	restored.DocumentAdd("Apple")
+	if id := restored.DocumentID("Apple"); id == nil || *id != 5 {
+		t.Errorf("Expected re-added 'Apple' to get ID 5, got %v", id)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Setenv("DB_PATH", t.TempDir())
+	db := snapshotDB(t)
+	if err := db.Save(); err != nil {
+		t.Fatalf("Error saving database: %s", err)
+	}
+
+	loaded := New("test-db")
+	names, err := loaded.Load()
+	if err != nil {
+		t.Fatalf("Error loading database: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"companies", "fruit"}) {
+		t.Errorf("Expected collections [companies fruit] loaded, got %v", names)
+	}
+	for _, name := range []string{"companies", "fruit"} {
+		if want, got := records(t, db, name), records(t, loaded, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected loaded %s to be %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestDropCollection(t *testing.T) {
+	t.Setenv("DB_PATH", t.TempDir())
+	db := New("test-db")
+	if err := db.OpenHistory(t.TempDir()); err != nil {
+		t.Fatalf("Error opening history: %s", err)
+	}
+	for _, name := range []string{"companies", "fruit"} {
+		if err := db.AddCollection(name); err != nil {
+			t.Fatalf("Error adding collection: %s", err)
+		}
+	}
+	fruit, _ := db.GetCollection("fruit")
+	fruit.DocumentAdd("Banana")
+	if err := db.Save(); err != nil {
+		t.Fatalf("Error saving database: %s", err)
+	}
+
+	if err := db.DropCollection("fruit"); err != nil {
+		t.Fatalf("Error dropping collection: %s", err)
+	}
+	if _, err := db.GetCollection("fruit"); err == nil {
+		t.Errorf("Expected the dropped collection to be gone")
+	}
+	if err := db.DropCollection("fruit"); !errors.Is(err, collection.ErrNotFound) {
+		t.Errorf("Expected dropping it again to fail with not found, got %v", err)
+	}
+	if _, err := os.Stat(db.historyPath("fruit")); !os.IsNotExist(err) {
+		t.Errorf("Expected the history file to be removed, got %v", err)
+	}
+
+	// The saved file is removed too, so the collection is not loaded back.
+	loaded := New("test-db")
+	if names, err := loaded.Load(); err != nil || !reflect.DeepEqual(names, []string{"companies"}) {
+		t.Errorf("Expected only companies to be loaded, got %v (err %v)", names, err)
+	}
+
+	// A collection added again under the name starts empty.
+	if err := db.AddCollection("fruit"); err != nil {
+		t.Fatalf("Error adding collection: %s", err)
+	}
+	if fruit, _ = db.GetCollection("fruit"); fruit.Length() != 0 || len(fruit.History(1)) != 0 {
+		t.Errorf("Expected a new fruit collection to be empty, got %v", fruit.DocumentList())
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := snapshotDB(t)
+	if purged, err := db.Purge(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("Expected nothing deleted an hour ago to be purged, got %d (err %v)", purged, err)
+	}
+	if purged, err := db.Purge(time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Errorf("Expected the deleted 'Apple' to be purged, got %d (err %v)", purged, err)
+	}
+	if purged, _ := db.Purge(time.Now().Add(time.Hour)); purged != 0 {
+		t.Errorf("Expected a second purge to find nothing, got %d", purged)
+	}
+}
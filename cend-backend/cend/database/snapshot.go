@@ -0,0 +1,312 @@
+package database
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"cend/database/storage"
+)
+
+// A snapshot is a gzip-compressed tar archive. Its first entry,
+// manifest.json, describes the archive; each collection's documents follow
+// in their own entry as JSON lines, one storage.Record per line in ID
+// order, tombstones included. The n-gram index is not archived, as it is rebuilt from the
+// documents on restore.
+const (
+	SnapshotFormat  = "cend-snapshot"
+	SnapshotVersion = 1
+
+	snapshotManifest = "manifest.json"
+)
+
+// SnapshotManifest describes a snapshot archive.
+type SnapshotManifest struct {
+	Format      string               `json:"format"`
+	Version     int                  `json:"version"`
+	Database    string               `json:"database"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	Collections []SnapshotCollection `json:"collections"`
+}
+
+// SnapshotCollection describes one collection of a snapshot, with the
+// metadata of its index at the time it was taken.
+type SnapshotCollection struct {
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	SHA256    string `json:"sha256"`
+	Documents int    `json:"documents"`
+	NextID    int    `json:"nextId"`
+	NGram     int    `json:"ngram"`
+	Tokens    int    `json:"tokens"`
+}
+
+// RestoreOptions controls RestoreWithOptions.
+type RestoreOptions struct {
+	// Rename maps archived collection names to the names they are restored
+	// under, so that a backup can be checked beside the live collections.
+	// Collections not listed keep their names.
+	Rename map[string]string
+	// MaxBytes bounds the decompressed size of the archive's entries
+	// together, and so of each of them, if it is not 0.
+	MaxBytes int64
+}
+
+// ErrSnapshotTooLarge is returned by RestoreWithOptions when the entries
+// of an archive decompress to more than RestoreOptions.MaxBytes.
+var ErrSnapshotTooLarge = errors.New("snapshot too large")
+
+// Snapshot writes every collection to w as a single archive.
+func (db *DB) Snapshot(w io.Writer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	manifest := SnapshotManifest{
+		Format:      SnapshotFormat,
+		Version:     SnapshotVersion,
+		Database:    db.name,
+		CreatedAt:   time.Now().UTC(),
+		Collections: []SnapshotCollection{},
+	}
+	files := [][]byte{}
+	for i, name := range slices.Sorted(maps.Keys(db.collections)) {
+		c := db.collections[name]
+		dump := c.Dump()
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, rec := range dump.Records {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		manifest.Collections = append(manifest.Collections, SnapshotCollection{
+			Name:      name,
+			File:      fmt.Sprintf("collections/%d.jsonl", i),
+			SHA256:    hex.EncodeToString(sum[:]),
+			Documents: len(dump.Records),
+			NextID:    dump.NextID,
+			NGram:     c.NGram(),
+			Tokens:    dump.Tokens,
+		})
+		files = append(files, buf.Bytes())
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, snapshotManifest, manifestData, manifest.CreatedAt); err != nil {
+		return err
+	}
+	for i, data := range files {
+		if err := writeTarFile(tw, manifest.Collections[i].File, data, manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Restore replaces collections with those in a snapshot archive, keeping
+// their names.
+func (db *DB) Restore(r io.Reader) error {
+	_, err := db.RestoreWithOptions(r, RestoreOptions{})
+	return err
+}
+
+// RestoreWithOptions replaces collections with those in a snapshot archive
+// and returns the names they were restored under. Collections that do not
+// exist are created. The whole archive is read and every record checked as
+// loading it would be before any collection is touched, so a bad archive
+// leaves the collections as they were; only a failing storage engine can
+// stop a restore part way. Restored documents keep their IDs.
+func (db *DB) RestoreWithOptions(r io.Reader, opts RestoreOptions) ([]string, error) {
+	manifest, records, err := readSnapshot(r, opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, len(manifest.Collections))
+	seen := make(map[string]bool)
+	archived := make(map[string]bool)
+	for i, sc := range manifest.Collections {
+		archived[sc.Name] = true
+		targets[i] = sc.Name
+		if name, exists := opts.Rename[sc.Name]; exists {
+			targets[i] = name
+		}
+		if err := checkCollectionName(targets[i]); err != nil {
+			return nil, fmt.Errorf("cannot restore collection %s: %w", sc.Name, err)
+		}
+		if seen[targets[i]] {
+			return nil, fmt.Errorf("more than one collection would be restored as %s", targets[i])
+		}
+		seen[targets[i]] = true
+	}
+	for name := range opts.Rename {
+		if !archived[name] {
+			return nil, fmt.Errorf("collection %s is not in the snapshot", name)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, name := range targets {
+		c, err := db.addCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Clear(); err != nil {
+			return nil, fmt.Errorf("clearing collection %s: %w", name, err)
+		}
+		for _, rec := range records[i] {
+			if err := c.DocumentLoad(rec); err != nil {
+				return nil, fmt.Errorf("restoring collection %s: %w", name, err)
+			}
+		}
+		if err := c.SetNextID(manifest.Collections[i].NextID); err != nil {
+			return nil, fmt.Errorf("restoring collection %s: %w", name, err)
+		}
+	}
+	return targets, nil
+}
+
+// readSnapshot reads and verifies a snapshot archive, returning the
+// records of each collection in manifest order. Its entries may decompress
+// to at most maxBytes together, if it is not 0.
+func readSnapshot(r io.Reader, maxBytes int64) (SnapshotManifest, [][]storage.Record, error) {
+	var manifest SnapshotManifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	if header.Name != snapshotManifest {
+		return manifest, nil, fmt.Errorf("reading snapshot: expected %s first, got %s", snapshotManifest, header.Name)
+	}
+	remaining := maxBytes
+	readEntry := func() ([]byte, error) {
+		if maxBytes == 0 {
+			return io.ReadAll(tr)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, remaining+1))
+		remaining -= int64(len(data))
+		if remaining < 0 {
+			return nil, fmt.Errorf("%w: entries decompress to more than %d bytes", ErrSnapshotTooLarge, maxBytes)
+		}
+		return data, err
+	}
+	data, err := readEntry()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("reading snapshot manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("reading snapshot manifest: %w", err)
+	}
+	if manifest.Format != SnapshotFormat {
+		return manifest, nil, fmt.Errorf("not a snapshot: format %q", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > SnapshotVersion {
+		return manifest, nil, fmt.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		data, err := readEntry()
+		if err != nil {
+			return manifest, nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		files[header.Name] = data
+	}
+
+	records := make([][]storage.Record, len(manifest.Collections))
+	for i, sc := range manifest.Collections {
+		data, exists := files[sc.File]
+		if !exists {
+			return manifest, nil, fmt.Errorf("snapshot is missing %s for collection %s", sc.File, sc.Name)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != sc.SHA256 {
+			return manifest, nil, fmt.Errorf("checksum mismatch for collection %s", sc.Name)
+		}
+		records[i], err = readRecords(data)
+		if err != nil {
+			return manifest, nil, fmt.Errorf("collection %s: %w", sc.Name, err)
+		}
+		if len(records[i]) != sc.Documents {
+			return manifest, nil, fmt.Errorf("collection %s: expected %d documents, got %d", sc.Name, sc.Documents, len(records[i]))
+		}
+		for _, rec := range records[i] {
+			if rec.ID >= sc.NextID {
+				return manifest, nil, fmt.Errorf("collection %s: document %d is not below next ID %d", sc.Name, rec.ID, sc.NextID)
+			}
+		}
+	}
+	return manifest, records, nil
+}
+
+// readRecords decodes JSON lines of records, rejecting what
+// Collection.DocumentLoad would: IDs below 1, repeated IDs and live
+// documents with the same text. A tombstone may share its text with any
+// other document.
+func readRecords(data []byte) ([]storage.Record, error) {
+	records := []storage.Record{}
+	ids := make(map[int]bool)
+	docs := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		var rec storage.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.ID < 1 {
+			return nil, fmt.Errorf("line %d: invalid document ID %d", line, rec.ID)
+		}
+		if ids[rec.ID] || (rec.Deleted == nil && docs[rec.Document]) {
+			return nil, fmt.Errorf("line %d: duplicate document %d", line, rec.ID)
+		}
+		ids[rec.ID] = true
+		if rec.Deleted == nil {
+			docs[rec.Document] = true
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cend/database/collection"
+	"cend/database/storage"
+)
+
+// snapshotDB returns a database with two collections, one with fields,
+// preferred links and a removed document.
+func snapshotDB(t *testing.T) *DB {
+	db := New("test-db")
+	for _, name := range []string{"companies", "fruit"} {
+		if err := db.AddCollection(name); err != nil {
+			t.Fatalf("Error adding collection: %s", err)
+		}
+	}
+	companies, _ := db.GetCollection("companies")
+	for _, doc := range []string{"IBM", "I.B.M.", "Intel", "Apple"} {
+		companies.DocumentAdd(doc)
+	}
+	ibm := *companies.DocumentID("IBM")
+	fields := map[string]string{"ticker": "IBM"}
+	companies.DocumentAddFields(ibm, &fields)
+	companies.DocumentSetPreferred(ibm, true, nil)
+	companies.DocumentSetPreferred(*companies.DocumentID("I.B.M."), false, []int{ibm})
+	companies.DocumentRemove(*companies.DocumentID("Apple"))
+
+	fruit, _ := db.GetCollection("fruit")
+	fruit.DocumentAdd("Banana")
+	return db
+}
+
+// records returns the stored form of every document in a collection.
+func records(t *testing.T, db *DB, name string) []storage.Record {
+	t.Helper()
+	c, err := db.GetCollection(name)
+	if err != nil {
+		t.Fatalf("Error getting collection: %s", err)
+	}
+	docs := c.GetDocumentCollection()
+	recs := []storage.Record{}
+	for _, id := range docs.IDs() {
+		recs = append(recs, storage.RecordOf(docs.Get(id)))
+	}
+	return recs
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	db := snapshotDB(t)
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatalf("Snapshot failed: %s", err)
+	}
+
+	restored := New("restored-db")
+	if err := restored.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	for _, name := range []string{"companies", "fruit"} {
+		if want, got := records(t, db, name), records(t, restored, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected restored %s to be %v, got %v", name, want, got)
+		}
+	}
+	companies, _ := restored.GetCollection("companies")
+	if results := companies.DocumentSearch("intel"); len(results) == 0 || results[0].Document != "Intel" {
+		t.Errorf("Expected the index to be rebuilt on restore, got %v", results)
+	}
+
+	// Restoring over live collections replaces their documents.
+	fruit, _ := db.GetCollection("fruit")
+	fruit.DocumentAdd("Cherry")
+	if err := db.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	if docs := fruit.DocumentList(); !reflect.DeepEqual(docs, []string{"Banana"}) {
+		t.Errorf("Expected restore to replace fruit with [Banana], got %v", docs)
+	}
+}
+
+// TestRestoreKeepsNextID checks that IDs of documents removed before a
+// snapshot are not handed out again after restoring it.
+func TestRestoreKeepsNextID(t *testing.T) {
+	db := snapshotDB(t)
+	companies, _ := db.GetCollection("companies")
+	if _, err := companies.Purge(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Purge failed: %s", err)
+	}
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatalf("Snapshot failed: %s", err)
+	}
+
+	restored := New("restored-db")
+	if err := restored.Restore(&archive); err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	companies, _ = restored.GetCollection("companies")
+	if next := companies.GetDocumentCollection().NextID(); next != 5 {
+		t.Errorf("Expected next ID 5 after restore, got %d", next)
+	}
+	companies.DocumentAdd("Nvidia")
+	if id := companies.DocumentID("Nvidia"); id == nil || *id != 5 {
+		t.Errorf("Expected the next document to get ID 5, got %v", id)
+	}
+}
+
+// TestSnapshotKeepsTombstones checks that removed documents are archived
+// and can be restored after the snapshot is, even when a live document
+// took over their text.
+func TestSnapshotKeepsTombstones(t *testing.T) {
+	db := snapshotDB(t)
+	companies, _ := db.GetCollection("companies")
+	apple, intel := 4, *companies.DocumentID("Intel") // snapshotDB removed Apple, its fourth document
+	companies.DocumentRemove(intel)
+	companies.DocumentAdd("Apple")
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatalf("Snapshot failed: %s", err)
+	}
+
+	restored := New("restored-db")
+	if err := restored.Restore(&archive); err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	companies, _ = restored.GetCollection("companies")
+	if doc := companies.Tombstone(apple); doc == nil || doc.String() != "Apple" {
+		t.Errorf("Expected Apple to be restored as a tombstone, got %v", doc)
+	}
+	if id := companies.DocumentID("Apple"); id == nil || *id == apple {
+		t.Errorf("Expected the live Apple to keep its own ID, got %v", id)
+	}
+	if err := companies.DocumentRestore(intel, collection.AnyVersion); err != nil {
+		t.Fatalf("DocumentRestore failed: %s", err)
+	}
+	if results := companies.DocumentSearch("intel"); len(results) == 0 || results[0].ID != intel {
+		t.Errorf("Expected Intel to be found once it is restored, got %v", results)
+	}
+}
+
+// TestSnapshotDuringWrites checks that a snapshot taken while documents
+// are added holds each collection as it was at one moment.
+func TestSnapshotDuringWrites(t *testing.T) {
+	db := snapshotDB(t)
+	fruit, _ := db.GetCollection("fruit")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			fruit.DocumentAdd(fmt.Sprintf("Fruit %d", i))
+		}
+	}()
+
+	for range 20 {
+		var archive bytes.Buffer
+		if err := db.Snapshot(&archive); err != nil {
+			t.Fatalf("Snapshot failed: %s", err)
+		}
+		manifest, _, err := readSnapshot(bytes.NewReader(archive.Bytes()), 0)
+		if err != nil {
+			t.Fatalf("Error reading snapshot: %s", err)
+		}
+		restored := New("restored-db")
+		if err := restored.Restore(&archive); err != nil {
+			t.Fatalf("Restore failed: %s", err)
+		}
+		c, _ := restored.GetCollection("fruit")
+		if sc := manifest.Collections[1]; c.IndexSize() != sc.Tokens || c.GetDocumentCollection().NextID() != sc.NextID {
+			t.Fatalf("Expected %d tokens and next ID %d, as the manifest says, got %d and %d", sc.Tokens, sc.NextID, c.IndexSize(), c.GetDocumentCollection().NextID())
+		}
+	}
+	<-done
+}
+
+func TestRestoreRename(t *testing.T) {
+	db := snapshotDB(t)
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatalf("Snapshot failed: %s", err)
+	}
+	fruit, _ := db.GetCollection("fruit")
+	fruit.DocumentAdd("Cherry")
+
+	names, err := db.RestoreWithOptions(&archive, RestoreOptions{Rename: map[string]string{"fruit": "fruit-backup"}})
+	if err != nil {
+		t.Fatalf("RestoreWithOptions failed: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"companies", "fruit-backup"}) {
+		t.Errorf("Expected collections [companies fruit-backup] restored, got %v", names)
+	}
+	if docs := fruit.DocumentList(); len(docs) != 2 {
+		t.Errorf("Expected live fruit to be untouched, got %v", docs)
+	}
+	backup, err := db.GetCollection("fruit-backup")
+	if err != nil {
+		t.Fatalf("Error getting restored collection: %s", err)
+	}
+	if docs := backup.DocumentList(); !reflect.DeepEqual(docs, []string{"Banana"}) {
+		t.Errorf("Expected fruit-backup to hold [Banana], got %v", docs)
+	}
+}
+
+// rewriteSnapshot returns a copy of archive with edit applied to each
+// entry's contents.
+func rewriteSnapshot(t *testing.T, archive []byte, edit func(name string, data []byte) []byte) []byte {
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err)
+	}
+	tr := tar.NewReader(gzr)
+	var out bytes.Buffer
+	gzw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		data = edit(header.Name, data)
+		header.Size = int64(len(data))
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+	gzw.Close()
+	return out.Bytes()
+}
+
+// resealSnapshot returns a copy of archive with edit applied to each
+// collection's documents and the manifest's checksums updated to match.
+func resealSnapshot(t *testing.T, archive []byte, edit func(data []byte) []byte) []byte {
+	sums := make(map[string]string)
+	archive = rewriteSnapshot(t, archive, func(name string, data []byte) []byte {
+		if name == snapshotManifest {
+			return data
+		}
+		data = edit(data)
+		sum := sha256.Sum256(data)
+		sums[name] = hex.EncodeToString(sum[:])
+		return data
+	})
+	return rewriteSnapshot(t, archive, func(name string, data []byte) []byte {
+		if name != snapshotManifest {
+			return data
+		}
+		var m SnapshotManifest
+		json.Unmarshal(data, &m)
+		for i, sc := range m.Collections {
+			m.Collections[i].SHA256 = sums[sc.File]
+		}
+		data, _ = json.Marshal(m)
+		return data
+	})
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	db := snapshotDB(t)
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatalf("Snapshot failed: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		opts    RestoreOptions
+		wantErr string
+	}{
+		{
+			name:    "not gzip",
+			archive: []byte("hello"),
+			wantErr: "reading snapshot",
+		},
+		{
+			name: "newer version",
+			archive: rewriteSnapshot(t, archive.Bytes(), func(name string, data []byte) []byte {
+				if name != snapshotManifest {
+					return data
+				}
+				var m map[string]any
+				json.Unmarshal(data, &m)
+				m["version"] = SnapshotVersion + 1
+				data, _ = json.Marshal(m)
+				return data
+			}),
+			wantErr: "unsupported snapshot version",
+		},
+		{
+			name: "corrupt documents",
+			archive: rewriteSnapshot(t, archive.Bytes(), func(name string, data []byte) []byte {
+				return bytes.ReplaceAll(data, []byte("Banana"), []byte("Bandana"))
+			}),
+			wantErr: "checksum mismatch",
+		},
+		{
+			name: "invalid ID",
+			archive: resealSnapshot(t, archive.Bytes(), func(data []byte) []byte {
+				return bytes.ReplaceAll(data, []byte(`{"id":1,"document":"Banana"`), []byte(`{"id":0,"document":"Banana"`))
+			}),
+			wantErr: "invalid document ID 0",
+		},
+		{
+			name: "next ID below the documents",
+			archive: rewriteSnapshot(t, archive.Bytes(), func(name string, data []byte) []byte {
+				if name != snapshotManifest {
+					return data
+				}
+				return bytes.ReplaceAll(data, []byte(`"nextId": 2`), []byte(`"nextId": 1`))
+			}),
+			wantErr: "is not below next ID 1",
+		},
+		{
+			name:    "too large",
+			archive: archive.Bytes(),
+			opts:    RestoreOptions{MaxBytes: 100},
+			wantErr: "snapshot too large",
+		},
+		{
+			name:    "unknown rename",
+			archive: archive.Bytes(),
+			opts:    RestoreOptions{Rename: map[string]string{"vegetables": "greens"}},
+			wantErr: "not in the snapshot",
+		},
+		{
+			name:    "rename outside the database",
+			archive: archive.Bytes(),
+			opts:    RestoreOptions{Rename: map[string]string{"fruit": "../../etc/x"}},
+			wantErr: "invalid collection name",
+		},
+		{
+			name: "name outside the database",
+			archive: rewriteSnapshot(t, archive.Bytes(), func(name string, data []byte) []byte {
+				if name != snapshotManifest {
+					return data
+				}
+				return bytes.ReplaceAll(data, []byte(`"name": "fruit"`), []byte(`"name": "../fruit"`))
+			}),
+			wantErr: "invalid collection name",
+		},
+		{
+			name:    "colliding rename",
+			archive: archive.Bytes(),
+			opts:    RestoreOptions{Rename: map[string]string{"fruit": "companies"}},
+			wantErr: "more than one collection",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.RestoreWithOptions(bytes.NewReader(tt.archive), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			// Nothing is touched when the archive is rejected.
+			if docs := records(t, db, "fruit"); len(docs) != 1 || docs[0].Document != "Banana" {
+				t.Errorf("Expected fruit to be untouched, got %v", docs)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"cend/database/collection/documents"
+)
+
+// Bucket layout of a BoltEngine file:
+//
+//	collections/<name>/documents        big-endian ID -> JSON Record
+//	collections/<name>/postings/<token> big-endian doc ID -> empty; the
+//	                                    bucket's sequence is the count
+//	collections/<name>/meta             "next_id", "count" -> big-endian uint64
+//	engine                              "layout" -> big-endian uint64
+//
+// Each token has a bucket of its own so that adding or removing a document
+// writes one key, and reading its documents walks a cursor.
+var (
+	boltCollections = []byte("collections")
+	boltDocuments   = []byte("documents")
+	boltPostings    = []byte("postings")
+	boltMeta        = []byte("meta")
+	boltNextID      = []byte("next_id")
+	boltCount       = []byte("count")
+	boltEngine      = []byte("engine")
+	boltLayout      = []byte("layout")
+)
+
+// boltLayoutVersion is the bucket layout OpenBolt writes. Layout 1 kept
+// each token's documents in one value: a uvarint count, then uvarint IDs.
+const boltLayoutVersion = 2
+
+// BoltEngine keeps every collection in a single bbolt file. Reads go
+// straight to the memory-mapped file, so documents and postings do not have
+// to fit in the Go heap.
+type BoltEngine struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the bbolt file at path.
+func OpenBolt(path string) (*BoltEngine, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		collections, err := tx.CreateBucketIfNotExists(boltCollections)
+		if err != nil {
+			return err
+		}
+		engine, err := tx.CreateBucketIfNotExists(boltEngine)
+		if err != nil {
+			return err
+		}
+		if boltUint(engine, boltLayout) < boltLayoutVersion {
+			names := [][]byte{}
+			collections.ForEachBucket(func(name []byte) error {
+				names = append(names, bytes.Clone(name))
+				return nil
+			})
+			for _, name := range names {
+				if err := migrateBoltPostings(collections.Bucket(name)); err != nil {
+					return fmt.Errorf("migrating collection %s: %w", name, err)
+				}
+			}
+		}
+		return boltPutUint(engine, boltLayout, boltLayoutVersion)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltEngine{db: db}, nil
+}
+
+func (e *BoltEngine) CreateCollection(name string) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		c, err := tx.Bucket(boltCollections).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, bucket := range [][]byte{boltDocuments, boltPostings, boltMeta} {
+			if _, err := c.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *BoltEngine) DropCollection(name string) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltCollections).DeleteBucket([]byte(name))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (e *BoltEngine) Collections() ([]string, error) {
+	names := []string{}
+	err := e.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCollections).ForEachBucket(func(name []byte) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+	return names, err
+}
+
+func (e *BoltEngine) exists(collection string) error {
+	return e.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltCollections).Bucket([]byte(collection)) == nil {
+			return fmt.Errorf("collection %s not found", collection)
+		}
+		return nil
+	})
+}
+
+func (e *BoltEngine) Documents(collection string) (DocumentStore, error) {
+	if err := e.exists(collection); err != nil {
+		return nil, err
+	}
+	return &boltDocumentStore{db: e.db, collection: []byte(collection)}, nil
+}
+
+func (e *BoltEngine) Postings(collection string) (PostingsStore, error) {
+	if err := e.exists(collection); err != nil {
+		return nil, err
+	}
+	return &boltPostingsStore{db: e.db, collection: []byte(collection)}, nil
+}
+
+func (e *BoltEngine) Close() error {
+	return e.db.Close()
+}
+
+func boltBucket(tx *bolt.Tx, collection, bucket []byte) *bolt.Bucket {
+	return tx.Bucket(boltCollections).Bucket(collection).Bucket(bucket)
+}
+
+func boltKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+func boltUint(b *bolt.Bucket, key []byte) int {
+	v := b.Get(key)
+	if v == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func boltPutUint(b *bolt.Bucket, key []byte, n int) error {
+	return b.Put(key, boltKey(n))
+}
+
+type boltDocumentStore struct {
+	db         *bolt.DB
+	collection []byte
+}
+
+func (s *boltDocumentStore) Get(id int) *documents.Document {
+	var rec *Record
+	s.db.View(func(tx *bolt.Tx) error {
+		data := boltBucket(tx, s.collection, boltDocuments).Get(boltKey(id))
+		if data == nil {
+			return nil
+		}
+		rec = &Record{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			rec = nil
+		}
+		return nil
+	})
+	if rec == nil {
+		return nil
+	}
+	return rec.Doc()
+}
+
+func (s *boltDocumentStore) Put(doc *documents.Document) error {
+	if doc == nil {
+		return fmt.Errorf("cannot put nil document")
+	}
+	data, err := json.Marshal(RecordOf(doc))
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		docs := boltBucket(tx, s.collection, boltDocuments)
+		meta := boltBucket(tx, s.collection, boltMeta)
+		key := boltKey(doc.ID())
+		if docs.Get(key) == nil {
+			if err := boltPutUint(meta, boltCount, boltUint(meta, boltCount)+1); err != nil {
+				return err
+			}
+		}
+		if err := boltPutUint(meta, boltNextID, max(boltUint(meta, boltNextID), doc.ID()+1)); err != nil {
+			return err
+		}
+		return docs.Put(key, data)
+	})
+}
+
+func (s *boltDocumentStore) RemoveDocument(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		docs := boltBucket(tx, s.collection, boltDocuments)
+		key := boltKey(id)
+		if docs.Get(key) == nil {
+			return nil
+		}
+		meta := boltBucket(tx, s.collection, boltMeta)
+		if err := boltPutUint(meta, boltCount, boltUint(meta, boltCount)-1); err != nil {
+			return err
+		}
+		return docs.Delete(key)
+	})
+}
+
+func (s *boltDocumentStore) IDs() []int {
+	ids := []int{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return boltBucket(tx, s.collection, boltDocuments).ForEach(func(k, _ []byte) error {
+			ids = append(ids, int(binary.BigEndian.Uint64(k)))
+			return nil
+		})
+	})
+	return ids
+}
+
+func (s *boltDocumentStore) Length() int {
+	var n int
+	s.db.View(func(tx *bolt.Tx) error {
+		n = boltUint(boltBucket(tx, s.collection, boltMeta), boltCount)
+		return nil
+	})
+	return n
+}
+
+func (s *boltDocumentStore) NextID() int {
+	next := 1
+	s.db.View(func(tx *bolt.Tx) error {
+		next = max(next, boltUint(boltBucket(tx, s.collection, boltMeta), boltNextID))
+		return nil
+	})
+	return next
+}
+
+func (s *boltDocumentStore) SetNextID(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta := boltBucket(tx, s.collection, boltMeta)
+		return boltPutUint(meta, boltNextID, max(boltUint(meta, boltNextID), id))
+	})
+}
+
+type boltPostingsStore struct {
+	db         *bolt.DB
+	collection []byte
+}
+
+// migrateBoltPostings moves the postings of a collection from layout 1,
+// one value per token, to a bucket per token.
+func migrateBoltPostings(c *bolt.Bucket) error {
+	lists := make(map[string][]int)
+	err := c.Bucket(boltPostings).ForEach(func(k, v []byte) error {
+		if v != nil {
+			lists[string(k)] = decodePostings(v)
+		}
+		return nil
+	})
+	if err != nil || len(lists) == 0 {
+		return err
+	}
+	for token, ids := range lists {
+		if err := c.Bucket(boltPostings).Delete([]byte(token)); err != nil {
+			return err
+		}
+		if err := boltAddPostings(c.Bucket(boltPostings), []string{token}, ids...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodePostings decodes a layout 1 posting list: a uvarint count, then
+// uvarint doc IDs.
+func decodePostings(data []byte) []int {
+	n, read := binary.Uvarint(data)
+	ids := make([]int, 0, n)
+	for data = data[read:]; len(data) > 0; data = data[read:] {
+		var id uint64
+		id, read = binary.Uvarint(data)
+		ids = append(ids, int(id))
+	}
+	return ids
+}
+
+// boltHas reports whether b has key, which may hold an empty value.
+func boltHas(b *bolt.Bucket, key []byte) bool {
+	k, _ := b.Cursor().Seek(key)
+	return bytes.Equal(k, key)
+}
+
+// boltAddPostings lists each of ids under each of tokens.
+func boltAddPostings(postings *bolt.Bucket, tokens []string, ids ...int) error {
+	for _, token := range tokens {
+		b, err := postings.CreateBucketIfNotExists([]byte(token))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			key := boltKey(id)
+			if boltHas(b, key) {
+				continue
+			}
+			if err := b.Put(key, []byte{}); err != nil {
+				return err
+			}
+			if err := b.SetSequence(b.Sequence() + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *boltPostingsStore) Add(docID int, tokens []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltAddPostings(boltBucket(tx, s.collection, boltPostings), tokens, docID)
+	})
+}
+
+func (s *boltPostingsStore) Remove(docID int, tokens []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		postings := boltBucket(tx, s.collection, boltPostings)
+		key := boltKey(docID)
+		for _, token := range tokens {
+			b := postings.Bucket([]byte(token))
+			if b == nil || !boltHas(b, key) {
+				continue
+			}
+			var err error
+			if b.Sequence() <= 1 {
+				err = postings.DeleteBucket([]byte(token))
+			} else if err = b.Delete(key); err == nil {
+				err = b.SetSequence(b.Sequence() - 1)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltPostingsStore) DocIDs(token string) []int {
+	ids := []int{}
+	s.db.View(func(tx *bolt.Tx) error {
+		b := boltBucket(tx, s.collection, boltPostings).Bucket([]byte(token))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			ids = append(ids, int(binary.BigEndian.Uint64(k)))
+		}
+		return nil
+	})
+	return ids
+}
+
+func (s *boltPostingsStore) Count(token string) int {
+	var n uint64
+	s.db.View(func(tx *bolt.Tx) error {
+		if b := boltBucket(tx, s.collection, boltPostings).Bucket([]byte(token)); b != nil {
+			n = b.Sequence()
+		}
+		return nil
+	})
+	return int(n)
+}
+
+func (s *boltPostingsStore) Tokens() []string {
+	tokens := []string{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return boltBucket(tx, s.collection, boltPostings).ForEachBucket(func(k []byte) error {
+			tokens = append(tokens, string(k))
+			return nil
+		})
+	})
+	return tokens
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestBoltMigratesPostings checks that a file written with layout 1, a
+// value per token, is opened with every posting kept.
+func TestBoltMigratesPostings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cend.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		collections, err := tx.CreateBucket(boltCollections)
+		if err != nil {
+			return err
+		}
+		c, err := collections.CreateBucket([]byte("companies"))
+		if err != nil {
+			return err
+		}
+		for _, bucket := range [][]byte{boltDocuments, boltPostings, boltMeta} {
+			if _, err := c.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		for token, ids := range map[string][]int{"ibm": {1, 2}, "bex": {2}} {
+			list := binary.AppendUvarint(nil, uint64(len(ids)))
+			for _, id := range ids {
+				list = binary.AppendUvarint(list, uint64(id))
+			}
+			if err := c.Bucket(boltPostings).Put([]byte(token), list); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.Close()
+	}
+	if err != nil {
+		t.Fatalf("Error writing layout 1: %v", err)
+	}
+
+	for range 2 { // the second open finds the file migrated already
+		e, err := OpenBolt(path)
+		if err != nil {
+			t.Fatalf("OpenBolt failed: %v", err)
+		}
+		postings, err := e.Postings("companies")
+		if err != nil {
+			t.Fatalf("Postings failed: %v", err)
+		}
+		if tokens := postings.Tokens(); !reflect.DeepEqual(tokens, []string{"bex", "ibm"}) {
+			t.Errorf("Expected tokens [bex ibm], got %v", tokens)
+		}
+		ids := postings.DocIDs("ibm")
+		slices.Sort(ids)
+		if !reflect.DeepEqual(ids, []int{1, 2}) || postings.Count("ibm") != 2 || postings.Count("bex") != 1 {
+			t.Errorf("Expected ibm in documents [1 2] and bex in one, got %v and %d", ids, postings.Count("bex"))
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
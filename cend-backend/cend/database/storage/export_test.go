@@ -0,0 +1,9 @@
+package storage
+
+// Hooks for the tests in package storage_test.
+var (
+	NewFakePostgres  = newFakePostgres
+	OpenFakePostgres = openFakePostgres
+)
+
+type FakePostgres = fakePostgres
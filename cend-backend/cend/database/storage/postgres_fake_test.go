@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fakePostgres is an in-process stand-in for the PostgreSQL server used to
+// test PostgresEngine without a container. It understands exactly the
+// statements PostgresEngine issues, enforces the primary and foreign keys of
+// the schema, and cascades deletes. Transactions are not isolated.
+type fakePostgres struct {
+	mu          sync.Mutex
+	ddl         int // number of CREATE TABLE and ALTER TABLE statements executed
+	migrations  map[int]bool
+	collections map[string]int // name -> next_id
+	documents   map[fakeKey]fakeDocument
+	fields      map[fakeKey]map[string]string
+	links       map[fakeKey]map[int]int // position -> preferred_id
+	postings    map[fakePosting]struct{}
+}
+
+type fakeKey struct {
+	collection string
+	id         int
+}
+
+type fakePosting struct {
+	collection string
+	token      string
+	id         int
+}
+
+type fakeDocument struct {
+	document    string
+	isPreferred bool
+	version     int
+	deleted     driver.Value // nil or time.Time
+}
+
+func newFakePostgres() *fakePostgres {
+	return &fakePostgres{
+		migrations:  make(map[int]bool),
+		collections: make(map[string]int),
+		documents:   make(map[fakeKey]fakeDocument),
+		fields:      make(map[fakeKey]map[string]string),
+		links:       make(map[fakeKey]map[int]int),
+		postings:    make(map[fakePosting]struct{}),
+	}
+}
+
+// openFakePostgres returns a *sql.DB backed by fake.
+func openFakePostgres(fake *fakePostgres) *sql.DB {
+	return sql.OpenDB(fakeConnector{fake})
+}
+
+type fakeConnector struct{ fake *fakePostgres }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fake postgres: use openFakePostgres")
+}
+
+type fakeConn struct{ fake *fakePostgres }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{fake: c.fake, query: query}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	fake  *fakePostgres
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch s.query {
+	case sqlInsertMigration:
+		version := int(args[0].(int64))
+		if f.migrations[version] {
+			return nil, fmt.Errorf("fake postgres: duplicate migration version %d", version)
+		}
+		f.migrations[version] = true
+	case sqlInsertCollection:
+		name := args[0].(string)
+		if _, exists := f.collections[name]; !exists {
+			f.collections[name] = 1
+		}
+	case sqlDeleteCollection:
+		name := args[0].(string)
+		delete(f.collections, name)
+		for key := range f.documents {
+			if key.collection == name {
+				delete(f.documents, key)
+				delete(f.fields, key)
+				delete(f.links, key)
+			}
+		}
+		for posting := range f.postings {
+			if posting.collection == name {
+				delete(f.postings, posting)
+			}
+		}
+	case sqlUpdateNextID:
+		name := args[0].(string)
+		if next, exists := f.collections[name]; exists {
+			f.collections[name] = max(next, int(args[1].(int64)))
+		}
+	case sqlUpsertDocument:
+		key := fakeKey{args[0].(string), int(args[1].(int64))}
+		if _, exists := f.collections[key.collection]; !exists {
+			return nil, fmt.Errorf("fake postgres: collection %s violates foreign key", key.collection)
+		}
+		f.documents[key] = fakeDocument{args[2].(string), args[3].(bool), int(args[4].(int64)), args[5]}
+	case sqlDeleteFields:
+		delete(f.fields, fakeKey{args[0].(string), int(args[1].(int64))})
+	case sqlInsertField:
+		key := fakeKey{args[0].(string), int(args[1].(int64))}
+		if _, exists := f.documents[key]; !exists {
+			return nil, fmt.Errorf("fake postgres: field of missing document %v", key)
+		}
+		if f.fields[key] == nil {
+			f.fields[key] = make(map[string]string)
+		}
+		f.fields[key][args[2].(string)] = args[3].(string)
+	case sqlDeleteLinks:
+		delete(f.links, fakeKey{args[0].(string), int(args[1].(int64))})
+	case sqlInsertLink:
+		key := fakeKey{args[0].(string), int(args[1].(int64))}
+		if _, exists := f.documents[key]; !exists {
+			return nil, fmt.Errorf("fake postgres: link of missing document %v", key)
+		}
+		if f.links[key] == nil {
+			f.links[key] = make(map[int]int)
+		}
+		f.links[key][int(args[2].(int64))] = int(args[3].(int64))
+	case sqlDeleteDocument:
+		key := fakeKey{args[0].(string), int(args[1].(int64))}
+		delete(f.documents, key)
+		delete(f.fields, key)
+		delete(f.links, key)
+	case sqlInsertPosting:
+		posting := fakePosting{args[0].(string), args[1].(string), int(args[2].(int64))}
+		if _, exists := f.collections[posting.collection]; !exists {
+			return nil, fmt.Errorf("fake postgres: collection %s violates foreign key", posting.collection)
+		}
+		f.postings[posting] = struct{}{}
+	case sqlDeletePosting:
+		delete(f.postings, fakePosting{args[0].(string), args[1].(string), int(args[2].(int64))})
+	default:
+		if !strings.HasPrefix(s.query, "CREATE TABLE") && !strings.HasPrefix(s.query, "ALTER TABLE") {
+			return nil, fmt.Errorf("fake postgres: unsupported statement %q", s.query)
+		}
+		f.ddl++
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	f := s.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := &fakeRows{}
+	switch s.query {
+	case sqlSelectMigrations:
+		rows.columns = []string{"version"}
+		for version := range f.migrations {
+			rows.values = append(rows.values, []driver.Value{int64(version)})
+		}
+	case sqlSelectCollections:
+		rows.columns = []string{"name"}
+		for _, name := range slices.Sorted(maps.Keys(f.collections)) {
+			rows.values = append(rows.values, []driver.Value{name})
+		}
+	case sqlSelectNextID:
+		rows.columns = []string{"next_id"}
+		if next, exists := f.collections[args[0].(string)]; exists {
+			rows.values = append(rows.values, []driver.Value{int64(next)})
+		}
+	case sqlSelectDocuments:
+		rows.columns = []string{"id", "document", "is_preferred", "version", "deleted"}
+		for _, key := range f.keys(args[0].(string)) {
+			doc := f.documents[key]
+			rows.values = append(rows.values, []driver.Value{int64(key.id), doc.document, doc.isPreferred, int64(doc.version), doc.deleted})
+		}
+	case sqlSelectFields:
+		rows.columns = []string{"document_id", "key", "value"}
+		for _, key := range f.keys(args[0].(string)) {
+			for field, value := range f.fields[key] {
+				rows.values = append(rows.values, []driver.Value{int64(key.id), field, value})
+			}
+		}
+	case sqlSelectLinks:
+		rows.columns = []string{"document_id", "preferred_id"}
+		for _, key := range f.keys(args[0].(string)) {
+			links := f.links[key]
+			for _, position := range slices.Sorted(maps.Keys(links)) {
+				rows.values = append(rows.values, []driver.Value{int64(key.id), int64(links[position])})
+			}
+		}
+	case sqlSelectPostings:
+		rows.columns = []string{"token", "document_id"}
+		for posting := range f.postings {
+			if posting.collection == args[0].(string) {
+				rows.values = append(rows.values, []driver.Value{posting.token, int64(posting.id)})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("fake postgres: unsupported query %q", s.query)
+	}
+	return rows, nil
+}
+
+// keys returns the document keys of a collection in ID order.
+func (f *fakePostgres) keys(collection string) []fakeKey {
+	keys := []fakeKey{}
+	for key := range f.documents {
+		if key.collection == collection {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b fakeKey) int { return a.id - b.id })
+	return keys
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestPostgresMigrations(t *testing.T) {
+	fake := newFakePostgres()
+	for i := 0; i < 2; i++ {
+		if _, err := NewPostgresEngine(openFakePostgres(fake)); err != nil {
+			t.Fatalf("NewPostgresEngine failed: %v", err)
+		}
+	}
+
+	if len(fake.migrations) != len(postgresMigrations) {
+		t.Errorf("Expected %d migrations recorded, got %d", len(postgresMigrations), len(fake.migrations))
+	}
+	// One CREATE TABLE for the migrations table per open, plus the schema
+	// statements applied once.
+	want := 2
+	for _, m := range postgresMigrations {
+		want += len(m.statements)
+	}
+	if fake.ddl != want {
+		t.Errorf("Expected %d schema statements, got %d", want, fake.ddl)
+	}
+}
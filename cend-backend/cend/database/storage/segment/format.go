@@ -0,0 +1,249 @@
+// Package segment implements an inverted index made of immutable,
+// memory-mapped segment files plus a small mutable segment for recent
+// writes. Segments are merged in the background so their number stays
+// bounded.
+package segment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// A segment file is laid out as follows, with integers little-endian:
+//
+//	header    "CSEG", uint32 version
+//	postings  per term in dictionary order: uvarint document count, then
+//	          uvarint gaps between ascending document IDs
+//	dict      per term in sorted order: uvarint length, then its bytes
+//	index     per term: uint64 dict offset, uint64 postings offset
+//	footer    uint64 term count, uint64 dict offset, uint64 index offset,
+//	          uint32 CRC-32 (IEEE) of everything before it
+//
+// The fixed-width index allows binary search over the dictionary without
+// reading it into memory.
+const (
+	segmentMagic   = "CSEG"
+	segmentVersion = 1
+	headerSize     = 8
+	footerSize     = 28
+	indexEntrySize = 16
+)
+
+// segmentWriter streams a segment to disk. Terms must be added in sorted
+// order; only the dictionary and index are buffered in memory.
+type segmentWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	crc     io.Writer
+	hash    uint32
+	offset  uint64
+	dict    bytes.Buffer
+	entries []uint64 // dict offset, postings offset per term
+	last    string
+	terms   uint64
+}
+
+func createSegment(path string) (*segmentWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &segmentWriter{file: f, buf: bufio.NewWriter(f)}
+	header := binary.LittleEndian.AppendUint32([]byte(segmentMagic), segmentVersion)
+	if err := w.write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *segmentWriter) write(p []byte) error {
+	w.hash = crc32.Update(w.hash, crc32.IEEETable, p)
+	w.offset += uint64(len(p))
+	_, err := w.buf.Write(p)
+	return err
+}
+
+// add appends a term with its document IDs, which must be ascending.
+func (w *segmentWriter) add(term string, ids []int) error {
+	if w.terms > 0 && term <= w.last {
+		return fmt.Errorf("segment terms out of order: %q after %q", term, w.last)
+	}
+	w.entries = append(w.entries, uint64(w.dict.Len()), w.offset)
+	w.dict.Write(binary.AppendUvarint(nil, uint64(len(term))))
+	w.dict.WriteString(term)
+	w.last = term
+	w.terms++
+
+	postings := binary.AppendUvarint(nil, uint64(len(ids)))
+	prev := 0
+	for _, id := range ids {
+		postings = binary.AppendUvarint(postings, uint64(id-prev))
+		prev = id
+	}
+	return w.write(postings)
+}
+
+// finish writes the dictionary, index and footer and syncs the file.
+func (w *segmentWriter) finish() error {
+	defer w.file.Close()
+	dictOffset := w.offset
+	if err := w.write(w.dict.Bytes()); err != nil {
+		return err
+	}
+	indexOffset := w.offset
+	index := make([]byte, 0, len(w.entries)*8)
+	for i := 0; i < len(w.entries); i += 2 {
+		index = binary.LittleEndian.AppendUint64(index, dictOffset+w.entries[i])
+		index = binary.LittleEndian.AppendUint64(index, w.entries[i+1])
+	}
+	if err := w.write(index); err != nil {
+		return err
+	}
+	footer := binary.LittleEndian.AppendUint64(nil, w.terms)
+	footer = binary.LittleEndian.AppendUint64(footer, dictOffset)
+	footer = binary.LittleEndian.AppendUint64(footer, indexOffset)
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(binary.LittleEndian.AppendUint32(nil, w.hash)); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// abort discards a partially written segment.
+func (w *segmentWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// segment is a read-only view of a segment file.
+type segment struct {
+	name   string
+	data   []byte
+	mapped bool // data must be unmapped on close
+	terms  int
+	index  int // offset of the term index
+}
+
+func openSegment(path, name string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, mapped, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("mapping segment %s: %w", name, err)
+	}
+	s := &segment{name: name, data: data, mapped: mapped}
+	if err := s.validate(); err != nil {
+		s.close()
+		return nil, fmt.Errorf("segment %s: %w", name, err)
+	}
+	return s, nil
+}
+
+func (s *segment) validate() error {
+	if len(s.data) < headerSize+footerSize || string(s.data[:4]) != segmentMagic {
+		return fmt.Errorf("not a segment file")
+	}
+	if version := binary.LittleEndian.Uint32(s.data[4:8]); version != segmentVersion {
+		return fmt.Errorf("unsupported segment version %d", version)
+	}
+	body := s.data[:len(s.data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(s.data[len(body):]) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	footer := body[len(body)-24:]
+	terms := binary.LittleEndian.Uint64(footer)
+	index := binary.LittleEndian.Uint64(footer[16:])
+	if index+terms*indexEntrySize != uint64(len(body)-24) {
+		return fmt.Errorf("corrupt footer")
+	}
+	s.terms, s.index = int(terms), int(index)
+	return nil
+}
+
+func (s *segment) close() error {
+	if !s.mapped {
+		return nil
+	}
+	return unmapFile(s.data)
+}
+
+// term returns the i-th term of the dictionary, sharing the segment's
+// memory.
+func (s *segment) term(i int) []byte {
+	offset := binary.LittleEndian.Uint64(s.data[s.index+i*indexEntrySize:])
+	n, read := binary.Uvarint(s.data[offset:])
+	start := int(offset) + read
+	return s.data[start : start+int(n)]
+}
+
+// postingsAt returns the encoded postings of the i-th term.
+func (s *segment) postingsAt(i int) []byte {
+	offset := binary.LittleEndian.Uint64(s.data[s.index+i*indexEntrySize+8:])
+	return s.data[offset:]
+}
+
+// find returns the position of term in the dictionary.
+func (s *segment) find(term string) (int, bool) {
+	i := sort.Search(s.terms, func(i int) bool { return string(s.term(i)) >= term })
+	return i, i < s.terms && string(s.term(i)) == term
+}
+
+func (s *segment) count(term string) int {
+	i, ok := s.find(term)
+	if !ok {
+		return 0
+	}
+	n, _ := binary.Uvarint(s.postingsAt(i))
+	return int(n)
+}
+
+// docIDs appends the documents containing the i-th term to ids.
+func (s *segment) docIDs(i int, ids []int) []int {
+	data := s.postingsAt(i)
+	n, read := binary.Uvarint(data)
+	data = data[read:]
+	id := 0
+	for ; n > 0; n-- {
+		gap, read := binary.Uvarint(data)
+		data = data[read:]
+		id += int(gap)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *segment) contains(term string, docID int) bool {
+	i, ok := s.find(term)
+	if !ok {
+		return false
+	}
+	data := s.postingsAt(i)
+	n, read := binary.Uvarint(data)
+	data = data[read:]
+	id := 0
+	for ; n > 0 && id < docID; n-- {
+		gap, read := binary.Uvarint(data)
+		data = data[read:]
+		id += int(gap)
+	}
+	return id == docID
+}
@@ -0,0 +1,580 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	manifestFile  = "MANIFEST"
+	logFile       = "wal.log"
+	segmentSuffix = ".seg"
+)
+
+// Defaults for zero-valued Options.
+const (
+	DefaultFlushThreshold = 100000
+	DefaultMergeFactor    = 8
+)
+
+// Options tunes an Index. Zero values select the defaults.
+type Options struct {
+	// FlushThreshold is the number of postings the mutable segment holds
+	// before it is written out as a segment file.
+	FlushThreshold int
+	// MergeFactor is the number of segment files at which the smallest of
+	// them are merged into one.
+	MergeFactor int
+}
+
+// postingSet maps tokens to sets of document IDs.
+type postingSet map[string]map[int]struct{}
+
+func (p postingSet) has(token string, docID int) bool {
+	_, exists := p[token][docID]
+	return exists
+}
+
+func (p postingSet) add(token string, docID int) {
+	if p[token] == nil {
+		p[token] = make(map[int]struct{})
+	}
+	p[token][docID] = struct{}{}
+}
+
+// remove reports whether the posting was present.
+func (p postingSet) remove(token string, docID int) bool {
+	if !p.has(token, docID) {
+		return false
+	}
+	delete(p[token], docID)
+	if len(p[token]) == 0 {
+		delete(p, token)
+	}
+	return true
+}
+
+// Index is an inverted index stored in a directory. Postings live in
+// immutable segment files, which are memory-mapped, and in a mutable
+// segment holding writes since the last flush. Removing a posting from a
+// segment file records it as deleted until a merge rewrites the segment
+// without it. Every write is appended to a log and synced to disk before it
+// is acknowledged, so a crash loses nothing that was acknowledged.
+//
+// Index implements storage.PostingsStore. It is safe for concurrent use.
+type Index struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	segments []*segment
+	mutable  postingSet // postings added since the last flush
+	size     int        // number of postings in mutable
+	deleted  postingSet // postings removed from segment files
+	next     int        // number of the next segment file
+	log      *os.File
+	closed   bool
+	merging  bool
+	mergeErr error // first background merge failure, reported by Close
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the index in dir, creating it if needed, and replays writes
+// logged since the last flush.
+func Open(dir string, opts Options) (*Index, error) {
+	if opts.FlushThreshold <= 0 {
+		opts.FlushThreshold = DefaultFlushThreshold
+	}
+	if opts.MergeFactor < 2 {
+		opts.MergeFactor = DefaultMergeFactor
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	idx := &Index{
+		dir:     dir,
+		opts:    opts,
+		mutable: postingSet{},
+		deleted: postingSet{},
+		next:    m.Next,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := idx.removeOrphans(m.Segments); err != nil {
+		return nil, err
+	}
+	for _, name := range m.Segments {
+		seg, err := openSegment(filepath.Join(dir, name), name)
+		if err != nil {
+			idx.closeSegments()
+			return nil, err
+		}
+		idx.segments = append(idx.segments, seg)
+	}
+	for token, ids := range m.Deleted {
+		for _, id := range ids {
+			idx.deleted.add(token, id)
+		}
+	}
+
+	logPath := filepath.Join(dir, logFile)
+	if err := replayLog(logPath, idx.apply); err != nil {
+		idx.closeSegments()
+		return nil, fmt.Errorf("replaying log: %w", err)
+	}
+	idx.log, err = os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		idx.closeSegments()
+		return nil, err
+	}
+	if idx.size >= opts.FlushThreshold {
+		if err := idx.flushLocked(); err != nil {
+			idx.log.Close()
+			idx.closeSegments()
+			return nil, err
+		}
+	}
+
+	idx.wg.Add(1)
+	go idx.mergeLoop()
+	idx.signalMerge()
+	return idx, nil
+}
+
+// removeOrphans deletes segment files left behind by an interrupted flush
+// or merge.
+func (idx *Index) removeOrphans(keep []string) error {
+	entries, err := os.ReadDir(idx.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, segmentSuffix) && !slices.Contains(keep, name) {
+			if err := os.Remove(filepath.Join(idx.dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (idx *Index) closeSegments() {
+	for _, seg := range idx.segments {
+		seg.close()
+	}
+	idx.segments = nil
+}
+
+func (idx *Index) inSegments(token string, docID int) bool {
+	for _, seg := range idx.segments {
+		if seg.contains(token, docID) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply performs a logged operation. The caller holds the write lock.
+func (idx *Index) apply(op byte, docID int, tokens []string) {
+	for _, token := range tokens {
+		switch op {
+		case opAdd:
+			switch {
+			case idx.mutable.has(token, docID):
+			case idx.deleted.remove(token, docID):
+				// A deleted posting is still in its segment file.
+			case idx.inSegments(token, docID):
+			default:
+				idx.mutable.add(token, docID)
+				idx.size++
+			}
+		case opRemove:
+			if idx.mutable.remove(token, docID) {
+				idx.size--
+			} else if !idx.deleted.has(token, docID) && idx.inSegments(token, docID) {
+				idx.deleted.add(token, docID)
+			}
+		}
+	}
+}
+
+// write logs and applies an operation, flushing the mutable segment once
+// it reaches the threshold.
+func (idx *Index) write(op byte, docID int, tokens []string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.closed {
+		return fmt.Errorf("index is closed")
+	}
+	if err := idx.writeLog(encodeOp(op, docID, tokens)); err != nil {
+		return err
+	}
+	idx.apply(op, docID, tokens)
+	if idx.size >= idx.opts.FlushThreshold {
+		return idx.flushLocked()
+	}
+	return nil
+}
+
+// writeLog appends records to the log and syncs it. The caller holds the
+// write lock.
+func (idx *Index) writeLog(records []byte) error {
+	if _, err := idx.log.Write(records); err != nil {
+		return fmt.Errorf("writing log: %w", err)
+	}
+	if err := idx.log.Sync(); err != nil {
+		return fmt.Errorf("syncing log: %w", err)
+	}
+	return nil
+}
+
+func (idx *Index) Add(docID int, tokens []string) error {
+	return idx.write(opAdd, docID, tokens)
+}
+
+func (idx *Index) Remove(docID int, tokens []string) error {
+	return idx.write(opRemove, docID, tokens)
+}
+
+func (idx *Index) DocIDs(token string) []int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ids := []int{}
+	for _, seg := range idx.segments {
+		if i, ok := seg.find(token); ok {
+			ids = seg.docIDs(i, ids)
+		}
+	}
+	if deleted := idx.deleted[token]; len(deleted) > 0 {
+		ids = slices.DeleteFunc(ids, func(id int) bool {
+			_, exists := deleted[id]
+			return exists
+		})
+	}
+	for id := range idx.mutable[token] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (idx *Index) Count(token string) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.countLocked(token)
+}
+
+func (idx *Index) countLocked(token string) int {
+	n := len(idx.mutable[token]) - len(idx.deleted[token])
+	for _, seg := range idx.segments {
+		n += seg.count(token)
+	}
+	return n
+}
+
+func (idx *Index) Tokens() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	tokens := make(map[string]struct{}, len(idx.mutable))
+	for token := range idx.mutable {
+		tokens[token] = struct{}{}
+	}
+	for _, seg := range idx.segments {
+		for i := 0; i < seg.terms; i++ {
+			tokens[string(seg.term(i))] = struct{}{}
+		}
+	}
+	for token := range idx.deleted {
+		if idx.countLocked(token) == 0 {
+			delete(tokens, token)
+		}
+	}
+	return slices.Sorted(maps.Keys(tokens))
+}
+
+// Flush writes the mutable segment to a segment file and empties the log.
+func (idx *Index) Flush() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.closed {
+		return fmt.Errorf("index is closed")
+	}
+	return idx.flushLocked()
+}
+
+func (idx *Index) flushLocked() error {
+	if idx.size > 0 {
+		name := fmt.Sprintf("%06d%s", idx.next, segmentSuffix)
+		seg, err := idx.writeSegment(name, func(w *segmentWriter) error {
+			for _, token := range slices.Sorted(maps.Keys(idx.mutable)) {
+				if err := w.add(token, slices.Sorted(maps.Keys(idx.mutable[token]))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("flushing index: %w", err)
+		}
+		idx.next++
+		idx.segments = append(idx.segments, seg)
+		idx.mutable = postingSet{}
+		idx.size = 0
+	}
+
+	if err := idx.writeManifest(); err != nil {
+		return err
+	}
+	if err := idx.log.Truncate(0); err != nil {
+		return fmt.Errorf("truncating log: %w", err)
+	}
+	idx.signalMerge()
+	return nil
+}
+
+// writeSegment writes and opens a segment file.
+func (idx *Index) writeSegment(name string, fill func(w *segmentWriter) error) (*segment, error) {
+	path := filepath.Join(idx.dir, name)
+	w, err := createSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := fill(w); err != nil {
+		w.abort()
+		return nil, err
+	}
+	if err := w.finish(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return openSegment(path, name)
+}
+
+// writeManifest records the current segment files and deletions. The
+// caller holds the write lock.
+func (idx *Index) writeManifest() error {
+	m := manifest{Version: 1, Next: idx.next, Segments: []string{}}
+	for _, seg := range idx.segments {
+		m.Segments = append(m.Segments, seg.name)
+	}
+	if len(idx.deleted) > 0 {
+		m.Deleted = make(map[string][]int, len(idx.deleted))
+		for token, ids := range idx.deleted {
+			m.Deleted[token] = slices.Sorted(maps.Keys(ids))
+		}
+	}
+	if err := writeManifest(filepath.Join(idx.dir, manifestFile), m); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
+
+func (idx *Index) signalMerge() {
+	if len(idx.segments) < idx.opts.MergeFactor {
+		return
+	}
+	select {
+	case idx.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (idx *Index) mergeLoop() {
+	defer idx.wg.Done()
+	for {
+		select {
+		case <-idx.done:
+			return
+		case <-idx.wake:
+			if err := idx.merge(false); err != nil {
+				idx.mu.Lock()
+				if idx.mergeErr == nil {
+					idx.mergeErr = err
+				}
+				idx.mu.Unlock()
+			}
+		}
+	}
+}
+
+// mergeUnlocked is called while a merge runs without the lock, so tests
+// can write to the index meanwhile.
+var mergeUnlocked = func() {}
+
+// Merge merges every segment file into one, dropping deleted postings.
+func (idx *Index) Merge() error {
+	return idx.merge(true)
+}
+
+// merge rewrites several segment files as one without holding the lock, so
+// reads and writes continue meanwhile. Unless all is set, only the
+// MergeFactor smallest files are merged, and only once there are that
+// many.
+func (idx *Index) merge(all bool) error {
+	idx.mu.Lock()
+	if idx.closed || idx.merging {
+		idx.mu.Unlock()
+		return nil
+	}
+	inputs := slices.Clone(idx.segments)
+	if !all {
+		if len(inputs) < idx.opts.MergeFactor {
+			idx.mu.Unlock()
+			return nil
+		}
+		slices.SortFunc(inputs, func(a, b *segment) int { return len(a.data) - len(b.data) })
+		inputs = inputs[:idx.opts.MergeFactor]
+	}
+	if len(inputs) < 2 && len(idx.deleted) == 0 {
+		idx.mu.Unlock()
+		return nil
+	}
+	deleted := make(postingSet, len(idx.deleted))
+	for token, ids := range idx.deleted {
+		deleted[token] = maps.Clone(ids)
+	}
+	name := fmt.Sprintf("%06d%s", idx.next, segmentSuffix)
+	idx.next++
+	idx.merging = true
+	idx.wg.Add(1) // Close waits for the merge before unmapping its inputs
+	defer idx.wg.Done()
+	idx.mu.Unlock()
+	mergeUnlocked()
+
+	applied := postingSet{}
+	merged, err := idx.writeSegment(name, func(w *segmentWriter) error {
+		return mergeSegments(w, inputs, deleted, applied)
+	})
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.merging = false
+	if err != nil {
+		return fmt.Errorf("merging segments: %w", err)
+	}
+
+	// A posting added back while the merge ran is no longer in any segment
+	// file, so it moves to the mutable segment. Its add may have left the
+	// log in a flush since, so it is logged again before the merged file
+	// replaces the inputs.
+	readded := make(map[int][]string)
+	for token, ids := range applied {
+		for id := range ids {
+			if !idx.deleted.has(token, id) {
+				readded[id] = append(readded[id], token)
+			}
+		}
+	}
+	records := []byte{}
+	for _, id := range slices.Sorted(maps.Keys(readded)) {
+		records = append(records, encodeOp(opAdd, id, readded[id])...)
+	}
+	if len(records) > 0 {
+		if err := idx.writeLog(records); err != nil {
+			merged.close()
+			os.Remove(filepath.Join(idx.dir, merged.name))
+			return fmt.Errorf("merging segments: %w", err)
+		}
+	}
+
+	segments := []*segment{}
+	for _, seg := range idx.segments {
+		if !slices.Contains(inputs, seg) {
+			segments = append(segments, seg)
+		}
+	}
+	idx.segments = append(segments, merged)
+	for token, ids := range applied {
+		for id := range ids {
+			if !idx.deleted.remove(token, id) {
+				idx.mutable.add(token, id)
+				idx.size++
+			}
+		}
+	}
+	if err := idx.writeManifest(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, seg := range inputs {
+		errs = append(errs, seg.close(), os.Remove(filepath.Join(idx.dir, seg.name)))
+	}
+	return errors.Join(errs...)
+}
+
+// mergeSegments writes the union of the inputs' postings in term order,
+// leaving out those in deleted and recording them in applied.
+func mergeSegments(w *segmentWriter, inputs []*segment, deleted, applied postingSet) error {
+	positions := make([]int, len(inputs))
+	for {
+		var term []byte
+		for i, seg := range inputs {
+			if positions[i] < seg.terms {
+				if t := seg.term(positions[i]); term == nil || string(t) < string(term) {
+					term = t
+				}
+			}
+		}
+		if term == nil {
+			return nil
+		}
+
+		token := string(term)
+		ids := []int{}
+		for i, seg := range inputs {
+			if positions[i] < seg.terms && string(seg.term(positions[i])) == token {
+				ids = seg.docIDs(positions[i], ids)
+				positions[i]++
+			}
+		}
+		ids = slices.DeleteFunc(ids, func(id int) bool {
+			if deleted.has(token, id) {
+				applied.add(token, id)
+				return true
+			}
+			return false
+		})
+		if len(ids) == 0 {
+			continue
+		}
+		slices.Sort(ids)
+		if err := w.add(token, ids); err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops background merging, flushes the mutable segment and unmaps
+// the segment files.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	if idx.closed {
+		idx.mu.Unlock()
+		return nil
+	}
+	// Closing first turns away writes and merges started from here on.
+	idx.closed = true
+	idx.mu.Unlock()
+	close(idx.done)
+	idx.wg.Wait()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	err := errors.Join(idx.flushLocked(), idx.mergeErr, idx.log.Close())
+	idx.closeSegments()
+	return err
+}
@@ -0,0 +1,246 @@
+package segment
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// crash abandons an index without flushing, as if the process died.
+func crash(idx *Index) {
+	idx.mu.Lock()
+	idx.closed = true
+	idx.mu.Unlock()
+	close(idx.done)
+	idx.wg.Wait()
+	idx.log.Close()
+	idx.closeSegments()
+}
+
+// checkModel fails unless idx holds exactly the postings in model.
+func checkModel(t *testing.T, idx *Index, model postingSet, step string) {
+	t.Helper()
+	if tokens := idx.Tokens(); !reflect.DeepEqual(tokens, slices.Sorted(maps.Keys(model))) {
+		t.Fatalf("%s: expected tokens %v, got %v", step, slices.Sorted(maps.Keys(model)), tokens)
+	}
+	for token, ids := range model {
+		got := idx.DocIDs(token)
+		slices.Sort(got)
+		if want := slices.Sorted(maps.Keys(ids)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %q in %v, got %v", step, token, want, got)
+		}
+		if n := idx.Count(token); n != len(ids) {
+			t.Fatalf("%s: expected count %d for %q, got %d", step, len(ids), token, n)
+		}
+	}
+}
+
+// TestIndexMatchesModel applies random writes, flushes, merges, restarts
+// and crashes, checking the index against a plain set of postings.
+func TestIndexMatchesModel(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{FlushThreshold: 7, MergeFactor: 3}
+	idx, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() { idx.Close() }()
+
+	rng := rand.New(rand.NewSource(1))
+	tokens := []string{"app", "ppl", "ple", "les", "ibm", "bm ", " ib", "int"}
+	model := postingSet{}
+	for step := 0; step < 400; step++ {
+		docID := rng.Intn(12) + 1
+		batch := []string{}
+		for _, token := range tokens {
+			if rng.Intn(3) == 0 {
+				batch = append(batch, token)
+			}
+		}
+
+		switch r := rng.Intn(20); {
+		case r < 11:
+			if err := idx.Add(docID, batch); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+			for _, token := range batch {
+				model.add(token, docID)
+			}
+		case r < 18:
+			if err := idx.Remove(docID, batch); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			for _, token := range batch {
+				model.remove(token, docID)
+			}
+		case r == 18:
+			if err := idx.Merge(); err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+		default:
+			if rng.Intn(2) == 0 {
+				if err := idx.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+			} else {
+				crash(idx)
+			}
+			if idx, err = Open(dir, opts); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+		}
+		checkModel(t, idx, model, fmt.Sprintf("step %d", step))
+	}
+}
+
+func TestMergeDropsDeletedPostings(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := Open(dir, Options{FlushThreshold: 2, MergeFactor: 100})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for id := 1; id <= 6; id++ {
+		idx.Add(id, []string{"app", "ppl"})
+	}
+	idx.Remove(2, []string{"app", "ppl"})
+	idx.Remove(5, []string{"app"})
+	if len(idx.segments) < 2 || len(idx.deleted) == 0 {
+		t.Fatalf("Expected several segments with deletions, got %d segments and %v deleted", len(idx.segments), idx.deleted)
+	}
+
+	if err := idx.Merge(); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(idx.segments) != 1 || len(idx.deleted) != 0 {
+		t.Errorf("Expected one segment without deletions, got %d segments and %v deleted", len(idx.segments), idx.deleted)
+	}
+	if n := idx.Count("app"); n != 4 {
+		t.Errorf("Expected app in 4 documents, got %d", n)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Merged inputs are removed from disk.
+	entries, _ := os.ReadDir(dir)
+	segments := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), segmentSuffix) {
+			segments++
+		}
+	}
+	if segments != 1 {
+		t.Errorf("Expected 1 segment file after merging, got %d", segments)
+	}
+}
+
+func TestBackgroundMerge(t *testing.T) {
+	idx, err := Open(t.TempDir(), Options{FlushThreshold: 1, MergeFactor: 2})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer idx.Close()
+	for id := 1; id <= 50; id++ {
+		if err := idx.Add(id, []string{"ibm"}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	// Merges run concurrently with writes, so only the result is checked.
+	ids := idx.DocIDs("ibm")
+	slices.Sort(ids)
+	if len(ids) != 50 || ids[0] != 1 || ids[49] != 50 {
+		t.Errorf("Expected ibm in documents 1-50, got %v", ids)
+	}
+}
+
+func TestSegmentFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.seg")
+	w, err := createSegment(path)
+	if err != nil {
+		t.Fatalf("createSegment failed: %v", err)
+	}
+	postings := map[string][]int{
+		" ib": {1},
+		"app": {1, 2, 300, 70000},
+		"ibm": {5, 6},
+	}
+	for _, term := range slices.Sorted(maps.Keys(postings)) {
+		if err := w.add(term, postings[term]); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	if err := w.add("aaa", []int{1}); err == nil {
+		t.Errorf("Expected out-of-order term to fail")
+	}
+	if err := w.finish(); err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+
+	seg, err := openSegment(path, "000001.seg")
+	if err != nil {
+		t.Fatalf("openSegment failed: %v", err)
+	}
+	for term, ids := range postings {
+		i, ok := seg.find(term)
+		if !ok {
+			t.Fatalf("Expected term %q in segment", term)
+		}
+		if got := seg.docIDs(i, nil); !reflect.DeepEqual(got, ids) {
+			t.Errorf("Expected %q in %v, got %v", term, ids, got)
+		}
+		if n := seg.count(term); n != len(ids) {
+			t.Errorf("Expected count %d for %q, got %d", len(ids), term, n)
+		}
+	}
+	if !seg.contains("app", 300) || seg.contains("app", 301) || seg.contains("xyz", 1) {
+		t.Errorf("Unexpected result from contains")
+	}
+	seg.close()
+
+	// A flipped byte is caught by the checksum.
+	data, _ := os.ReadFile(path)
+	data[headerSize] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	if _, err := openSegment(path, "000001.seg"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected checksum error, got %v", err)
+	}
+}
+
+// TestMergeKeepsPostingsAddedBack checks that a posting removed from a
+// segment file and added back while a merge drops it survives a crash, even
+// once a flush has emptied the log it was added in.
+func TestMergeKeepsPostingsAddedBack(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{FlushThreshold: 100, MergeFactor: 100}
+	idx, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	idx.Add(1, []string{"app"})
+	idx.Flush()
+	idx.Add(2, []string{"app"})
+	idx.Flush()
+	idx.Remove(1, []string{"app"})
+
+	mergeUnlocked = func() {
+		idx.Add(1, []string{"app"})
+		idx.Flush()
+	}
+	defer func() { mergeUnlocked = func() {} }()
+	if err := idx.Merge(); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	crash(idx)
+
+	if idx, err = Open(dir, opts); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer idx.Close()
+	checkModel(t, idx, postingSet{"app": {1: {}, 2: {}}}, "after crash")
+}
@@ -0,0 +1,122 @@
+package segment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Operations recorded in the write-ahead log. Each record is an operation
+// byte, the uvarint document ID, a uvarint token count, then each token as
+// a uvarint length followed by its bytes.
+const (
+	opAdd    byte = 'a'
+	opRemove byte = 'r'
+)
+
+func encodeOp(op byte, docID int, tokens []string) []byte {
+	record := []byte{op}
+	record = binary.AppendUvarint(record, uint64(docID))
+	record = binary.AppendUvarint(record, uint64(len(tokens)))
+	for _, token := range tokens {
+		record = binary.AppendUvarint(record, uint64(len(token)))
+		record = append(record, token...)
+	}
+	return record
+}
+
+// replayLog calls apply for every complete record in the log at path. A
+// record cut short by a crash ends the replay.
+func replayLog(path string, apply func(op byte, docID int, tokens []string)) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		docID, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil
+		}
+		tokens := make([]string, 0, n)
+		for ; n > 0; n-- {
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil
+			}
+			token := make([]byte, size)
+			if _, err := io.ReadFull(r, token); err != nil {
+				return nil
+			}
+			tokens = append(tokens, string(token))
+		}
+		apply(op, int(docID), tokens)
+	}
+}
+
+// manifest records which segment files make up the index, and the postings
+// removed from them since they were written.
+type manifest struct {
+	Version  int              `json:"version"`
+	Next     int              `json:"next"` // number of the next segment file
+	Segments []string         `json:"segments"`
+	Deleted  map[string][]int `json:"deleted,omitempty"`
+}
+
+func readManifest(path string) (manifest, error) {
+	m := manifest{Version: 1, Next: 1}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// writeManifest replaces the manifest atomically.
+func writeManifest(path string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cend/database/storage/segment"
+)
+
+// SegmentEngine keeps documents in another engine and each collection's
+// postings in a segment.Index, a directory of memory-mapped segment files
+// under dir. Postings then stay out of the Go heap whatever engine holds
+// the documents.
+type SegmentEngine struct {
+	Engine
+	dir     string
+	opts    segment.Options
+	mu      sync.Mutex
+	indexes map[string]*segment.Index
+}
+
+// NewSegmentEngine returns an engine keeping documents in docs and
+// postings under dir.
+func NewSegmentEngine(docs Engine, dir string, opts segment.Options) *SegmentEngine {
+	return &SegmentEngine{
+		Engine:  docs,
+		dir:     dir,
+		opts:    opts,
+		indexes: make(map[string]*segment.Index),
+	}
+}
+
+func (e *SegmentEngine) Postings(collection string) (PostingsStore, error) {
+	if _, err := e.Engine.Documents(collection); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if idx, exists := e.indexes[collection]; exists {
+		return idx, nil
+	}
+	idx, err := segment.Open(filepath.Join(e.dir, url.PathEscape(collection)), e.opts)
+	if err != nil {
+		return nil, err
+	}
+	e.indexes[collection] = idx
+	return idx, nil
+}
+
+// DropCollection closes and deletes the collection's index, then drops
+// its documents.
+func (e *SegmentEngine) DropCollection(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if idx, exists := e.indexes[name]; exists {
+		delete(e.indexes, name)
+		if err := idx.Close(); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(e.dir, url.PathEscape(name))); err != nil {
+		return err
+	}
+	return e.Engine.DropCollection(name)
+}
+
+// Close flushes and closes every index, then the document engine.
+func (e *SegmentEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var errs []error
+	for _, idx := range e.indexes {
+		errs = append(errs, idx.Close())
+	}
+	e.indexes = make(map[string]*segment.Index)
+	return errors.Join(append(errs, e.Engine.Close())...)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"time"
+
+	"cend/database/collection/documents"
+)
+
+// Engine creates and opens the stores backing each collection of a
+// database. Implementations are chosen by configuration; every engine must
+// pass the conformance suite in storagetest.
+type Engine interface {
+	// CreateCollection registers a collection. Creating an existing
+	// collection is not an error.
+	CreateCollection(name string) error
+	// DropCollection removes a collection and everything in it. Dropping a
+	// missing collection is not an error.
+	DropCollection(name string) error
+	// Collections returns the names of all collections in sorted order.
+	Collections() ([]string, error)
+	// Documents returns the document store of a collection. Repeated calls
+	// return stores over the same data.
+	Documents(collection string) (DocumentStore, error)
+	// Postings returns the inverted index of a collection.
+	Postings(collection string) (PostingsStore, error)
+	Close() error
+}
+
+// DocumentStore holds the documents of one collection by ID. Documents
+// returned by Get may be copies, so changes must be written back with Put.
+type DocumentStore interface {
+	// Get returns the document with the given ID, or nil.
+	Get(id int) *documents.Document
+	// Put inserts or replaces a document under its ID.
+	Put(doc *documents.Document) error
+	// RemoveDocument removes the document with the given ID, if any.
+	RemoveDocument(id int) error
+	// IDs returns the IDs of all documents in ascending order.
+	IDs() []int
+	Length() int
+	// NextID returns the ID for the next new document. IDs are never
+	// reused, even after the document holding them is removed.
+	NextID() int
+	// SetNextID advances NextID to id, as when restoring documents whose
+	// last IDs were removed. It never moves NextID backwards.
+	SetNextID(id int) error
+}
+
+// PostingsStore is the inverted index of one collection, mapping each token
+// to the set of documents containing it.
+type PostingsStore interface {
+	// Add records that the document contains each of tokens.
+	Add(docID int, tokens []string) error
+	// Remove reverses Add. Tokens the document is not listed under are
+	// ignored.
+	Remove(docID int, tokens []string) error
+	// DocIDs returns the documents containing token, in no particular order.
+	DocIDs(token string) []int
+	// Count returns the number of documents containing token.
+	Count(token string) int
+	// Tokens returns every token with at least one document, sorted.
+	Tokens() []string
+}
+
+// Record is the stored form of a document. Token frequencies are not
+// stored; they are derived from the document string.
+type Record struct {
+	ID                 int               `json:"id"`
+	Document           string            `json:"document"`
+	Fields             map[string]string `json:"fields"`
+	IsPreferred        bool              `json:"isPreferred"`
+	PreferredDocuments []int             `json:"preferredDocuments"`
+	Version            int               `json:"version"`
+	// Deleted is set on tombstones, documents deleted but not yet purged.
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+// RecordOf converts a document to its stored form.
+func RecordOf(doc *documents.Document) Record {
+	rec := Record{
+		ID:                 doc.ID(),
+		Document:           doc.String(),
+		Fields:             map[string]string{},
+		IsPreferred:        doc.Preferred(),
+		PreferredDocuments: doc.PreferredDocuments(),
+		Version:            doc.Version(),
+	}
+	if fields := doc.Fields(); fields != nil && *fields != nil {
+		rec.Fields = *fields
+	}
+	if rec.PreferredDocuments == nil {
+		rec.PreferredDocuments = []int{}
+	}
+	if deleted := doc.Deleted(); !deleted.IsZero() {
+		deleted = deleted.UTC()
+		rec.Deleted = &deleted
+	}
+	return rec
+}
+
+// Doc converts a record back to a document.
+func (rec Record) Doc() *documents.Document {
+	fields := rec.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	preferredDocuments := rec.PreferredDocuments
+	if preferredDocuments == nil {
+		preferredDocuments = []int{}
+	}
+	isPreferred := rec.IsPreferred
+	doc := documents.NewDocument(rec.Document, rec.ID, nil, &isPreferred, &fields, &preferredDocuments)
+	// Records stored before documents were versioned start at version 1.
+	if rec.Version > 0 {
+		doc.SetVersion(rec.Version)
+	}
+	if rec.Deleted != nil {
+		doc.SetDeleted(*rec.Deleted)
+	}
+	return doc
+}
@@ -0,0 +1,79 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cend/database/storage"
+	"cend/database/storage/segment"
+	"cend/database/storage/storagetest"
+)
+
+func TestBoltEngine(t *testing.T) {
+	paths := make(map[storage.Engine]string)
+	storagetest.TestEngine(t,
+		func(t *testing.T) storage.Engine {
+			path := filepath.Join(t.TempDir(), "cend.db")
+			e, err := storage.OpenBolt(path)
+			if err != nil {
+				t.Fatalf("OpenBolt failed: %v", err)
+			}
+			paths[e] = path
+			return e
+		},
+		func(t *testing.T, e storage.Engine) storage.Engine {
+			if err := e.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			reopened, err := storage.OpenBolt(paths[e])
+			if err != nil {
+				t.Fatalf("OpenBolt failed: %v", err)
+			}
+			return reopened
+		},
+	)
+}
+
+func TestSegmentEngine(t *testing.T) {
+	dirs := make(map[storage.Engine]string)
+	open := func(t *testing.T, dir string) storage.Engine {
+		docs, err := storage.OpenBolt(filepath.Join(dir, "cend.db"))
+		if err != nil {
+			t.Fatalf("OpenBolt failed: %v", err)
+		}
+		// A tiny flush threshold puts postings in segment files.
+		e := storage.NewSegmentEngine(docs, filepath.Join(dir, "index"), segment.Options{FlushThreshold: 2, MergeFactor: 2})
+		dirs[e] = dir
+		return e
+	}
+	storagetest.TestEngine(t,
+		func(t *testing.T) storage.Engine { return open(t, t.TempDir()) },
+		func(t *testing.T, e storage.Engine) storage.Engine {
+			if err := e.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			return open(t, dirs[e])
+		},
+	)
+}
+
+func TestPostgresEngine(t *testing.T) {
+	fakes := make(map[storage.Engine]*storage.FakePostgres)
+	open := func(t *testing.T, fake *storage.FakePostgres) storage.Engine {
+		e, err := storage.NewPostgresEngine(storage.OpenFakePostgres(fake))
+		if err != nil {
+			t.Fatalf("NewPostgresEngine failed: %v", err)
+		}
+		fakes[e] = fake
+		return e
+	}
+	storagetest.TestEngine(t,
+		func(t *testing.T) storage.Engine { return open(t, storage.NewFakePostgres()) },
+		func(t *testing.T, e storage.Engine) storage.Engine {
+			if err := e.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			return open(t, fakes[e])
+		},
+	)
+}
@@ -0,0 +1,294 @@
+// Package storagetest is the conformance suite every storage.Engine must
+// pass.
+package storagetest
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"cend/database/collection/documents"
+	"cend/database/storage"
+)
+
+// TestEngine runs the conformance suite. newEngine returns a fresh, empty
+// engine for each subtest. reopen closes an engine and opens it again over
+// the same data; it is nil for engines that do not persist.
+func TestEngine(t *testing.T, newEngine func(t *testing.T) storage.Engine, reopen func(t *testing.T, e storage.Engine) storage.Engine) {
+	t.Run("Collections", func(t *testing.T) { testCollections(t, newEngine(t)) })
+	t.Run("Documents", func(t *testing.T) { testDocuments(t, newEngine(t)) })
+	t.Run("Postings", func(t *testing.T) { testPostings(t, newEngine(t)) })
+	t.Run("Isolation", func(t *testing.T) { testIsolation(t, newEngine(t)) })
+	t.Run("Drop", func(t *testing.T) { testDrop(t, newEngine(t)) })
+	if reopen != nil {
+		t.Run("Reopen", func(t *testing.T) { testReopen(t, newEngine(t), reopen) })
+	}
+}
+
+func document(id int, doc string, fields map[string]string, isPreferred bool, preferredDocuments []int) *documents.Document {
+	return documents.NewDocument(doc, id, nil, &isPreferred, &fields, &preferredDocuments)
+}
+
+// checkDocument fails unless got holds the same data as want.
+func checkDocument(t *testing.T, got, want *documents.Document) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("Expected document %d, got nil", want.ID())
+	}
+	if !reflect.DeepEqual(storage.RecordOf(got), storage.RecordOf(want)) {
+		t.Errorf("Expected document %+v, got %+v", storage.RecordOf(want), storage.RecordOf(got))
+	}
+}
+
+func createCollection(t *testing.T, e storage.Engine, name string) (storage.DocumentStore, storage.PostingsStore) {
+	t.Helper()
+	if err := e.CreateCollection(name); err != nil {
+		t.Fatalf("CreateCollection(%s) failed: %v", name, err)
+	}
+	docs, err := e.Documents(name)
+	if err != nil {
+		t.Fatalf("Documents(%s) failed: %v", name, err)
+	}
+	postings, err := e.Postings(name)
+	if err != nil {
+		t.Fatalf("Postings(%s) failed: %v", name, err)
+	}
+	return docs, postings
+}
+
+func testCollections(t *testing.T, e storage.Engine) {
+	defer e.Close()
+	names, err := e.Collections()
+	if err != nil || len(names) != 0 {
+		t.Fatalf("Expected no collections, got %v (err %v)", names, err)
+	}
+	for _, name := range []string{"fruit", "companies", "fruit"} {
+		if err := e.CreateCollection(name); err != nil {
+			t.Fatalf("CreateCollection(%s) failed: %v", name, err)
+		}
+	}
+	names, err = e.Collections()
+	if err != nil || !reflect.DeepEqual(names, []string{"companies", "fruit"}) {
+		t.Errorf("Expected collections [companies fruit], got %v (err %v)", names, err)
+	}
+	if _, err := e.Documents("missing"); err == nil {
+		t.Errorf("Expected Documents of a missing collection to fail")
+	}
+	if _, err := e.Postings("missing"); err == nil {
+		t.Errorf("Expected Postings of a missing collection to fail")
+	}
+}
+
+func testDocuments(t *testing.T, e storage.Engine) {
+	defer e.Close()
+	docs, _ := createCollection(t, e, "companies")
+	if docs.Length() != 0 || docs.NextID() != 1 || len(docs.IDs()) != 0 {
+		t.Fatalf("Expected an empty store with next ID 1, got length %d, next ID %d, IDs %v", docs.Length(), docs.NextID(), docs.IDs())
+	}
+
+	ibm := document(1, "IBM", map[string]string{"ticker": "IBM"}, true, []int{})
+	variant := document(3, "I.B.M.", map[string]string{}, false, []int{1})
+	for _, doc := range []*documents.Document{variant, ibm} {
+		if err := docs.Put(doc); err != nil {
+			t.Fatalf("Put(%s) failed: %v", doc, err)
+		}
+	}
+	checkDocument(t, docs.Get(1), ibm)
+	checkDocument(t, docs.Get(3), variant)
+	if doc := docs.Get(2); doc != nil {
+		t.Errorf("Expected no document 2, got %v", doc)
+	}
+	if ids := docs.IDs(); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("Expected IDs [1 3], got %v", ids)
+	}
+	if docs.Length() != 2 || docs.NextID() != 4 {
+		t.Errorf("Expected length 2 and next ID 4, got %d and %d", docs.Length(), docs.NextID())
+	}
+	if err := docs.Put(nil); err == nil {
+		t.Errorf("Expected Put(nil) to fail")
+	}
+
+	// Put replaces fields and links rather than merging them, and keeps
+	// the version.
+	ibm = document(1, "IBM", map[string]string{"country": "US"}, true, []int{3})
+	ibm.SetVersion(7)
+	if err := docs.Put(ibm); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	checkDocument(t, docs.Get(1), ibm)
+	if docs.Length() != 2 {
+		t.Errorf("Expected replacing a document to keep length 2, got %d", docs.Length())
+	}
+
+	// Removing the highest ID must not let it be reused.
+	if err := docs.RemoveDocument(3); err != nil {
+		t.Fatalf("RemoveDocument failed: %v", err)
+	}
+	if err := docs.RemoveDocument(3); err != nil {
+		t.Errorf("Expected removing a missing document to succeed, got %v", err)
+	}
+	if doc := docs.Get(3); doc != nil {
+		t.Errorf("Expected document 3 to be removed, got %v", doc)
+	}
+	if docs.Length() != 1 || docs.NextID() != 4 || !reflect.DeepEqual(docs.IDs(), []int{1}) {
+		t.Errorf("Expected length 1, next ID 4 and IDs [1], got %d, %d and %v", docs.Length(), docs.NextID(), docs.IDs())
+	}
+
+	// SetNextID advances the next ID but never moves it back.
+	for _, id := range []int{10, 5} {
+		if err := docs.SetNextID(id); err != nil {
+			t.Fatalf("SetNextID(%d) failed: %v", id, err)
+		}
+	}
+	if docs.NextID() != 10 {
+		t.Errorf("Expected next ID 10, got %d", docs.NextID())
+	}
+
+	// Documents returned by Get may be copies; the store only changes on Put.
+	again, err := e.Documents("companies")
+	if err != nil {
+		t.Fatalf("Documents failed: %v", err)
+	}
+	checkDocument(t, again.Get(1), ibm)
+}
+
+func testPostings(t *testing.T, e storage.Engine) {
+	defer e.Close()
+	_, postings := createCollection(t, e, "companies")
+	if tokens := postings.Tokens(); len(tokens) != 0 {
+		t.Fatalf("Expected no tokens, got %v", tokens)
+	}
+
+	for _, add := range []struct {
+		id     int
+		tokens []string
+	}{
+		{1, []string{"ibm", " ib", "bm "}},
+		{2, []string{"ibm", "bex"}},
+		{1, []string{"ibm"}}, // adding twice is not counted twice
+	} {
+		if err := postings.Add(add.id, add.tokens); err != nil {
+			t.Fatalf("Add(%d, %v) failed: %v", add.id, add.tokens, err)
+		}
+	}
+	if tokens := postings.Tokens(); !reflect.DeepEqual(tokens, []string{" ib", "bex", "bm ", "ibm"}) {
+		t.Errorf("Expected sorted tokens, got %v", tokens)
+	}
+	if n := postings.Count("ibm"); n != 2 {
+		t.Errorf("Expected ibm in 2 documents, got %d", n)
+	}
+	ids := postings.DocIDs("ibm")
+	slices.Sort(ids)
+	if !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("Expected ibm in documents [1 2], got %v", ids)
+	}
+	if n, ids := postings.Count("xyz"), postings.DocIDs("xyz"); n != 0 || len(ids) != 0 {
+		t.Errorf("Expected unknown token in no documents, got %d and %v", n, ids)
+	}
+
+	if err := postings.Remove(1, []string{"ibm", " ib", "bm ", "bex"}); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if ids := postings.DocIDs("ibm"); !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("Expected ibm in documents [2], got %v", ids)
+	}
+	if n := postings.Count("bex"); n != 1 {
+		t.Errorf("Expected removing an unlisted document to keep bex, got count %d", n)
+	}
+	// Tokens without documents are dropped.
+	if tokens := postings.Tokens(); !reflect.DeepEqual(tokens, []string{"bex", "ibm"}) {
+		t.Errorf("Expected tokens [bex ibm], got %v", tokens)
+	}
+}
+
+func testIsolation(t *testing.T, e storage.Engine) {
+	defer e.Close()
+	fruitDocs, fruitPostings := createCollection(t, e, "fruit")
+	companyDocs, companyPostings := createCollection(t, e, "companies")
+
+	if err := fruitDocs.Put(document(1, "Apple", map[string]string{}, false, []int{})); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := fruitPostings.Add(1, []string{"app"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if companyDocs.Length() != 0 || companyDocs.Get(1) != nil || companyDocs.NextID() != 1 {
+		t.Errorf("Expected documents of fruit not to appear in companies")
+	}
+	if companyPostings.Count("app") != 0 || len(companyPostings.Tokens()) != 0 {
+		t.Errorf("Expected postings of fruit not to appear in companies")
+	}
+}
+
+func testDrop(t *testing.T, e storage.Engine) {
+	defer e.Close()
+	fruitDocs, fruitPostings := createCollection(t, e, "fruit")
+	companyDocs, _ := createCollection(t, e, "companies")
+	for _, docs := range []storage.DocumentStore{fruitDocs, companyDocs} {
+		if err := docs.Put(document(1, "Apple", map[string]string{"kind": "pome"}, true, []int{})); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := fruitPostings.Add(1, []string{"app"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	for range 2 { // dropping a missing collection is not an error
+		if err := e.DropCollection("fruit"); err != nil {
+			t.Fatalf("DropCollection failed: %v", err)
+		}
+	}
+	if names, err := e.Collections(); err != nil || !reflect.DeepEqual(names, []string{"companies"}) {
+		t.Errorf("Expected collections [companies], got %v (err %v)", names, err)
+	}
+	if _, err := e.Documents("fruit"); err == nil {
+		t.Errorf("Expected Documents of a dropped collection to fail")
+	}
+	if companyDocs.Length() != 1 || companyDocs.Get(1) == nil {
+		t.Errorf("Expected dropping fruit to keep the documents of companies")
+	}
+
+	// A collection created again under the name starts empty.
+	fruitDocs, fruitPostings = createCollection(t, e, "fruit")
+	if fruitDocs.Length() != 0 || fruitDocs.Get(1) != nil || fruitDocs.NextID() != 1 {
+		t.Errorf("Expected a recreated collection to have no documents, got length %d and next ID %d", fruitDocs.Length(), fruitDocs.NextID())
+	}
+	if tokens := fruitPostings.Tokens(); len(tokens) != 0 {
+		t.Errorf("Expected a recreated collection to have no postings, got %v", tokens)
+	}
+}
+
+func testReopen(t *testing.T, e storage.Engine, reopen func(t *testing.T, e storage.Engine) storage.Engine) {
+	docs, postings := createCollection(t, e, "companies")
+	createCollection(t, e, "fruit")
+	ibm := document(1, "IBM", map[string]string{"ticker": "IBM"}, true, []int{2})
+	// Tombstones keep their deletion time.
+	ibm.SetDeleted(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	for _, doc := range []*documents.Document{ibm, document(2, "I.B.M.", map[string]string{}, false, []int{1})} {
+		if err := docs.Put(doc); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := docs.RemoveDocument(2); err != nil {
+		t.Fatalf("RemoveDocument failed: %v", err)
+	}
+	if err := postings.Add(1, []string{"ibm", " ib"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	e = reopen(t, e)
+	defer e.Close()
+	names, err := e.Collections()
+	if err != nil || !reflect.DeepEqual(names, []string{"companies", "fruit"}) {
+		t.Errorf("Expected collections [companies fruit] after reopening, got %v (err %v)", names, err)
+	}
+	docs, postings = createCollection(t, e, "companies")
+	checkDocument(t, docs.Get(1), ibm)
+	if docs.Length() != 1 || docs.NextID() != 3 {
+		t.Errorf("Expected length 1 and next ID 3 after reopening, got %d and %d", docs.Length(), docs.NextID())
+	}
+	if tokens := postings.Tokens(); !reflect.DeepEqual(tokens, []string{" ib", "ibm"}) {
+		t.Errorf("Expected tokens [ ib ibm] after reopening, got %v", tokens)
+	}
+}
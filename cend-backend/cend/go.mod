@@ -6,7 +6,11 @@ require (
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/text v0.20.0
 )
 
-require github.com/felixge/httpsnoop v1.0.4 // indirect
+require (
+	github.com/felixge/httpsnoop v1.0.4 // indirect
+	golang.org/x/sys v0.27.0 // indirect
+)
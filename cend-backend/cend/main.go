@@ -3,13 +3,20 @@ package main
 import (
 	"bufio"
 	"cend/database"
+	"cend/database/collection"
+	"cend/database/storage"
+	"cend/database/storage/segment"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
 )
 
 
@@ -36,30 +43,221 @@ func readTestData() []string {
 	return names
 }
 
+// dataPath returns DB_PATH, creating it if needed.
+func dataPath() (string, error) {
+	dbPath, exists := os.LookupEnv("DB_PATH")
+	if !exists {
+		dbPath = "./cend-db"
+	}
+	return dbPath, os.MkdirAll(dbPath, 0755)
+}
+
+// memoryStorage reports whether CEND_STORAGE selects the memory engine.
+func memoryStorage() bool {
+	backend, _ := os.LookupEnv("CEND_STORAGE")
+	return backend == "" || backend == "memory"
+}
+
+// openDB opens the database using the storage engine named by
+// CEND_STORAGE: "memory" (the default), whose collections are saved under
+// DB_PATH on shutdown and loaded again on start; "bolt", which keeps every collection in the file at
+// CEND_BOLT_PATH (DB_PATH/cend.db by default); or "postgres", which
+// connects to CEND_POSTGRES_DSN.
+//
+// Setting CEND_INDEX to "segments" moves the n-gram index of every engine
+// into memory-mapped segment files under CEND_INDEX_PATH (DB_PATH/index by
+// default).
+func openDB(name string) (*database.DB, error) {
+	backend, _ := os.LookupEnv("CEND_STORAGE")
+	var engine storage.Engine
+	switch backend {
+	case "", "memory":
+		engine = collection.NewMemoryEngine()
+	case "bolt":
+		path, exists := os.LookupEnv("CEND_BOLT_PATH")
+		if !exists {
+			dbPath, err := dataPath()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dbPath, "cend.db")
+		}
+		bolt, err := storage.OpenBolt(path)
+		if err != nil {
+			return nil, err
+		}
+		engine = bolt
+	case "postgres":
+		postgres, err := storage.OpenPostgres(os.Getenv("CEND_POSTGRES_DSN"))
+		if err != nil {
+			return nil, err
+		}
+		engine = postgres
+	default:
+		return nil, fmt.Errorf("unknown CEND_STORAGE %q", backend)
+	}
+
+	switch index, _ := os.LookupEnv("CEND_INDEX"); index {
+	case "", "engine":
+	case "segments":
+		path, exists := os.LookupEnv("CEND_INDEX_PATH")
+		if !exists {
+			dbPath, err := dataPath()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dbPath, "index")
+		}
+		// Documents in memory are reindexed when they are loaded, so
+		// their index is not kept across a restart.
+		if memoryStorage() {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, err
+			}
+		}
+		engine = storage.NewSegmentEngine(engine, path, segment.Options{})
+	default:
+		return nil, fmt.Errorf("unknown CEND_INDEX %q", index)
+	}
+	return database.Open(name, engine)
+}
+
+// tombstoneRetention returns how long deleted documents are kept for
+// restoring before they are purged: CEND_TOMBSTONE_RETENTION, as in
+// "720h", or 30 days by default.
+func tombstoneRetention() (time.Duration, error) {
+	retention, exists := os.LookupEnv("CEND_TOMBSTONE_RETENTION")
+	if !exists {
+		return 30 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(retention)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid CEND_TOMBSTONE_RETENTION %q", retention)
+	}
+	return d, nil
+}
+
+// purgeTombstones purges documents deleted longer than retention ago, then
+// again every interval until ctx is done.
+func purgeTombstones(ctx context.Context, db *database.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := db.Purge(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Error purging deleted documents: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted documents", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func main() {
+	if handled, err := runCommand(os.Args[1:]); handled {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.Print("Preparing database...")
+	retention, err := tombstoneRetention()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create database and collection
-	db := database.New("test-db")
-	db.AddCollection("docs")
+	db, err := openDB("test-db")
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	if memoryStorage() {
+		loaded, err := db.Load()
+		if err != nil {
+			log.Fatalf("Error loading saved collections: %v", err)
+		}
+		if len(loaded) > 0 {
+			log.Printf("Loaded collections %v", loaded)
+		}
+	}
+	historyPath, exists := os.LookupEnv("CEND_HISTORY_PATH")
+	if !exists {
+		dbPath, err := dataPath()
+		if err != nil {
+			log.Fatalf("Error creating data directory: %v", err)
+		}
+		historyPath = filepath.Join(dbPath, "history")
+	}
+	if err := db.OpenHistory(historyPath); err != nil {
+		log.Fatalf("Error opening history: %v", err)
+	}
+	if err := db.AddCollection("docs"); err != nil {
+		log.Fatalf("Error adding collection: %v", err)
+	}
 	collection, _ := db.GetCollection("docs")
 
-	bigSampleDocs := readTestData()
-	log.Printf("Adding %d sample documents...", len(bigSampleDocs))
-	for _, doc := range bigSampleDocs {
-		collection.DocumentAdd(doc)
+	// Only seed an empty collection, so persisted documents keep their IDs.
+	if collection.Length() == 0 {
+		bigSampleDocs := readTestData()
+		log.Printf("Adding %d sample documents...", len(bigSampleDocs))
+		for _, doc := range bigSampleDocs {
+			collection.DocumentAdd(doc)
+		}
 	}
 
 
 	log.Print("Setting up routes...")
-	r := mux.NewRouter()
-	r.HandleFunc("/", rootHandler)
-	r.HandleFunc("/search", searchHandler(db))
-	r.HandleFunc("/add", addHandler(db))
-	r.HandleFunc("/delete", removeHandler(db))
-	r.HandleFunc("/query", queryHandler(db))
-	r.HandleFunc("/get", getHandler(db))
-
-	log.Print("Listening on port 8000")
-	log.Fatal(http.ListenAndServe(":8000", handlers.LoggingHandler(os.Stdout, r)))
+	keys, err := loadAPIKeys()
+	if err != nil {
+		log.Fatalf("Error loading API keys: %v", err)
+	}
+	if keys == nil {
+		log.Print("No API keys configured; every endpoint is open to anyone")
+	}
+	cors, err := loadCORSPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	limiter, err := loadRateLimiter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := newRouter(db, keys, limiter)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go purgeTombstones(ctx, db, retention, time.Hour)
+	handler := handlers.LoggingHandler(os.Stdout, cors.handler(r))
+	// Behind the proxy, clients are told apart by the address it forwards.
+	if trust, _ := os.LookupEnv("CEND_TRUST_PROXY_HEADERS"); trust == "true" {
+		handler = handlers.ProxyHeaders(handler)
+	}
+	server := &http.Server{Addr: ":8000", Handler: handler}
+	go func() {
+		log.Print("Listening on port 8000")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Print("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	if memoryStorage() {
+		if err := db.Save(); err != nil {
+			log.Printf("Error saving collections: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"cend/database/collection"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// schema is an OpenAPI schema object, or any other part of the document.
+type schema = map[string]any
+
+// schemaOverrides describes the types that marshal themselves to JSON.
+var schemaOverrides = map[reflect.Type]schema{
+	reflect.TypeFor[time.Time]():       {"type": "string", "format": "date-time"},
+	reflect.TypeFor[json.RawMessage](): {},
+	reflect.TypeFor[collection.Ref](): {
+		"description": "A document ID, or #n for the document added by operation n of the batch.",
+		"oneOf":       []schema{{"type": "integer"}, {"type": "string", "pattern": "^#[0-9]+$"}},
+	},
+}
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// openAPI generates the OpenAPI document of the route table, describing
+// every request and response by its Go type.
+func openAPI() schema {
+	g := schemaGenerator{schemas: schema{}, names: map[reflect.Type]string{}}
+	paths := schema{}
+	for _, rt := range apiRoutes() {
+		op := schema{"summary": rt.summary}
+		params := []schema{}
+		for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+			param := schema{"name": m[1], "in": "path", "required": true, "schema": schema{"type": "string"}}
+			if m[1] == "id" {
+				param["schema"] = schema{"type": "integer"}
+			}
+			params = append(params, param)
+		}
+		if rt.query != nil {
+			for _, f := range jsonFields(reflect.TypeOf(rt.query)) {
+				params = append(params, schema{"name": f.name, "in": "query", "schema": g.schema(f.Type)})
+			}
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.request != nil {
+			op["requestBody"] = schema{"required": true, "content": g.content(rt.request)}
+		}
+		status := rt.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := schema{"description": http.StatusText(status)}
+		if rt.response != nil {
+			success["content"] = g.content(rt.response)
+		}
+		op["responses"] = schema{
+			strconv.Itoa(status): success,
+			"default":            schema{"$ref": "#/components/responses/Error"},
+		}
+		description := []string{}
+		if rt.role != rolePublic {
+			op["security"] = []schema{{"bearer": []string{}}, {"apiKey": []string{}}}
+			scope := "the collection"
+			if !strings.Contains(rt.path, "{collection}") {
+				scope = "collection " + rt.fixedScope()
+			}
+			if rt.scope == allCollections {
+				scope = "every collection"
+			}
+			description = append(description, fmt.Sprintf("Needs the %s role in %s.", rt.role, scope))
+		}
+		if rt.successor != "" {
+			op["deprecated"] = true
+			description = append(description, "Deprecated in favour of "+rt.successor+".")
+		}
+		if len(description) > 0 {
+			op["description"] = strings.Join(description, " ")
+		}
+
+		item, ok := paths[rt.path].(schema)
+		if !ok {
+			item = schema{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+
+	errorResponse := schema{
+		"description": "An error",
+		"content":     schema{"application/json": schema{"schema": g.schema(reflect.TypeFor[ErrorResponse]())}},
+	}
+	return schema{
+		"openapi": "3.0.3",
+		"info":    schema{"title": "CEND API", "version": "1.0.0"},
+		"paths":   paths,
+		"components": schema{
+			"schemas":   g.schemas,
+			"responses": schema{"Error": errorResponse},
+			"securitySchemes": schema{
+				"bearer": schema{"type": "http", "scheme": "bearer"},
+				"apiKey": schema{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// schemaGenerator describes Go types as schemas, collecting named structs
+// as components.
+type schemaGenerator struct {
+	schemas schema
+	names   map[reflect.Type]string
+}
+
+// content describes a body: JSON of the type of v, or a mediaType.
+func (g *schemaGenerator) content(v any) schema {
+	if media, ok := v.(mediaType); ok {
+		return schema{string(media): schema{"schema": schema{"type": "string", "format": "binary"}}}
+	}
+	return schema{"application/json": schema{"schema": g.schema(reflect.TypeOf(v))}}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) schema {
+	if s, ok := schemaOverrides[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return schema{"allOf": []schema{s}, "nullable": true}
+		}
+		nullable := schema{"nullable": true}
+		for k, v := range s {
+			nullable[k] = v
+		}
+		return nullable
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.name(t)
+			g.names[t] = name
+			g.schemas[name] = g.object(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	}
+	return schema{}
+}
+
+// name returns the component name of a struct, qualified by its package if
+// another struct already has its name.
+func (g *schemaGenerator) name(t reflect.Type) string {
+	for other, name := range g.names {
+		if name == t.Name() && other != t {
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			return string(unicode.ToUpper(rune(pkg[0]))) + pkg[1:] + t.Name()
+		}
+	}
+	return t.Name()
+}
+
+func (g *schemaGenerator) object(t reflect.Type) schema {
+	properties := schema{}
+	for _, f := range jsonFields(t) {
+		properties[f.name] = g.schema(f.Type)
+	}
+	return schema{"type": "object", "properties": properties}
+}
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	reflect.StructField
+	name string
+}
+
+// jsonFields returns the fields encoding/json marshals of a struct,
+// including those of embedded structs, indexed from t.
+func jsonFields(t reflect.Type) []jsonField {
+	fields := []jsonField{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, embedded := range jsonFields(f.Type) {
+				embedded.Index = append([]int{i}, embedded.Index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{f, name})
+	}
+	return fields
+}
+
+// openAPIHandler serves the OpenAPI document of the API.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPI())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden OpenAPI document")
+
+// TestOpenAPIGolden checks the generated OpenAPI document against its
+// golden file, so that changing the types of a handler without reviewing
+// the change to the API fails.
+func TestOpenAPIGolden(t *testing.T) {
+	got, err := json.MarshalIndent(openAPI(), "", "  ")
+	if err != nil {
+		t.Fatalf("Error marshalling the OpenAPI document: %v", err)
+	}
+	got = append(got, '\n')
+	golden := filepath.Join("testdata", "openapi.json")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("Error writing golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Error reading golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("The API no longer matches %s; if the change is deliberate, run go test -run TestOpenAPIGolden -update and review the diff.", golden)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodGet, "/openapi.json", "")
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil || rec.Code != http.StatusOK || spec.OpenAPI != "3.0.3" {
+		t.Fatalf("Expected an OpenAPI document, got %d %s", rec.Code, rec.Body)
+	}
+	for _, rt := range apiRoutes() {
+		if _, ok := spec.Paths[rt.path][map[string]string{
+			http.MethodGet: "get", http.MethodPost: "post", http.MethodPut: "put",
+			http.MethodPatch: "patch", http.MethodDelete: "delete",
+		}[rt.method]]; !ok {
+			t.Errorf("Expected %s %s to be described", rt.method, rt.path)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateClass groups endpoints that share a rate limit.
+type rateClass string
+
+const (
+	classSearch rateClass = "search"
+	classRead   rateClass = "read"
+	classWrite  rateClass = "write"
+	classAdmin  rateClass = "admin"
+)
+
+// rateClass returns the class of the route: its own, or else the class of
+// what its role may do.
+func (rt route) rateClass() rateClass {
+	if rt.class != "" {
+		return rt.class
+	}
+	switch rt.role {
+	case roleCurator:
+		return classWrite
+	case roleAdmin:
+		return classAdmin
+	}
+	return classRead
+}
+
+// rateLimit is a token bucket: a client may make Burst requests at once,
+// and the bucket refills at PerSecond requests a second.
+type rateLimit struct {
+	PerSecond float64
+	Burst     float64
+}
+
+// parseRateLimits parses limits such as "search=20/s, write=600/m:50": a
+// class, the number of requests allowed per second, minute or hour, and
+// optionally the burst, which is otherwise that number.
+func parseRateLimits(s string) (map[rateClass]rateLimit, error) {
+	limits := make(map[rateClass]rateLimit)
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		class, limit, _ := strings.Cut(spec, "=")
+		switch rateClass(class) {
+		case classSearch, classRead, classWrite, classAdmin:
+		default:
+			return nil, fmt.Errorf("unknown rate class %q; expected search, read, write or admin", class)
+		}
+		limit, burst, hasBurst := strings.Cut(limit, ":")
+		count, unit, _ := strings.Cut(limit, "/")
+		n, err := strconv.ParseFloat(count, 64)
+		per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+		if err != nil || n <= 0 || per == 0 {
+			return nil, fmt.Errorf("invalid rate limit %q; expected a count per s, m or h, as in 20/s", spec)
+		}
+		l := rateLimit{PerSecond: n / per.Seconds(), Burst: n}
+		if hasBurst {
+			if l.Burst, err = strconv.ParseFloat(burst, 64); err != nil || l.Burst < 1 {
+				return nil, fmt.Errorf("invalid burst in rate limit %q", spec)
+			}
+		}
+		limits[rateClass(class)] = l
+	}
+	return limits, nil
+}
+
+// bucket is the tokens a client has left of one class at a time.
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// rateLimiter limits the rate of requests of each client, each API key or
+// else each IP address, to each class of endpoint.
+type rateLimiter struct {
+	limits map[rateClass]rateLimit
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limits map[rateClass]rateLimit) *rateLimiter {
+	return &rateLimiter{limits: limits, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// loadRateLimiter reads the limits of each class from CEND_RATE_LIMITS, as
+// parseRateLimits does. Without it, it returns nil, and nothing is limited.
+func loadRateLimiter() (*rateLimiter, error) {
+	spec, exists := os.LookupEnv("CEND_RATE_LIMITS")
+	if !exists {
+		return nil, nil
+	}
+	limits, err := parseRateLimits(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEND_RATE_LIMITS: %w", err)
+	}
+	return newRateLimiter(limits), nil
+}
+
+// take takes a token from the client's bucket of a class, reporting
+// whether there was one, or else how long until there is.
+func (l *rateLimiter) take(client string, class rateClass) (bool, time.Duration) {
+	limit, limited := l.limits[class]
+	if !limited {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	key := string(class) + " " + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.Burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(limit.Burst, b.tokens+now.Sub(b.at).Seconds()*limit.PerSecond)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.PerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets, once a minute, the buckets that have refilled, as they
+// are no different from new ones. The caller holds the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		class, _, _ := strings.Cut(key, " ")
+		limit := l.limits[rateClass(class)]
+		if b.tokens+now.Sub(b.at).Seconds()*limit.PerSecond >= limit.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// client identifies who makes a request: the name of a valid API key it
+// presents, or else its IP address.
+func client(r *http.Request, keys *apiKeys) string {
+	if keys != nil {
+		if k, _ := keys.authenticate(r); k != nil {
+			return "key:" + k.Name
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// middleware limits each request by the class of the route it matched.
+// It runs before requests are authorized, so that guessing keys is limited
+// too. routes are the routes of the router by name.
+func (l *rateLimiter) middleware(routes map[string]route, keys *apiKeys) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := routes[mux.CurrentRoute(r).GetName()].rateClass()
+			if ok, wait := l.take(client(r, keys), class); !ok {
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				writeError(w, http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "Rate limit exceeded", fmt.Sprintf("Too many %s requests; retry in %d seconds.", class, seconds))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("search=20/s:40, write=600/m,admin=1/h")
+	if err != nil {
+		t.Fatalf("Error parsing limits: %v", err)
+	}
+	want := map[rateClass]rateLimit{
+		classSearch: {PerSecond: 20, Burst: 40},
+		classWrite:  {PerSecond: 10, Burst: 600},
+		classAdmin:  {PerSecond: 1.0 / 3600, Burst: 1},
+	}
+	if len(limits) != len(want) {
+		t.Errorf("Expected %v, got %v", want, limits)
+	}
+	for class, l := range want {
+		if limits[class] != l {
+			t.Errorf("%s: expected %+v, got %+v", class, l, limits[class])
+		}
+	}
+
+	for _, invalid := range []string{"search", "browse=1/s", "search=0/s", "search=1/d", "search=1/s:0", "search=x/s"} {
+		if _, err := parseRateLimits(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(map[rateClass]rateLimit{classSearch: {PerSecond: 2, Burst: 3}})
+	l.now = func() time.Time { return clock }
+
+	for i := range 3 {
+		if ok, _ := l.take("ip:10.0.0.1", classSearch); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i)
+		}
+	}
+	if ok, wait := l.take("ip:10.0.0.1", classSearch); ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for a token, got %v %v", ok, wait)
+	}
+	if ok, _ := l.take("ip:10.0.0.2", classSearch); !ok {
+		t.Errorf("Expected another client to have its own bucket")
+	}
+	if ok, _ := l.take("ip:10.0.0.1", classRead); !ok {
+		t.Errorf("Expected a class without a limit not to be limited")
+	}
+
+	clock = clock.Add(500 * time.Millisecond)
+	if ok, _ := l.take("ip:10.0.0.1", classSearch); !ok {
+		t.Errorf("Expected a token after it refilled")
+	}
+
+	clock = clock.Add(time.Hour)
+	l.take("ip:10.0.0.3", classSearch)
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected refilled buckets to be swept, got %d", len(l.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := newRateLimiter(map[rateClass]rateLimit{classSearch: {PerSecond: 0.5, Burst: 1}, classRead: {PerSecond: 1, Burst: 1}})
+	limited := newTestRouterWith(t, testKeys(t), limiter)
+	search := func(header, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query": "ibm"}`))
+		if name, value, ok := strings.Cut(header, ": "); ok {
+			req.Header.Set(name, value)
+		}
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := search("Authorization: Bearer reader-key", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first search to be allowed, got %d %s", rec.Code, rec.Body)
+	}
+	rec := search("Authorization: Bearer reader-key", "10.0.0.2:1234")
+	expectError(t, rec, http.StatusTooManyRequests, "TOO_MANY_REQUESTS")
+	if rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected to retry after 2 seconds, got %q", rec.Header().Get("Retry-After"))
+	}
+	if rec := search("Authorization: Bearer admin-key", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another key to have its own limit, got %d", rec.Code)
+	}
+
+	// Without a valid key, requests are limited by IP, before they are
+	// authorized.
+	expectError(t, search("Authorization: Bearer wrong-key", "10.0.0.3:1234"), http.StatusUnauthorized, "UNAUTHORIZED")
+	expectError(t, search("Authorization: Bearer guessed-key", "10.0.0.3:5678"), http.StatusTooManyRequests, "TOO_MANY_REQUESTS")
+
+	req := httptest.NewRequest(http.MethodGet, "/collections/docs/documents/1", nil)
+	req.Header.Set("Authorization", "Bearer reader-key")
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected reads to be limited apart from searches, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"cend/database"
+	"cend/database/collection"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The reconciliation service lets OpenRefine, or any other client of the
+// Reconciliation Service API, match values against a collection. Each
+// collection is a service of its own at /reconcile/{collection}, and is the
+// one type of entity the service knows. Entities are identified by
+// document ID.
+
+// ReconcileManifest describes a reconciliation service to its clients.
+type ReconcileManifest struct {
+	Versions        []string               `json:"versions"`
+	Name            string                 `json:"name"`
+	IdentifierSpace string                 `json:"identifierSpace"`
+	SchemaSpace     string                 `json:"schemaSpace"`
+	DefaultTypes    []ReconcileType        `json:"defaultTypes"`
+	View            ReconcileView          `json:"view"`
+	Preview         ReconcilePreview       `json:"preview"`
+	Suggest         ReconcileSuggestConfig `json:"suggest"`
+}
+
+type ReconcileType struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ReconcileView struct {
+	Url string `json:"url"`
+}
+
+type ReconcilePreview struct {
+	Url    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type ReconcileSuggestConfig struct {
+	Entity   ReconcileSuggestService `json:"entity"`
+	Property ReconcileSuggestService `json:"property"`
+	Type     ReconcileSuggestService `json:"type"`
+}
+
+type ReconcileSuggestService struct {
+	ServiceUrl  string `json:"service_url"`
+	ServicePath string `json:"service_path"`
+}
+
+// ReconcileQuery asks which entities Query refers to. Properties name
+// fields the entity should have; Type, if set, must be the collection.
+type ReconcileQuery struct {
+	Query      string              `json:"query"`
+	Type       string              `json:"type"`
+	Limit      int                 `json:"limit"`
+	Properties []ReconcileProperty `json:"properties"`
+}
+
+// ReconcileProperty is the value V expected in field Pid. V is a string,
+// number or boolean, an entity {"id": ..., "name": ...}, or a list of these,
+// any of which may match.
+type ReconcileProperty struct {
+	Pid string          `json:"pid"`
+	V   json.RawMessage `json:"v"`
+}
+
+type ReconcileCandidate struct {
+	Id       string               `json:"id"`
+	Name     string               `json:"name"`
+	Type     []ReconcileType      `json:"type"`
+	Score    float64              `json:"score"`
+	Match    bool                 `json:"match"`
+	Features []collection.Feature `json:"features"`
+}
+
+type ReconcileResult struct {
+	Result []ReconcileCandidate `json:"result"`
+}
+
+type ReconcileSuggestion struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type ReconcileSuggestResult struct {
+	Result []ReconcileSuggestion `json:"result"`
+}
+
+// defaultReconcileCandidates bounds the candidates of a query without a limit.
+const defaultReconcileCandidates = 5
+
+// jsonpCallback is what a JSONP callback must look like, so that it cannot
+// inject script into the response.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+var reconcilePreview = template.Must(template.New("preview").Parse(`<html><body style="margin:0;font-family:sans-serif;font-size:13px">
+<div style="padding:6px"><strong>{{.Document}}</strong> <span style="color:#888">#{{.Id}}{{if .IsPreferred}}, preferred{{end}}</span>
+{{if .Fields}}<table>{{range $name, $value := .Fields}}<tr><td style="color:#888">{{$name}}</td><td>{{$value}}</td></tr>{{end}}</table>{{end}}
+</div></body></html>
+`))
+
+// writeReconcile writes v as JSON, or as JSONP if the request names a
+// callback, as older clients fetch manifests that way.
+func writeReconcile(w http.ResponseWriter, r *http.Request, v any) {
+	callback := r.FormValue("callback")
+	if callback == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	if !jsonpCallback.MatchString(callback) {
+		writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid callback", fmt.Sprintf("%q is not a JavaScript identifier.", callback))
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error encoding response", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript")
+	fmt.Fprintf(w, "%s(%s);", callback, data)
+}
+
+// serviceURL returns the URL the reconciliation service of a request is at.
+// A request's URL has a scheme only when handlers.ProxyHeaders took it from
+// a trusted proxy; otherwise the connection tells it.
+func serviceURL(r *http.Request, name string) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return fmt.Sprintf("%s://%s/reconcile/%s", scheme, r.Host, name)
+}
+
+// propertyValues returns the values a ReconcileProperty accepts.
+func propertyValues(raw json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		list = []json.RawMessage{raw}
+	}
+	values := []string{}
+	for _, item := range list {
+		var value any
+		if err := json.Unmarshal(item, &value); err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case string:
+			values = append(values, v)
+		case float64, bool:
+			values = append(values, fmt.Sprint(v))
+		case map[string]any:
+			if name, ok := v["name"].(string); ok {
+				values = append(values, name)
+			} else if id, ok := v["id"].(string); ok {
+				values = append(values, id)
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("unsupported property value %s", item)
+		}
+	}
+	return values, nil
+}
+
+// reconcile answers one query against docs.
+func reconcile(docs *collection.Collection, q ReconcileQuery) (ReconcileResult, error) {
+	result := ReconcileResult{Result: []ReconcileCandidate{}}
+	if q.Type != "" && q.Type != docs.Name() {
+		return result, nil
+	}
+	properties := []collection.PropertyValue{}
+	for _, p := range q.Properties {
+		values, err := propertyValues(p.V)
+		if err != nil {
+			return result, fmt.Errorf("property %s: %w", p.Pid, err)
+		}
+		if len(values) > 0 {
+			properties = append(properties, collection.PropertyValue{Field: p.Pid, Values: values})
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultReconcileCandidates
+	}
+	for _, c := range docs.Reconcile(q.Query, properties, limit) {
+		result.Result = append(result.Result, ReconcileCandidate{
+			Id:       strconv.Itoa(c.ID),
+			Name:     c.Document,
+			Type:     []ReconcileType{{Id: docs.Name(), Name: docs.Name()}},
+			Score:    c.Score * 100,
+			Match:    c.Match,
+			Features: c.Features,
+		})
+	}
+	return result, nil
+}
+
+// reconcileHandler serves the manifest of a collection's reconciliation
+// service, or answers the queries in its "queries" parameter, a JSON object
+// of ReconcileQuery by key. A single query in the "query" parameter, as
+// text or a ReconcileQuery, is answered on its own.
+func reconcileHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only GET and POST methods are allowed, got %s", r.Method), "Use GET or POST to reconcile")
+			return
+		}
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+
+		if queries := r.FormValue("queries"); queries != "" {
+			var batch map[string]ReconcileQuery
+			if err := json.Unmarshal([]byte(queries), &batch); err != nil {
+				writeError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid queries", err.Error())
+				return
+			}
+			if len(batch) > maxBatchQueries {
+				writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid batch", fmt.Sprintf("A batch must have at most %d queries.", maxBatchQueries))
+				return
+			}
+			results := make(map[string]ReconcileResult, len(batch))
+			for key, q := range batch {
+				result, err := reconcile(docs, q)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid query", fmt.Sprintf("Query %s: %v", key, err))
+					return
+				}
+				results[key] = result
+			}
+			writeReconcile(w, r, results)
+			return
+		}
+
+		if query := r.FormValue("query"); query != "" {
+			q := ReconcileQuery{Query: query}
+			if strings.HasPrefix(strings.TrimSpace(query), "{") {
+				if err := json.Unmarshal([]byte(query), &q); err != nil {
+					writeError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid query", err.Error())
+					return
+				}
+			}
+			result, err := reconcile(docs, q)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid query", err.Error())
+				return
+			}
+			writeReconcile(w, r, result)
+			return
+		}
+
+		service := serviceURL(r, docs.Name())
+		writeReconcile(w, r, ReconcileManifest{
+			Versions:        []string{"0.1", "0.2"},
+			Name:            fmt.Sprintf("CEND %s", docs.Name()),
+			IdentifierSpace: service + "/",
+			SchemaSpace:     service + "/fields/",
+			DefaultTypes:    []ReconcileType{{Id: docs.Name(), Name: docs.Name()}},
+			View:            ReconcileView{Url: service + "/preview?id={{id}}"},
+			Preview:         ReconcilePreview{Url: service + "/preview?id={{id}}", Width: 400, Height: 120},
+			Suggest: ReconcileSuggestConfig{
+				Entity:   ReconcileSuggestService{ServiceUrl: service, ServicePath: "/suggest/entity"},
+				Property: ReconcileSuggestService{ServiceUrl: service, ServicePath: "/suggest/property"},
+				Type:     ReconcileSuggestService{ServiceUrl: service, ServicePath: "/suggest/type"},
+			},
+		})
+	}
+}
+
+// reconcilePreviewHandler renders the document with the given id as a
+// small HTML page for OpenRefine to show next to a candidate.
+func reconcilePreviewHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid id", err.Error())
+			return
+		}
+		doc := docs.Document(id)
+		if doc == nil {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", fmt.Sprintf("No document with ID %d.", id))
+			return
+		}
+		preview := struct {
+			QueryResult
+			Fields map[string]string
+		}{QueryResult: documentToQueryResult(doc)}
+		if preview.QueryResult.Fields != nil {
+			preview.Fields = *preview.QueryResult.Fields
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		reconcilePreview.Execute(w, preview)
+	}
+}
+
+// reconcileSuggestHandler completes the entity, property or type a user
+// is typing into OpenRefine from its "prefix" parameter, skipping the
+// first "cursor" suggestions.
+func reconcileSuggestHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		prefix := r.FormValue("prefix")
+		cursor, _ := strconv.Atoi(r.FormValue("cursor"))
+		cursor = max(cursor, 0)
+
+		result := ReconcileSuggestResult{Result: []ReconcileSuggestion{}}
+		switch kind := mux.Vars(r)["kind"]; kind {
+		case "entity":
+			for _, s := range docs.DocumentSuggest(prefix, cursor+defaultSuggestResults) {
+				suggestion := ReconcileSuggestion{Id: strconv.Itoa(s.ID), Name: s.Document}
+				if s.Preferred {
+					suggestion.Description = "preferred"
+				}
+				result.Result = append(result.Result, suggestion)
+			}
+		case "property":
+			for _, name := range docs.FieldNames() {
+				if strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+					result.Result = append(result.Result, ReconcileSuggestion{Id: name, Name: name})
+				}
+			}
+		case "type":
+			if strings.HasPrefix(strings.ToLower(docs.Name()), strings.ToLower(prefix)) {
+				result.Result = append(result.Result, ReconcileSuggestion{Id: docs.Name(), Name: docs.Name()})
+			}
+		default:
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown suggest service", fmt.Sprintf("There are no suggestions of %s; use entity, property or type.", kind))
+			return
+		}
+		result.Result = result.Result[min(cursor, len(result.Result)):]
+		writeReconcile(w, r, result)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/handlers"
+)
+
+// TestReconcileServiceURL checks that the manifest trusts the scheme a
+// proxy forwards only when handlers.ProxyHeaders is in front of the API.
+func TestReconcileServiceURL(t *testing.T) {
+	router := newTestRouter(t)
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		want    string
+	}{
+		{"untrusted", router, "http://example.com/reconcile/docs"},
+		{"trusted", handlers.ProxyHeaders(router), "https://example.com/reconcile/docs"},
+	} {
+		rec := serveRequest(tt.handler, http.MethodGet, "/reconcile/docs", "", "X-Forwarded-Proto: https")
+		var manifest ReconcileManifest
+		if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+			t.Fatalf("%s: expected a manifest, got %d %s", tt.name, rec.Code, rec.Body)
+		}
+		if manifest.Suggest.Entity.ServiceUrl != tt.want {
+			t.Errorf("%s: expected the service at %s, got %s", tt.name, tt.want, manifest.Suggest.Entity.ServiceUrl)
+		}
+	}
+}
+
+func TestReconcileManifest(t *testing.T) {
+	router := newTestRouter(t)
+
+	var manifest ReconcileManifest
+	if err := json.Unmarshal(serve(t, router, http.MethodGet, "/reconcile/docs", "").Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("Expected a manifest: %v", err)
+	}
+	service := "http://example.com/reconcile/docs"
+	if manifest.IdentifierSpace != service+"/" || manifest.SchemaSpace != service+"/fields/" ||
+		manifest.View.Url != service+"/preview?id={{id}}" || manifest.Preview.Url != service+"/preview?id={{id}}" {
+		t.Errorf("Expected the manifest's URLs under %s, got %+v", service, manifest)
+	}
+	if manifest.Suggest.Property.ServiceUrl != service || manifest.Suggest.Property.ServicePath != "/suggest/property" {
+		t.Errorf("Expected property suggestions at %s/suggest/property, got %+v", service, manifest.Suggest.Property)
+	}
+	if !reflect.DeepEqual(manifest.DefaultTypes, []ReconcileType{{Id: "docs", Name: "docs"}}) {
+		t.Errorf("Expected the collection as the one type, got %v", manifest.DefaultTypes)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/missing", ""), http.StatusNotFound, "NOT_FOUND")
+
+	rec := serveRequest(router, http.MethodGet, "/reconcile/docs?callback=jQuery.cb_1", "", "")
+	if body := rec.Body.String(); rec.Header().Get("Content-Type") != "application/javascript" ||
+		!strings.HasPrefix(body, "jQuery.cb_1({") || !strings.HasSuffix(body, "});") {
+		t.Errorf("Expected the manifest wrapped in the callback, got %s %s", rec.Header().Get("Content-Type"), body)
+	}
+	for _, callback := range []string{"alert(1)//", "cb;evil", "1cb"} {
+		expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs?callback="+url.QueryEscape(callback), ""), http.StatusBadRequest, "VALIDATION_ERROR")
+	}
+}
+
+func TestReconcileQueries(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, query := range []string{"IBM", `{"query": "IBM", "type": "docs", "limit": 1}`} {
+		var result ReconcileResult
+		rec := serve(t, router, http.MethodGet, "/reconcile/docs?query="+url.QueryEscape(query), "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || len(result.Result) == 0 {
+			t.Fatalf("Expected candidates for %s, got %s", query, rec.Body)
+		}
+		if c := result.Result[0]; c.Id != "1" || c.Name != "IBM" || !c.Match {
+			t.Errorf("Expected IBM to match %s, got %+v", query, c)
+		}
+	}
+
+	// A batch may be posted as a form, as OpenRefine does.
+	form := url.Values{"queries": {`{"q0": {"query": "Intel"}, "q1": {"query": "IBM", "type": "other"}, "q2": {"query": "IBM", "properties": [{"pid": "ticker", "v": "IBM"}]}}`}}
+	rec := serveRequest(router, http.MethodPost, "/reconcile/docs", form.Encode(), "Content-Type: application/x-www-form-urlencoded")
+	var results map[string]ReconcileResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || len(results) != 3 {
+		t.Fatalf("Expected results for three queries, got %d %s", rec.Code, rec.Body)
+	}
+	if r := results["q0"].Result; len(r) == 0 || r[0].Name != "Intel" {
+		t.Errorf("Expected Intel to answer q0, got %v", r)
+	}
+	if r := results["q1"].Result; len(r) != 0 {
+		t.Errorf("Expected no candidates of another type, got %v", r)
+	}
+	if r := results["q2"].Result; len(r) == 0 || r[0].Name != "IBM" || len(r[0].Features) == 0 {
+		t.Errorf("Expected IBM to answer q2 with its property scored, got %v", r)
+	}
+
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs?queries=%7Bnot", ""), http.StatusBadRequest, "INVALID_JSON")
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs?query=%7Bnot", ""), http.StatusBadRequest, "INVALID_JSON")
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs?queries="+url.QueryEscape(`{"q0": {"query": "IBM", "properties": [{"pid": "ticker", "v": [[1]]}]}}`), ""), http.StatusBadRequest, "VALIDATION_ERROR")
+
+	batch := make(map[string]ReconcileQuery)
+	for i := range maxBatchQueries + 1 {
+		batch[fmt.Sprintf("q%d", i)] = ReconcileQuery{Query: "IBM"}
+	}
+	data, _ := json.Marshal(batch)
+	form = url.Values{"queries": {string(data)}}
+	rec = serveRequest(router, http.MethodPost, "/reconcile/docs", form.Encode(), "Content-Type: application/x-www-form-urlencoded")
+	expectError(t, rec, http.StatusBadRequest, "VALIDATION_ERROR")
+}
+
+func TestReconcilePreview(t *testing.T) {
+	router := newTestRouter(t)
+	rec := serve(t, router, http.MethodPost, "/add", `{"document": "<b>Acme</b>", "fields": {"<i>note</i>": "<script>alert(1)</script>"}}`)
+	id := expectDocument(t, rec, http.StatusCreated).Id
+
+	rec = serveRequest(router, http.MethodGet, fmt.Sprintf("/reconcile/docs/preview?id=%d", id), "", "")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("Expected an HTML preview, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"&lt;b&gt;Acme&lt;/b&gt;", "&lt;i&gt;note&lt;/i&gt;", "&lt;script&gt;alert(1)&lt;/script&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the preview to hold %s, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script>") || strings.Contains(body, "<b>Acme") {
+		t.Errorf("Expected the document and its fields to be escaped, got %s", body)
+	}
+
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs/preview?id=x", ""), http.StatusBadRequest, "VALIDATION_ERROR")
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs/preview?id=42", ""), http.StatusNotFound, "NOT_FOUND")
+}
+
+func TestReconcileSuggest(t *testing.T) {
+	router := newTestRouter(t)
+	suggest := func(path string) []ReconcileSuggestion {
+		t.Helper()
+		var result ReconcileSuggestResult
+		rec := serve(t, router, http.MethodGet, path, "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Expected suggestions, got %d %s", rec.Code, rec.Body)
+		}
+		return result.Result
+	}
+
+	if got := suggest("/reconcile/docs/suggest/entity?prefix=i"); !reflect.DeepEqual(got, []ReconcileSuggestion{{Id: "1", Name: "IBM"}, {Id: "2", Name: "Intel"}}) {
+		t.Errorf("Expected IBM and Intel, got %v", got)
+	}
+	if got := suggest("/reconcile/docs/suggest/entity?prefix=i&cursor=1"); !reflect.DeepEqual(got, []ReconcileSuggestion{{Id: "2", Name: "Intel"}}) {
+		t.Errorf("Expected the cursor to skip IBM, got %v", got)
+	}
+	if got := suggest("/reconcile/docs/suggest/entity?prefix=i&cursor=5"); len(got) != 0 {
+		t.Errorf("Expected no suggestions past the end, got %v", got)
+	}
+	if got := suggest("/reconcile/docs/suggest/property?prefix=TIC"); !reflect.DeepEqual(got, []ReconcileSuggestion{{Id: "ticker", Name: "ticker"}}) {
+		t.Errorf("Expected the ticker field, got %v", got)
+	}
+	if got := suggest("/reconcile/docs/suggest/type?prefix=do"); !reflect.DeepEqual(got, []ReconcileSuggestion{{Id: "docs", Name: "docs"}}) {
+		t.Errorf("Expected the collection as a type, got %v", got)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/reconcile/docs/suggest/color?prefix=r", ""), http.StatusNotFound, "NOT_FOUND")
+}
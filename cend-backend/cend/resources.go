@@ -0,0 +1,428 @@
+package main
+
+import (
+	"cend/database"
+	"cend/database/collection"
+	"cend/database/collection/documents"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The resource API serves the documents of every collection as resources:
+//
+//	GET    /collections/{collection}/documents       list them, as /list does
+//	POST   /collections/{collection}/documents       add one, as /add does
+//	GET    /collections/{collection}/documents/{id}  read one
+//	PUT    /collections/{collection}/documents/{id}  replace one
+//	PATCH  /collections/{collection}/documents/{id}  change part of one
+//	DELETE /collections/{collection}/documents/{id}  delete one
+//	POST   /collections/{collection}/documents/{id}/restore  restore a deleted one, as /undelete does
+//	GET    /collections/{collection}/search          search, as /search/page does
+//
+// Requests are read from query parameters named as the fields of the JSON
+// requests they replace. A document's ETag is its version: GET answers 304
+// when If-None-Match names it, and PUT, PATCH, DELETE and restore fail with
+// 412 unless If-Match does, if it is given. If-Match compares tags
+// strongly, so a weak tag never matches it.
+
+// DocumentPatch changes the parts of a document it sets. Fields is merged
+// into the document's fields, a null value removing the field.
+type DocumentPatch struct {
+	Document           *string            `json:"document"`
+	Fields             map[string]*string `json:"fields"`
+	IsPreferred        *bool              `json:"isPreferred"`
+	PreferredDocuments *[]int             `json:"preferredDocuments"`
+}
+
+// CollectionResult describes a collection.
+type CollectionResult struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+}
+
+// deprecated marks the responses of an endpoint the resource API replaces
+// as deprecated, linking to the endpoint that succeeds it.
+func deprecated(successor string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		h(w, r)
+	}
+}
+
+// etag returns the entity tag of a document, its version.
+func etag(doc *documents.Document) string {
+	return fmt.Sprintf("%q", strconv.Itoa(doc.Version()))
+}
+
+// etagMatches reports whether header, the value of If-Match or
+// If-None-Match, names tag. Under weak comparison, as If-None-Match uses,
+// weak tags match by their opaque part; under strong comparison, as
+// If-Match uses, a weak tag matches nothing.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// queryParams reads typed query parameters, recording those that do not
+// parse as invalid fields of the request.
+type queryParams struct {
+	url.Values
+	validation
+}
+
+func (p *queryParams) parse(name, kind string, parse func(string) error) {
+	if value := p.Get(name); value != "" {
+		p.check(parse(value) == nil, name, "must be %s, not %q", kind, value)
+	}
+}
+
+func (p *queryParams) int(name string) (n int) {
+	p.parse(name, "an integer", func(s string) (err error) { n, err = strconv.Atoi(s); return })
+	return n
+}
+
+func (p *queryParams) float(name string) (f float64) {
+	p.parse(name, "a number", func(s string) (err error) { f, err = strconv.ParseFloat(s, 64); return })
+	return f
+}
+
+func (p *queryParams) bool(name string) (b bool) {
+	p.parse(name, "a boolean", func(s string) (err error) { b, err = strconv.ParseBool(s); return })
+	return b
+}
+
+// writeDocument writes doc with its ETag, or 404 if doc is nil because
+// another request removed it.
+func writeDocument(w http.ResponseWriter, status int, doc *documents.Document) {
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", "The document was removed by another request.")
+		return
+	}
+	w.Header().Set("ETag", etag(doc))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(documentToQueryResult(doc))
+}
+
+// pathDocument returns the collection and document named in the path of
+// a request, writing an error if there is no such document.
+func pathDocument(db *database.DB, w http.ResponseWriter, r *http.Request) (*collection.Collection, *documents.Document, bool) {
+	docs, ok := pathCollection(db, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	doc := docs.Document(id)
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", fmt.Sprintf("No document %d.", id))
+		return nil, nil, false
+	}
+	return docs, doc, true
+}
+
+// pathID returns the document ID in the path of a request, writing an
+// error if it is not a number.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid document ID", err.Error())
+		return 0, false
+	}
+	return id, true
+}
+
+// expectedVersion returns the version a change to doc requires under the
+// request's If-Match header, or writes 412 if the header does not name it.
+func expectedVersion(w http.ResponseWriter, r *http.Request, doc *documents.Document) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return collection.AnyVersion, true
+	}
+	if !etagMatches(header, etag(doc), false) {
+		writeError(w, http.StatusPreconditionFailed, "PRECONDITION_FAILED", "Document was changed by someone else", fmt.Sprintf("Document %d is at version %d.", doc.ID(), doc.Version()))
+		return 0, false
+	}
+	return doc.Version(), true
+}
+
+// writeResourceError writes the error of a change to a document. A
+// version conflict can only come of If-Match, so it fails the precondition.
+func writeResourceError(w http.ResponseWriter, message string, err error) {
+	err = unwrapBatchError(err)
+	var conflict *collection.VersionConflictError
+	if errors.As(err, &conflict) {
+		writeError(w, http.StatusPreconditionFailed, "PRECONDITION_FAILED", "Document was changed by someone else", err.Error())
+		return
+	}
+	writeChangeError(w, message, err)
+}
+
+// changeDocument updates doc to document and fields, and then links it to
+// preferredDocs unless they are as they were, all or nothing.
+func changeDocument(w http.ResponseWriter, r *http.Request, docs *collection.Collection, doc *documents.Document, version int, document string, fields *map[string]string, isPreferred bool, preferredDocs []int) {
+	id := collection.Ref{ID: doc.ID()}
+	ops := []collection.BatchOp{{Op: collection.BatchUpdate, ID: &id, Document: document, Fields: fields, ExpectedVersion: version}}
+	if isPreferred != doc.Preferred() || !slices.Equal(preferredDocs, doc.PreferredDocuments()) {
+		ops = append(ops, collection.BatchOp{Op: collection.BatchLink, ID: &id, IsPreferred: isPreferred, PreferredDocuments: refs(preferredDocs)})
+	}
+	if _, err := docs.As(actor(r)).Batch(ops); err != nil {
+		writeResourceError(w, "Error updating document", err)
+		return
+	}
+	writeDocument(w, http.StatusOK, docs.Document(doc.ID()))
+}
+
+// createCollectionHandler creates the collection named in the path, if it
+// does not exist.
+func createCollectionHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["collection"]
+		status := http.StatusOK
+		if _, err := db.GetCollection(name); err != nil {
+			var v validation
+			v.check(database.ValidCollectionName(name), "collection", "must be up to 64 letters, digits, '_', '.' and '-', starting with a letter or digit")
+			if !v.valid(w, nil) {
+				return
+			}
+			if err := db.AddCollection(name); err != nil {
+				writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error creating collection", err.Error())
+				return
+			}
+			status = http.StatusCreated
+		}
+		docs, _ := db.GetCollection(name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(CollectionResult{Name: name, Documents: docs.Length()})
+	}
+}
+
+// deleteCollectionHandler drops a collection with all its documents and
+// their history.
+func deleteCollectionHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := db.DropCollection(mux.Vars(r)["collection"])
+		if errors.Is(err, collection.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Collection not found", err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error deleting collection", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func listDocumentsHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		params := queryParams{Values: r.URL.Query()}
+		req := ListRequest{Cursor: params.Get("cursor"), PageSize: params.int("pageSize"), Deleted: params.bool("deleted")}
+		if !params.valid(w, req) {
+			return
+		}
+		writeListPage(w, docs, req)
+	}
+}
+
+func createDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		var req AddRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		doc, ok := addDocument(w, r, docs, req)
+		if !ok {
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/collections/%s/documents/%d", url.PathEscape(docs.Name()), doc.ID()))
+		writeDocument(w, http.StatusCreated, doc)
+	}
+}
+
+func getDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, doc, ok := pathDocument(db, w, r)
+		if !ok {
+			return
+		}
+		if etagMatches(r.Header.Get("If-None-Match"), etag(doc), true) {
+			w.Header().Set("ETag", etag(doc))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeDocument(w, http.StatusOK, doc)
+	}
+}
+
+// putDocumentHandler replaces a document with the body of the request, as
+// for /add. Fields and links it leaves out are removed.
+func putDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, doc, ok := pathDocument(db, w, r)
+		if !ok {
+			return
+		}
+		var req AddRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		version, ok := expectedVersion(w, r, doc)
+		if !ok {
+			return
+		}
+		fields := req.Fields
+		if fields == nil {
+			fields = &map[string]string{}
+		}
+		preferredDocs := req.PreferredDocuments
+		if preferredDocs == nil {
+			preferredDocs = []int{}
+		}
+		changeDocument(w, r, docs, doc, version, req.Document, fields, req.IsPreferred, preferredDocs)
+	}
+}
+
+func patchDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, doc, ok := pathDocument(db, w, r)
+		if !ok {
+			return
+		}
+		var patch DocumentPatch
+		if !decodeRequest(w, r, &patch) {
+			return
+		}
+		version, ok := expectedVersion(w, r, doc)
+		if !ok {
+			return
+		}
+
+		document := ""
+		if patch.Document != nil {
+			document = *patch.Document
+		}
+		var fields *map[string]string
+		if patch.Fields != nil {
+			set, unset := map[string]string{}, []string{}
+			for key, value := range patch.Fields {
+				if value == nil {
+					unset = append(unset, key)
+				} else {
+					set[key] = *value
+				}
+			}
+			patched := patchFields(doc.Fields(), set, unset)
+			fields = &patched
+		}
+		isPreferred, preferredDocs := doc.Preferred(), doc.PreferredDocuments()
+		if patch.IsPreferred != nil {
+			isPreferred = *patch.IsPreferred
+		}
+		if patch.PreferredDocuments != nil {
+			preferredDocs = *patch.PreferredDocuments
+		}
+		changeDocument(w, r, docs, doc, version, document, fields, isPreferred, preferredDocs)
+	}
+}
+
+// deleteDocumentHandler deletes a document, leaving the tombstone it
+// returns.
+func deleteDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, doc, ok := pathDocument(db, w, r)
+		if !ok {
+			return
+		}
+		version, ok := expectedVersion(w, r, doc)
+		if !ok {
+			return
+		}
+		if err := docs.As(actor(r)).DocumentRemoveIfVersion(doc.ID(), version); err != nil {
+			writeResourceError(w, "Error removing document", err)
+			return
+		}
+		writeDocument(w, http.StatusOK, docs.Tombstone(doc.ID()))
+	}
+}
+
+// restoreDocumentHandler brings back the deleted document named in the
+// path, whose tombstone If-Match names.
+func restoreDocumentHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		version := collection.AnyVersion
+		if tombstone := docs.Tombstone(id); tombstone != nil {
+			if version, ok = expectedVersion(w, r, tombstone); !ok {
+				return
+			}
+		}
+		if err := docs.As(actor(r)).DocumentRestore(id, version); err != nil {
+			writeResourceError(w, "Error restoring document", err)
+			return
+		}
+		writeDocument(w, http.StatusOK, docs.Document(id))
+	}
+}
+
+func searchDocumentsHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docs, ok := pathCollection(db, w, r)
+		if !ok {
+			return
+		}
+		params := queryParams{Values: r.URL.Query()}
+		req := SearchPageRequest{
+			SearchRequest: SearchRequest{
+				Query:            params.Get("query"),
+				Explain:          params.bool("explain"),
+				MinScore:         params.float("minScore"),
+				RelativeCutoff:   params.float("relativeCutoff"),
+				MinShouldMatch:   params.float("minShouldMatch"),
+				CollapseVariants: params.bool("collapseVariants"),
+				PreferredBoost:   params.float("preferredBoost"),
+			},
+			Cursor:   params.Get("cursor"),
+			PageSize: params.int("pageSize"),
+		}
+		if !params.valid(w, req) {
+			return
+		}
+		writeSearchPage(w, docs, req)
+	}
+}
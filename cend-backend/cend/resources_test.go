@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveRequest serves a request with the given If-Match or If-None-Match
+// header, as "If-Match: value".
+func serveRequest(router http.Handler, method, path, body, header string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if name, value, ok := strings.Cut(header, ": "); ok {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDocumentResource(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodGet, "/collections/docs/documents/1", "")
+	if doc := expectDocument(t, rec, http.StatusOK); doc.Document != "IBM" || rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected IBM at ETag \"2\", got %+v at %s", doc, rec.Header().Get("ETag"))
+	}
+	if rec := serveRequest(router, http.MethodGet, "/collections/docs/documents/1", "", `If-None-Match: W/"1", "2"`); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	if rec := serveRequest(router, http.MethodGet, "/collections/docs/documents/1", "", `If-None-Match: "1"`); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a stale ETag, got %d", rec.Code)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/collections/docs/documents/42", ""), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodGet, "/collections/other/documents/1", ""), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodPost, "/collections/docs/documents/1", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+
+	rec = serve(t, router, http.MethodPost, "/collections/docs/documents", `{"document": "I.B.M.", "preferredDocuments": [1]}`)
+	if doc := expectDocument(t, rec, http.StatusCreated); doc.Id != 3 || rec.Header().Get("Location") != "/collections/docs/documents/3" {
+		t.Errorf("Expected document 3 to be created, got %+v at %s", doc, rec.Header().Get("Location"))
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/collections/docs/documents", `{"document": "IBM"}`), http.StatusConflict, "CONFLICT")
+
+	expectError(t, serveRequest(router, http.MethodPut, "/collections/docs/documents/2", `{"document": "Intel Corp"}`, `If-Match: "2"`), http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+	expectError(t, serveRequest(router, http.MethodPut, "/collections/docs/documents/2", `{"document": "Intel Corp"}`, `If-Match: W/"1"`), http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+	rec = serveRequest(router, http.MethodPut, "/collections/docs/documents/2", `{"document": "Intel Corp", "fields": {"ticker": "INTC"}, "isPreferred": true}`, `If-Match: "1"`)
+	if doc := expectDocument(t, rec, http.StatusOK); doc.Document != "Intel Corp" || (*doc.Fields)["ticker"] != "INTC" || !doc.IsPreferred {
+		t.Errorf("Expected Intel to be replaced, got %+v", doc)
+	}
+	rec = serve(t, router, http.MethodPut, "/collections/docs/documents/2", `{"document": "Intel"}`)
+	if doc := expectDocument(t, rec, http.StatusOK); len(*doc.Fields) != 0 || doc.IsPreferred {
+		t.Errorf("Expected a replacement to drop what it leaves out, got %+v", doc)
+	}
+	expectError(t, serve(t, router, http.MethodPut, "/collections/docs/documents/2", `{"document": "IBM"}`), http.StatusConflict, "CONFLICT")
+
+	rec = serve(t, router, http.MethodPatch, "/collections/docs/documents/1", `{"fields": {"ticker": null, "country": "US"}, "isPreferred": true}`)
+	if doc := expectDocument(t, rec, http.StatusOK); doc.Document != "IBM" || len(*doc.Fields) != 1 || (*doc.Fields)["country"] != "US" || !doc.IsPreferred {
+		t.Errorf("Expected only the patched parts to change, got %+v", doc)
+	}
+	expectError(t, serve(t, router, http.MethodPatch, "/collections/docs/documents/1", `{"document": ""}`), http.StatusBadRequest, "VALIDATION_ERROR")
+
+	expectError(t, serveRequest(router, http.MethodDelete, "/collections/docs/documents/1", "", `If-Match: "1"`), http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+	if doc := expectDocument(t, serve(t, router, http.MethodDelete, "/collections/docs/documents/1", ""), http.StatusOK); doc.Deleted == nil {
+		t.Errorf("Expected the tombstone of the deleted document, got %+v", doc)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/collections/docs/documents/1", ""), http.StatusNotFound, "NOT_FOUND")
+
+	expectError(t, serveRequest(router, http.MethodPost, "/collections/docs/documents/1/restore", "", `If-Match: "4"`), http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+	rec = serveRequest(router, http.MethodPost, "/collections/docs/documents/1/restore", "", `If-Match: "5"`)
+	if doc := expectDocument(t, rec, http.StatusOK); doc.Document != "IBM" || doc.Deleted != nil || rec.Header().Get("ETag") != `"6"` {
+		t.Errorf("Expected IBM to be restored at ETag \"6\", got %+v at %s", doc, rec.Header().Get("ETag"))
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/collections/docs/documents/1/restore", ""), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodPost, "/collections/docs/documents/x/restore", ""), http.StatusBadRequest, "VALIDATION_ERROR")
+}
+
+func TestDocumentCollectionResource(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodGet, "/collections/docs/documents?pageSize=1", "")
+	var list ListResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || list.Total != 2 || len(list.Documents) != 1 || list.NextCursor == "" {
+		t.Errorf("Expected the first of 2 documents, got %s", rec.Body)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/collections/docs/documents?pageSize=many", ""), http.StatusBadRequest, "VALIDATION_ERROR")
+
+	rec = serve(t, router, http.MethodGet, "/collections/docs/search?query=intel&minScore=0.5", "")
+	var page SearchPageResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || page.Total != 1 || page.Results[0].Id != 2 {
+		t.Errorf("Expected Intel, got %s", rec.Body)
+	}
+	expectError(t, serve(t, router, http.MethodGet, "/collections/docs/search?query=intel&relativeCutoff=2", ""), http.StatusBadRequest, "VALIDATION_ERROR")
+}
+
+func TestDeprecatedEndpoints(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodPost, "/get", `{"ids": [1]}`)
+	if rec.Header().Get("Deprecation") != "true" || !strings.Contains(rec.Header().Get("Link"), "/collections/docs/documents/{id}") {
+		t.Errorf("Expected /get to be deprecated in favour of the document resource, got %v", rec.Header())
+	}
+	if rec := serve(t, router, http.MethodPost, "/merge", `{}`); rec.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected /merge not to be deprecated")
+	}
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"cend/database"
+	"cend/database/collection"
+	"cend/database/collection/documents"
 	"fmt"
 	"net/http"
-	_ "github.com/lib/pq"
+	"runtime"
+	"time"
 )
 
 type SearchRequest struct {
 	Query      string `json:"query"`
 	MaxResults int    `json:"maxResults"`
+	Explain    bool   `json:"explain"`
+	MinScore       float64 `json:"minScore"`
+	RelativeCutoff float64 `json:"relativeCutoff"`
+	MinShouldMatch float64 `json:"minShouldMatch"`
+	CollapseVariants bool    `json:"collapseVariants"`
+	PreferredBoost   float64 `json:"preferredBoost"`
 }
 
 type DeleteRequest struct {
 	Document *string `json:"document"`
 	Id *int `json:"id"`
+	ExpectedVersion int `json:"expectedVersion"`
 }
 
 type AddRequest struct {
@@ -25,6 +37,95 @@ type AddRequest struct {
 	PreferredDocuments []int `json:"preferredDocuments"`
 }
 
+// UpdateRequest changes a document in place, keeping its ID. The document
+// is found by Id, or else by its current text in Document. NewDocument
+// replaces its text. Fields replaces all of its fields, while SetFields and
+// UnsetFields patch individual keys; the two cannot be combined.
+//
+// ExpectedVersion, here and in DeleteRequest and MergeRequest, is the
+// version the client last saw; the change fails with a CONFLICT if the
+// document has changed since. It is not checked when zero.
+type UpdateRequest struct {
+	Id              *int               `json:"id"`
+	Document        *string            `json:"document"`
+	NewDocument     string             `json:"newDocument"`
+	Fields          *map[string]string `json:"fields"`
+	SetFields       map[string]string  `json:"setFields"`
+	UnsetFields     []string           `json:"unsetFields"`
+	ExpectedVersion int                `json:"expectedVersion"`
+}
+
+// MergeRequest folds the Source document into the Target, which keeps its
+// ID, and removes the Source.
+type MergeRequest struct {
+	Source                int `json:"source"`
+	Target                int `json:"target"`
+	ExpectedSourceVersion int `json:"expectedSourceVersion"`
+	ExpectedTargetVersion int `json:"expectedTargetVersion"`
+}
+
+// SplitRequest splits a new document with the text Document off the
+// Source, moving the Source's Fields with the given keys and the given
+// Variants, documents listing the Source as preferred, to it.
+type SplitRequest struct {
+	Source          int      `json:"source"`
+	Document        string   `json:"document"`
+	Fields          []string `json:"fields"`
+	Variants        []int    `json:"variants"`
+	ExpectedVersion int      `json:"expectedVersion"`
+}
+
+// HistoryRequest reads the history of the document Id, or when Id is not
+// set, pages through the history of the whole collection: the changes
+// after the change numbered After, up to PageSize of them.
+type HistoryRequest struct {
+	Id       *int `json:"id"`
+	After    int  `json:"after"`
+	PageSize int  `json:"pageSize"`
+}
+
+type HistoryResult struct {
+	Changes []collection.Change `json:"changes"`
+	// Next is the After of the next page of the collection's history.
+	Next int `json:"next,omitempty"`
+}
+
+// BatchRequest applies Operations to the collection in order, all or none
+// of them. An operation can name the document added by an earlier one as
+// "#n", n counting from 0, wherever it takes a document ID.
+type BatchRequest struct {
+	Operations []collection.BatchOp `json:"operations"`
+}
+
+// BatchResult reports each operation of a batch. When the batch was not
+// Applied, the operation that failed carries its error, and the others
+// have status 424 as they were rolled back or never run.
+type BatchResult struct {
+	Applied bool                   `json:"applied"`
+	Results []BatchOperationResult `json:"results"`
+}
+
+type BatchOperationResult struct {
+	Status   int            `json:"status"`
+	Document *QueryResult   `json:"document,omitempty"`
+	Error    *ErrorResponse `json:"error,omitempty"`
+}
+
+// maxBatchOperations bounds the operations of one /batch request.
+const maxBatchOperations = 1000
+
+// UndeleteRequest restores the deleted document Id.
+type UndeleteRequest struct {
+	Id              int `json:"id"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// UndoRequest reverts the merge or split recorded in history as change
+// Seq.
+type UndoRequest struct {
+	Seq int `json:"seq"`
+}
+
 type QueryRequest struct {
 	Min int `json:"min"`
 	Max int `json:"max"`
@@ -37,6 +138,9 @@ type SearchResult struct {
 	Fields   *map[string]string `json:"fields"`
 	IsPreferred bool `json:"isPreferred"`
 	PreferredDocuments []int `json:"preferredDocuments"`
+	Explanation *collection.Explanation `json:"explanation,omitempty"`
+	MatchedVariant *collection.VariantMatch `json:"matchedVariant,omitempty"`
+	Version int `json:"version"`
 }
 
 type DeleteResult struct {
@@ -55,35 +159,140 @@ type QueryResult struct {
 	Fields   *map[string]string `json:"fields"`
 	IsPreferred bool `json:"isPreferred"`
 	PreferredDocuments []int `json:"preferredDocuments"`
+	Version int `json:"version"`
+	// Deleted is when a document listed from the tombstones was deleted.
+	Deleted *time.Time `json:"deleted,omitempty"`
 }
 
 type GetRequest struct {
 	Ids []int `json:"ids"`
 }
 
+type SuggestRequest struct {
+	Prefix     string `json:"prefix"`
+	MaxResults int    `json:"maxResults"`
+}
+
+type SuggestResult struct {
+	Document    string  `json:"document"`
+	Score       float64 `json:"score"`
+	Id          int     `json:"id"`
+	IsPreferred bool    `json:"isPreferred"`
+}
+
+// defaultSuggestResults bounds /suggest responses when maxResults is not set.
+const defaultSuggestResults = 10
+
+// SearchPageRequest pages through the results of a search. Cursor is the
+// nextCursor of the previous page, or empty for the first page.
+type SearchPageRequest struct {
+	SearchRequest
+	Cursor   string `json:"cursor"`
+	PageSize int    `json:"pageSize"`
+}
+
+type SearchPageResult struct {
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// BatchSearchRequest runs many searches at once. Each result is keyed by
+// the query's Id, or by its text if it has none.
+type BatchSearchRequest struct {
+	Queries []BatchSearchQuery `json:"queries"`
+}
+
+type BatchSearchQuery struct {
+	Id string `json:"id"`
+	SearchRequest
+}
+
+type BatchSearchResult struct {
+	Results map[string][]SearchResult `json:"results"`
+}
+
+// maxBatchQueries bounds the queries of one /search/batch request.
+const maxBatchQueries = 1000
+
+// ListRequest pages through every document in a collection in ID order,
+// or with Deleted, through its deleted documents not yet purged.
+type ListRequest struct {
+	Cursor   string `json:"cursor"`
+	PageSize int    `json:"pageSize"`
+	Deleted  bool   `json:"deleted"`
+}
+
+type ListResult struct {
+	Documents  []QueryResult `json:"documents"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+type RestoreResult struct {
+	Collections []string `json:"collections"`
+}
+
+// defaultPageSize and maxPageSize bound /search/page and /list pages.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 1000
+)
+
+// options converts the request's thresholds to collection.SearchOptions.
+func (req SearchRequest) options() collection.SearchOptions {
+	return collection.SearchOptions{
+		MinScore:         req.MinScore,
+		RelativeCutoff:   req.RelativeCutoff,
+		MinShouldMatch:   req.MinShouldMatch,
+		CollapseVariants: req.CollapseVariants,
+		PreferredBoost:   req.PreferredBoost,
+	}
+}
+
+// pageSize returns the requested page size, or the default if it is 0.
+func pageSize(requested int) int {
+	if requested == 0 {
+		return defaultPageSize
+	}
+	return requested
+}
+
+// keys returns the key of each query: its Id, or its text if it has none.
+func (req BatchSearchRequest) keys() []string {
+	keys := make([]string, len(req.Queries))
+	for i, q := range req.Queries {
+		keys[i] = q.Id
+		if keys[i] == "" {
+			keys[i] = q.Query
+		}
+	}
+	return keys
+}
+
 func searchHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Method != http.MethodPost {
-			http.Error(w, fmt.Sprintf("Only POST method is allowed, got %s", r.Method), http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to search")
 			return
 		}
 
 		var req SearchRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeRequest(w, r, &req) {
 			return
 		}
 		fmt.Printf("Incoming search request: %v", req)
 		fmt.Printf("Query: %v", req.Query)
+		opts := req.options()
 		// Get the docs collection
 		docs, err := db.GetCollection("docs")
 		if err != nil {
-			http.Error(w, "Error getting collection", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
 			return
 		}
 		fmt.Printf("Collection: %v", docs.DocumentList())
-		searchResults := docs.DocumentSearch(req.Query)
+		searchResults := docs.DocumentSearchWithOptions(req.Query, opts)
 		fmt.Printf("Search results: %v", searchResults)
 
 		// Apply maxResults limit if specified and greater than 0
@@ -91,6 +300,10 @@ func searchHandler(db *database.DB) http.HandlerFunc {
 			searchResults = searchResults[:req.MaxResults]
 		}
 
+		if req.Explain {
+			explainResults(docs, req.Query, opts, searchResults)
+		}
+
 		// Convert SearchResultScore to SearchResult
 		formattedSearchResult := getSearchResult(docs, searchResults)
 		w.Header().Set("Content-Type", "application/json")
@@ -98,6 +311,135 @@ func searchHandler(db *database.DB) http.HandlerFunc {
 	}
 }
 
+func searchPageHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w,
+				http.StatusMethodNotAllowed,
+				"METHOD_NOT_ALLOWED",
+				fmt.Sprintf("Only POST method is allowed, got %s", r.Method),
+				"Use POST to search",
+			)
+			return
+		}
+
+		var req SearchPageRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		writeSearchPage(w, docs, req)
+	}
+}
+
+// writeSearchPage writes the page of search results req asks for.
+func writeSearchPage(w http.ResponseWriter, docs *collection.Collection, req SearchPageRequest) {
+	opts := req.options()
+	page, err := docs.DocumentSearchPage(req.Query, opts, req.Cursor, pageSize(req.PageSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor", err.Error())
+		return
+	}
+	if req.Explain {
+		explainResults(docs, req.Query, opts, page.Results)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SearchPageResult{
+		Results:    getSearchResult(docs, page.Results),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	})
+}
+
+func searchBatchHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to search")
+			return
+		}
+
+		var req BatchSearchRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		keys := req.keys()
+		queries := make([]collection.Query, len(req.Queries))
+		for i, q := range req.Queries {
+			queries[i] = collection.Query{Text: q.Query, MaxResults: q.MaxResults, Explain: q.Explain, Options: q.options()}
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+
+		result := BatchSearchResult{Results: make(map[string][]SearchResult, len(queries))}
+		for i, searchResults := range docs.SearchBatch(queries, runtime.GOMAXPROCS(0)) {
+			result.Results[keys[i]] = getSearchResult(docs, searchResults)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func listHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w,
+				http.StatusMethodNotAllowed,
+				"METHOD_NOT_ALLOWED",
+				fmt.Sprintf("Only POST method is allowed, got %s", r.Method),
+				"Use POST to list documents",
+			)
+			return
+		}
+
+		var req ListRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		writeListPage(w, docs, req)
+	}
+}
+
+// writeListPage writes the page of documents req asks for.
+func writeListPage(w http.ResponseWriter, docs *collection.Collection, req ListRequest) {
+	listPage := docs.DocumentListPage
+	if req.Deleted {
+		listPage = docs.TombstoneListPage
+	}
+	page, err := listPage(req.Cursor, pageSize(req.PageSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor", err.Error())
+		return
+	}
+
+	results := make([]QueryResult, 0, len(page.Documents))
+	for _, doc := range page.Documents {
+		results = append(results, documentToQueryResult(doc))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListResult{
+		Documents:  results,
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	})
+}
+
 func queryHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodPost {
@@ -111,31 +453,19 @@ func queryHandler(db *database.DB) http.HandlerFunc {
         }
 
 		var req QueryRequest
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            writeError(w,
-                http.StatusBadRequest,
-                "INVALID_JSON",
-                "Invalid request body",
-                err.Error(),
-            )
+        if !decodeRequest(w, r, &req) {
             return
         }
 		fmt.Printf("Incoming search request: %v", req)
 		fmt.Printf("Query: %v", req)
 
-		if req.Min < 0 || req.Max < req.Min {
-			writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid range: min must be >= 0 and max must be >= min", "Error: Invalid Range")
-			return
-		}
-
 		docs, err := db.GetCollection("docs")
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
 			return
 		}
 
-		docCollec := docs.GetDocumentCollection()
-		docList, err := docCollec.GetDocuments(req.Min, req.Max)
+		docList, err := docs.DocumentRange(req.Min, req.Max)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting documents", err.Error())
 			return
@@ -161,91 +491,341 @@ func addHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Method != http.MethodPost {
-			http.Error(w, fmt.Sprintf("Only POST method is allowed, got %s", r.Method), http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to add documents")
 			return
 		}
 
 		var req AddRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeRequest(w, r, &req) {
 			return
 		}
 
 		// Get the docs collection
 		docs, err := db.GetCollection("docs")
 		if err != nil {
-			http.Error(w, "Error getting collection", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		doc, ok := addDocument(w, r, docs, req)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(documentToQueryResult(doc))
+	}
+}
+
+// addDocument adds the document req describes to docs and returns it, or
+// writes why it cannot. The document is added as a batch of one, so that it
+// is not left behind if its fields or links cannot be set.
+func addDocument(w http.ResponseWriter, r *http.Request, docs *collection.Collection, req AddRequest) (*documents.Document, bool) {
+	ids, err := docs.As(actor(r)).Batch([]collection.BatchOp{{
+		Op:                 collection.BatchAdd,
+		Document:           req.Document,
+		Fields:             req.Fields,
+		IsPreferred:        req.IsPreferred,
+		PreferredDocuments: refs(req.PreferredDocuments),
+	}})
+	if err != nil {
+		writeChangeError(w, "Error adding document", unwrapBatchError(err))
+		return nil, false
+	}
+	doc := docs.Document(ids[0])
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", "The document was removed by another request.")
+		return nil, false
+	}
+	return doc, true
+}
+
+func updateHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to update documents")
+			return
+		}
+
+		var req UpdateRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
 			return
 		}
-		if req.Document == "" {
-			http.Error(w, "Document was empty", http.StatusBadRequest)
+		if req.Id == nil {
+			req.Id = docs.DocumentID(*req.Document)
+		}
+		var doc *documents.Document
+		if req.Id != nil {
+			doc = docs.Document(*req.Id)
+		}
+		if doc == nil {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", "Not found.")
 			return
 		}
-		docs.DocumentAdd(req.Document)
-		docID := docs.DocumentID(req.Document)
-		if docID == nil {
-			http.Error(w, "Error adding document", http.StatusInternalServerError)
+
+		fields := req.Fields
+		if req.SetFields != nil || req.UnsetFields != nil {
+			patched := patchFields(doc.Fields(), req.SetFields, req.UnsetFields)
+			fields = &patched
+		}
+		if err := docs.As(actor(r)).DocumentUpdateIfVersion(*req.Id, req.ExpectedVersion, req.NewDocument, fields); err != nil {
+			writeChangeError(w, "Error updating document", err)
 			return
 		}
-		docCollec := docs.GetDocumentCollection()
-		if docCollec == nil {
-			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting documents", "Document collection not found")
+
+		writeQueryResult(w, docs.Document(*req.Id))
+	}
+}
+
+func mergeHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to merge documents")
 			return
 		}
 
-		doc := docCollec.Get(*docID)
-		if err := doc.AddFields(req.Fields); err != nil {
-			http.Error(w, "Fields invalid", http.StatusBadRequest)
+		var req MergeRequest
+		if !decodeRequest(w, r, &req) {
 			return
 		}
-		doc.SetPreferred(req.IsPreferred)
-		doc.SetPreferredDocuments(req.PreferredDocuments)
 
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		if err := docs.As(actor(r)).DocumentMerge(req.Source, req.Target, req.ExpectedSourceVersion, req.ExpectedTargetVersion); err != nil {
+			writeChangeError(w, "Error merging documents", err)
+			return
+		}
+
+		writeQueryResult(w, docs.Document(req.Target))
+	}
+}
+
+func splitHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to split documents")
+			return
+		}
+
+		var req SplitRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		id, err := docs.As(actor(r)).DocumentSplit(req.Source, req.ExpectedVersion, req.Document, req.Fields, req.Variants)
+		var conflict *collection.VersionConflictError
+		if errors.As(err, &conflict) || errors.Is(err, collection.ErrNotFound) || errors.Is(err, collection.ErrExists) {
+			writeChangeError(w, "Error splitting document", err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INPUT_ERROR", "Cannot split document", err.Error())
+			return
+		}
+
+		writeQueryResult(w, docs.Document(id))
+	}
+}
+
+func historyHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to read history")
+			return
+		}
+
+		var req HistoryRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+
+		result := HistoryResult{}
+		if req.Id != nil {
+			result.Changes = docs.History(*req.Id)
+		} else {
+			limit := pageSize(req.PageSize)
+			result.Changes = docs.HistoryFeed(req.After, limit)
+			if len(result.Changes) == limit {
+				result.Next = result.Changes[len(result.Changes)-1].Seq
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func batchHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to apply a batch")
+			return
+		}
+
+		var req BatchRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+
+		ids, err := docs.As(actor(r)).Batch(req.Operations)
+		result := BatchResult{Applied: err == nil, Results: make([]BatchOperationResult, len(req.Operations))}
+		status := http.StatusOK
+		var batchErr *collection.BatchError
+		if errors.As(err, &batchErr) {
+			for i := range result.Results {
+				result.Results[i] = BatchOperationResult{
+					Status: http.StatusFailedDependency,
+					Error:  &ErrorResponse{Status: http.StatusFailedDependency, Message: "Not applied", Code: "NOT_APPLIED", Details: fmt.Sprintf("Operation %d failed.", batchErr.Index)},
+				}
+			}
+			opErr := batchOperationError(batchErr.Err)
+			result.Results[batchErr.Index] = BatchOperationResult{Status: opErr.Status, Error: &opErr}
+			status = opErr.Status
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error applying batch", err.Error())
+			return
+		} else {
+			for i, id := range ids {
+				doc := docs.Document(id)
+				if doc == nil {
+					doc = docs.Tombstone(id)
+				}
+				result.Results[i] = BatchOperationResult{Status: http.StatusOK}
+				if doc != nil {
+					qr := documentToQueryResult(doc)
+					result.Results[i].Document = &qr
+				}
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode("200")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func undeleteHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to restore deleted documents")
+			return
+		}
+
+		var req UndeleteRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		if err := docs.As(actor(r)).DocumentRestore(req.Id, req.ExpectedVersion); err != nil {
+			writeChangeError(w, "Error restoring document", err)
+			return
+		}
+
+		writeQueryResult(w, docs.Document(req.Id))
+	}
+}
+
+func undoHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to undo changes")
+			return
+		}
+
+		var req UndoRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
+		if len(docs.HistoryFeed(req.Seq-1, 1)) == 0 {
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Change not found", fmt.Sprintf("No change %d.", req.Seq))
+			return
+		}
+		change, err := docs.As(actor(r)).Undo(req.Seq)
+		var conflict *collection.UndoConflictError
+		if errors.As(err, &conflict) {
+			writeError(w, http.StatusConflict, "CONFLICT", "Change conflicts with later changes", err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INPUT_ERROR", "Cannot undo change", err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(change)
 	}
 }
 
 func removeHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, fmt.Sprintf("Only POST method is allowed, got %s", r.Method), http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("Only POST method is allowed, got %s", r.Method), "Use POST to delete documents")
 			return
 		}
 
 		var req DeleteRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeRequest(w, r, &req) {
 			return
 		}
 
 		// Get the docs collection
 		docs, err := db.GetCollection("docs")
 		if err != nil {
-			http.Error(w, "Error getting collection", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
 			return
 		}
 		if req.Id == nil {
-			if req.Document == nil {
-				writeError(w, http.StatusBadRequest, "INPUT_ERROR", "Must specify an Id or Document string.", "Invalid input.")
-				return
-			}
 			docId := docs.DocumentID(*req.Document)
 			if docId == nil {
-				writeError(w, http.StatusInternalServerError, "NOT_FOUND", "Document not found", "Not found.")
+				writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", fmt.Sprintf("No document is %q.", *req.Document))
 				return
 			}
 			req.Id = docId
 
 		}
-		err = docs.DocumentRemove(*req.Id)
+		err = docs.As(actor(r)).DocumentRemoveIfVersion(*req.Id, req.ExpectedVersion)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error removing document: %v", err), http.StatusInternalServerError)
+			writeChangeError(w, "Error removing document", err)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode("200")
+		writeQueryResult(w, docs.Tombstone(*req.Id))
 	}
 }
 
@@ -262,13 +842,7 @@ func getHandler(db *database.DB) http.HandlerFunc {
         }
 
 		var req GetRequest
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            writeError(w,
-                http.StatusBadRequest,
-                "INVALID_JSON",
-                "Invalid request body",
-                err.Error(),
-            )
+        if !decodeRequest(w, r, &req) {
             return
         }
 		fmt.Printf("Incoming get request: %v", req)
@@ -279,17 +853,11 @@ func getHandler(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		docCollec := docs.GetDocumentCollection()
-		if docCollec == nil {
-			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting documents", "Document collection not found")
-			return
-		}
-		
 		queryResults := make([]QueryResult, 0, len(req.Ids))
 		for _, reqId := range req.Ids {
-			doc := docCollec.Get(reqId)
+			doc := docs.Document(reqId)
 			if doc == nil {
-				writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting documents", "Document not found")
+				writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", fmt.Sprintf("No document %d.", reqId))
 				return
 			}
 			queryResults = append(queryResults, documentToQueryResult(doc))
@@ -300,16 +868,139 @@ func getHandler(db *database.DB) http.HandlerFunc {
 	}
 }
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+func suggestHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w,
+				http.StatusMethodNotAllowed,
+				"METHOD_NOT_ALLOWED",
+				fmt.Sprintf("Only POST method is allowed, got %s", r.Method),
+				"Use POST to retrieve suggestions",
+			)
+			return
+		}
+
+		var req SuggestRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+
+		docs, err := db.GetCollection("docs")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error getting collection", err.Error())
+			return
+		}
 
+		maxResults := req.MaxResults
+		if maxResults <= 0 {
+			maxResults = defaultSuggestResults
+		}
+		suggestions := docs.DocumentSuggest(req.Prefix, maxResults)
+
+		results := make([]SuggestResult, 0, len(suggestions))
+		for _, s := range suggestions {
+			results = append(results, SuggestResult{
+				Document:    s.Document,
+				Score:       s.Score,
+				Id:          s.ID,
+				IsPreferred: s.Preferred,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// snapshotHandler downloads every collection as a snapshot archive.
+func snapshotHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w,
+				http.StatusMethodNotAllowed,
+				"METHOD_NOT_ALLOWED",
+				fmt.Sprintf("Only GET method is allowed, got %s", r.Method),
+				"Use GET to download a snapshot",
+			)
+			return
+		}
+
+		// Buffer the archive so a failure can still be reported as JSON.
+		var archive bytes.Buffer
+		if err := db.Snapshot(&archive); err != nil {
+			writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", "Error taking snapshot", err.Error())
+			return
+		}
+
+		filename := fmt.Sprintf("cend-snapshot-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Write(archive.Bytes())
+	}
+}
+
+// maxSnapshotBytes bounds the snapshot archive /restore reads, and
+// maxSnapshotDataBytes what its entries decompress to, so that a small
+// archive cannot expand to fill memory.
+const (
+	maxSnapshotBytes     = 256 << 20
+	maxSnapshotDataBytes = 1 << 30
+)
+
+// restoreHandler restores the snapshot archive in the request body. Each
+// rename query parameter, as in ?rename=docs:docs-backup, restores a
+// collection under a new name instead of replacing the live one.
+func restoreHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w,
+				http.StatusMethodNotAllowed,
+				"METHOD_NOT_ALLOWED",
+				fmt.Sprintf("Only POST method is allowed, got %s", r.Method),
+				"Use POST with a snapshot archive as the body",
+			)
+			return
+		}
+
+		rename, err := parseRenames(r.URL.Query()["rename"])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid rename", err.Error())
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, maxSnapshotBytes)
+		names, err := db.RestoreWithOptions(body, database.RestoreOptions{Rename: rename, MaxBytes: maxSnapshotDataBytes})
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, database.ErrSnapshotTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", "Snapshot too large", err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_SNAPSHOT", "Error restoring snapshot", err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RestoreResult{Collections: names})
+	}
+}
+
+func rootHandler(w http.ResponseWriter, r *http.Request) {
 	basicInfo := map[string]string{
 		"greeting": "Welcome to Cend!",
 		"name":     "Cend API",
 		"version":  "1.0.0",
 	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(basicInfo)
+}
+
+// notFoundHandler answers requests to paths no endpoint serves.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "Endpoint not found", fmt.Sprintf("No endpoint serves %s.", r.URL.Path))
+}
+
+// methodNotAllowedHandler answers requests with a method the endpoint at
+// their path does not serve.
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path), "See the API documentation for the methods of this endpoint.")
 }
\ No newline at end of file
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cend/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestRouter returns the API over a database whose docs collection
+// holds IBM, with the ticker IBM, and Intel, with IDs 1 and 2.
+func newTestRouter(t *testing.T) http.Handler {
+	t.Helper()
+	return newTestRouterWith(t, nil, nil)
+}
+
+// newTestRouterWith returns the API of newTestRouter, authorizing requests
+// with keys and limiting them with limiter.
+func newTestRouterWith(t *testing.T, keys *apiKeys, limiter *rateLimiter) http.Handler {
+	t.Helper()
+	t.Setenv("DB_PATH", t.TempDir())
+	db := database.New("test-db")
+	db.AddCollection("docs")
+	docs, _ := db.GetCollection("docs")
+	docs.DocumentAdd("IBM")
+	docs.DocumentAdd("Intel")
+	docs.DocumentAddFields(1, &map[string]string{"ticker": "IBM"})
+	return newRouter(db, keys, limiter)
+}
+
+func serve(t *testing.T, router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s: expected a JSON response, got %q", method, path, got)
+	}
+	return rec
+}
+
+// expectError checks that rec is an ErrorResponse with the given status
+// and code.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected an error response, got %s", rec.Body)
+	}
+	if rec.Code != status || resp.Status != status || resp.Code != code {
+		t.Errorf("Expected %d %s, got %d with %+v", status, code, rec.Code, resp)
+	}
+}
+
+func expectDocument(t *testing.T, rec *httptest.ResponseRecorder, status int) QueryResult {
+	t.Helper()
+	var result QueryResult
+	if rec.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Expected a document, got %s", rec.Body)
+	}
+	return result
+}
+
+func TestEndpointsRejectMethodsAndInvalidJSON(t *testing.T) {
+	router := newTestRouter(t)
+	for _, path := range []string{
+		"/search", "/search/page", "/search/batch", "/add", "/update", "/delete", "/merge", "/split",
+		"/history", "/undo", "/undelete", "/batch", "/query", "/get", "/list", "/suggest",
+	} {
+		t.Run(path, func(t *testing.T) {
+			expectError(t, serve(t, router, http.MethodPut, path, ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+			expectError(t, serve(t, router, http.MethodPost, path, "{"), http.StatusBadRequest, "INVALID_JSON")
+		})
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/snapshot", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	expectError(t, serve(t, router, http.MethodGet, "/restore", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	expectError(t, serve(t, router, http.MethodGet, "/no-such-endpoint", ""), http.StatusNotFound, "NOT_FOUND")
+}
+
+func TestAddHandler(t *testing.T) {
+	router := newTestRouter(t)
+
+	doc := expectDocument(t, serve(t, router, http.MethodPost, "/add", `{"document": "I.B.M.", "fields": {"ticker": "IBM"}, "preferredDocuments": [1]}`), http.StatusCreated)
+	if doc.Id != 3 || doc.Document != "I.B.M." || (*doc.Fields)["ticker"] != "IBM" || len(doc.PreferredDocuments) != 1 {
+		t.Errorf("Expected the added document with its ID, got %+v", doc)
+	}
+
+	expectError(t, serve(t, router, http.MethodPost, "/add", `{"document": "IBM"}`), http.StatusConflict, "CONFLICT")
+	expectError(t, serve(t, router, http.MethodPost, "/add", `{"document": ""}`), http.StatusBadRequest, "VALIDATION_ERROR")
+	expectError(t, serve(t, router, http.MethodPost, "/add", `{"document": "Apple", "preferredDocuments": [42]}`), http.StatusNotFound, "NOT_FOUND")
+}
+
+func TestRemoveHandler(t *testing.T) {
+	router := newTestRouter(t)
+
+	expectError(t, serve(t, router, http.MethodPost, "/delete", `{"id": 42}`), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodPost, "/delete", `{"document": "Apple"}`), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodPost, "/delete", `{}`), http.StatusBadRequest, "VALIDATION_ERROR")
+	expectError(t, serve(t, router, http.MethodPost, "/delete", `{"id": 1, "expectedVersion": 1}`), http.StatusConflict, "CONFLICT")
+
+	doc := expectDocument(t, serve(t, router, http.MethodPost, "/delete", `{"document": "IBM", "expectedVersion": 2}`), http.StatusOK)
+	if doc.Id != 1 || doc.Deleted == nil {
+		t.Errorf("Expected the deleted document, got %+v", doc)
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/delete", `{"id": 1}`), http.StatusNotFound, "NOT_FOUND")
+}
+
+func TestGetAndQueryHandlers(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodPost, "/get", `{"ids": [2, 1]}`)
+	var docs []QueryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &docs); err != nil || rec.Code != http.StatusOK || len(docs) != 2 || docs[0].Document != "Intel" {
+		t.Errorf("Expected Intel and IBM, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/get", `{"ids": [1, 42]}`), http.StatusNotFound, "NOT_FOUND")
+
+	rec = serve(t, router, http.MethodPost, "/query", `{"min": 1, "max": 1}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &docs); err != nil || rec.Code != http.StatusOK || len(docs) != 1 || docs[0].Document != "IBM" {
+		t.Errorf("Expected IBM, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/query", `{"min": 0, "max": 10}`), http.StatusBadRequest, "VALIDATION_ERROR")
+}
+
+func TestUpdateHandler(t *testing.T) {
+	router := newTestRouter(t)
+
+	doc := expectDocument(t, serve(t, router, http.MethodPost, "/update", `{"id": 2, "newDocument": "Intel Corporation", "setFields": {"ticker": "INTC"}}`), http.StatusOK)
+	if doc.Document != "Intel Corporation" || (*doc.Fields)["ticker"] != "INTC" {
+		t.Errorf("Expected the updated document, got %+v", doc)
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/update", `{"id": 42, "newDocument": "Apple"}`), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, serve(t, router, http.MethodPost, "/update", `{"id": 2, "newDocument": "IBM"}`), http.StatusConflict, "CONFLICT")
+	expectError(t, serve(t, router, http.MethodPost, "/update", `{"id": 2, "newDocument": "Intel", "expectedVersion": 1}`), http.StatusConflict, "CONFLICT")
+}
+
+func TestSearchHandler(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodPost, "/search", `{"query": "ibm", "maxResults": 1}`)
+	var results []SearchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || rec.Code != http.StatusOK || len(results) != 1 || results[0].Id != 1 {
+		t.Errorf("Expected IBM, got %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serve(t, router, http.MethodPost, "/search", `{"query": "ibm", "relativeCutoff": 2}`), http.StatusBadRequest, "VALIDATION_ERROR")
+}
+
+// TestConcurrentRequests checks that documents can be read while other
+// requests change them. Run it with -race.
+func TestConcurrentRequests(t *testing.T) {
+	router := newTestRouter(t)
+	do := func(method, path, body string) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if rec.Code >= http.StatusInternalServerError {
+			t.Errorf("%s %s: expected no server error, got %d %s", method, path, rec.Code, rec.Body)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for writer := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				id := 3 + 2*i + writer
+				do(http.MethodPost, "/collections/docs/documents", fmt.Sprintf(`{"document": "Company %d-%d", "preferredDocuments": [1]}`, writer, i))
+				do(http.MethodPatch, fmt.Sprintf("/collections/docs/documents/%d", id), `{"fields": {"ticker": "CO"}, "isPreferred": true}`)
+				do(http.MethodPost, "/update", fmt.Sprintf(`{"id": %d, "setFields": {"country": "US"}}`, id))
+				do(http.MethodDelete, fmt.Sprintf("/collections/docs/documents/%d", id), "")
+				do(http.MethodPost, fmt.Sprintf("/collections/docs/documents/%d/restore", id), "")
+			}
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				do(http.MethodGet, "/collections/docs/search?query=company&collapseVariants=true", "")
+				do(http.MethodPost, "/suggest", `{"prefix": "comp"}`)
+				do(http.MethodGet, "/collections/docs/documents?pageSize=10", "")
+				do(http.MethodGet, "/collections/docs/documents?deleted=true", "")
+				do(http.MethodPost, "/get", `{"ids": [1, 2]}`)
+				do(http.MethodPost, "/query", `{"min": 1, "max": 50}`)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"cend/database"
 	"cend/database/collection/documents"
 	"cend/database/collection"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 func documentToQueryResult(doc *documents.Document) QueryResult {
@@ -13,20 +20,122 @@ func documentToQueryResult(doc *documents.Document) QueryResult {
 		f := make(map[string]string)
 		fields = &f
 	}
-	return QueryResult{
+	result := QueryResult{
 		Document: doc.String(),
 		Id: doc.ID(),
 		Fields: fields,
 		IsPreferred: doc.Preferred(),
 		PreferredDocuments: doc.PreferredDocuments(),
+		Version: doc.Version(),
+	}
+	if deleted := doc.Deleted(); !deleted.IsZero() {
+		result.Deleted = &deleted
+	}
+	return result
+}
+
+// writeQueryResult writes doc as a change left it, or 404 if another
+// request removed it since.
+func writeQueryResult(w http.ResponseWriter, doc *documents.Document) {
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", "The document was removed by another request.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documentToQueryResult(doc))
+}
+
+// actor returns who a request acts for, as recorded in collection history:
+// the name of its API key, or without one, the X-CEND-Actor header.
+func actor(r *http.Request) string {
+	if k := requestKey(r); k != nil {
+		return k.Name
+	}
+	return r.Header.Get("X-CEND-Actor")
+}
+
+// writeChangeError writes the error of a change to a document, reporting a
+// stale expected version or a document that already exists as a conflict
+// and a missing document as not found.
+func writeChangeError(w http.ResponseWriter, message string, err error) {
+	var conflict *collection.VersionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		writeError(w, http.StatusConflict, "CONFLICT", "Document was changed by someone else", err.Error())
+	case errors.Is(err, collection.ErrExists):
+		writeError(w, http.StatusConflict, "CONFLICT", "Document already exists", err.Error())
+	case errors.Is(err, collection.ErrNotFound):
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Document not found", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "INERNAL_ERROR", message, err.Error())
+	}
+}
+
+// batchOperationError describes why an operation of a batch failed.
+func batchOperationError(err error) ErrorResponse {
+	var conflict *collection.VersionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		return ErrorResponse{Status: http.StatusConflict, Message: "Document was changed by someone else", Code: "CONFLICT", Details: err.Error()}
+	case errors.Is(err, collection.ErrExists):
+		return ErrorResponse{Status: http.StatusConflict, Message: "Document already exists", Code: "CONFLICT", Details: err.Error()}
+	case errors.Is(err, collection.ErrNotFound):
+		return ErrorResponse{Status: http.StatusNotFound, Message: "Document not found", Code: "NOT_FOUND", Details: err.Error()}
+	}
+	return ErrorResponse{Status: http.StatusBadRequest, Message: "Invalid operation", Code: "INPUT_ERROR", Details: err.Error()}
+}
+
+// pathCollection returns the collection named in the path of a request,
+// writing an error if there is none.
+func pathCollection(db *database.DB, w http.ResponseWriter, r *http.Request) (*collection.Collection, bool) {
+	docs, err := db.GetCollection(mux.Vars(r)["collection"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Collection not found", err.Error())
+		return nil, false
+	}
+	return docs, true
+}
+
+// refs refers to documents by ID in a batch.
+func refs(ids []int) []collection.Ref {
+	refs := []collection.Ref{}
+	for _, id := range ids {
+		refs = append(refs, collection.Ref{ID: id})
+	}
+	return refs
+}
+
+// unwrapBatchError returns the error of the operation a batch failed at.
+func unwrapBatchError(err error) error {
+	var batchErr *collection.BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.Err
+	}
+	return err
+}
+
+// patchFields returns a copy of fields with the keys in set added or
+// overwritten and the keys in unset removed.
+func patchFields(fields *map[string]string, set map[string]string, unset []string) map[string]string {
+	patched := make(map[string]string)
+	if fields != nil {
+		maps.Copy(patched, *fields)
+	}
+	maps.Copy(patched, set)
+	for _, key := range unset {
+		delete(patched, key)
 	}
+	return patched
 }
 
 func getSearchResult(collec *collection.Collection, searchResults []collection.SearchResultScore) []SearchResult {
-	dc := collec.GetDocumentCollection()
 	results := make([]SearchResult, 0, len(searchResults))
 	for _, res := range searchResults {
-		doc := dc.Get(res.ID)
+		doc := collec.Document(res.ID)
+		if doc == nil {
+			// deleted since the search ran
+			continue
+		}
 		fields := doc.Fields()
 
 		if fields == nil {
@@ -40,6 +149,9 @@ func getSearchResult(collec *collection.Collection, searchResults []collection.S
 			Fields: fields,
 			IsPreferred: doc.Preferred(),
 			PreferredDocuments: doc.PreferredDocuments(),
+			Version: doc.Version(),
+			Explanation: res.Explanation,
+			MatchedVariant: res.MatchedVariant,
 		}
 
 		results = append(results, curSearchRes)
@@ -47,11 +159,20 @@ func getSearchResult(collec *collection.Collection, searchResults []collection.S
 	return results
 }
 
+// explainResults attaches a score explanation to each search result.
+func explainResults(collec *collection.Collection, query string, opts collection.SearchOptions, searchResults []collection.SearchResultScore) {
+	for i := range searchResults {
+		searchResults[i].Explanation = collec.ExplainResult(query, searchResults[i], opts)
+	}
+}
+
 type ErrorResponse struct {
     Status  int    `json:"status"`
     Message string `json:"message"`
     Code    string `json:"code"`
     Details string `json:"details,omitempty"`
+    // Fields lists every invalid field of a VALIDATION_ERROR.
+    Fields []FieldError `json:"fields,omitempty"`
 }
 
 func writeError(w http.ResponseWriter, status int, code string, message string, details string) {
@@ -65,4 +186,18 @@ func writeError(w http.ResponseWriter, status int, code string, message string,
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(status)
     json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// parseRenames parses "from:to" pairs naming collections to restore under
+// new names.
+func parseRenames(pairs []string) (map[string]string, error) {
+	rename := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		from, to, found := strings.Cut(pair, ":")
+		if !found || from == "" || to == "" {
+			return nil, fmt.Errorf("expected from:to, got %q", pair)
+		}
+		rename[from] = to
+	}
+	return rename, nil
+}
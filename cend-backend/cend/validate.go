@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// maxBodyBytes bounds the JSON body of a request. /restore, which reads a
+// snapshot rather than JSON, is bounded by maxSnapshotBytes instead.
+const maxBodyBytes = 1 << 20
+
+// FieldError is an invalid field of a request, named by its path in the
+// request, such as queries[2].maxResults.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validator is implemented by requests with constraints on their fields.
+type validator interface {
+	validate(v *validation)
+}
+
+// validation collects every invalid field of a request.
+type validation struct {
+	prefix string
+	errors []FieldError
+}
+
+// check records field as invalid, with the message format, unless ok.
+func (v *validation) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		v.errors = append(v.errors, FieldError{Field: v.prefix + field, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// nested validates req as the part of the request at prefix.
+func (v *validation) nested(prefix string, req validator) {
+	outer := v.prefix
+	v.prefix += prefix
+	req.validate(v)
+	v.prefix = outer
+}
+
+// valid validates req, if it is a validator, and reports whether no field
+// was found invalid. Otherwise it writes a VALIDATION_ERROR listing them.
+func (v *validation) valid(w http.ResponseWriter, req any) bool {
+	if req, ok := req.(validator); ok {
+		req.validate(v)
+	}
+	if len(v.errors) == 0 {
+		return true
+	}
+	writeValidationError(w, v.errors)
+	return false
+}
+
+func writeValidationError(w http.ResponseWriter, fields []FieldError) {
+	invalid := make([]string, len(fields))
+	for i, f := range fields {
+		invalid[i] = f.Field + ": " + f.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Status:  http.StatusBadRequest,
+		Message: "Invalid request",
+		Code:    "VALIDATION_ERROR",
+		Details: strings.Join(invalid, "; "),
+		Fields:  fields,
+	})
+}
+
+// decodeRequest decodes the JSON body of r into req and validates it. The
+// body must be at most maxBodyBytes and name only fields req has. It
+// reports whether req is valid, having written the error if it is not.
+// Every unknown field and value of the wrong type is reported, not only
+// the first.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req any) bool {
+	var raw json.RawMessage
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	err := dec.Decode(&raw)
+	if err == nil && dec.Decode(&json.RawMessage{}) != io.EOF {
+		err = errors.New("data after the JSON value")
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", "Request body too large", fmt.Sprintf("A request body must be at most %d bytes.", tooLarge.Limit))
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body", err.Error())
+		return false
+	}
+	dst := reflect.ValueOf(req).Elem()
+	if _, _, ok := objectMembers(raw); !ok || dst.Kind() != reflect.Struct {
+		if err := json.Unmarshal(raw, req); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_JSON", "Invalid request body", err.Error())
+			return false
+		}
+	}
+
+	var v validation
+	v.decode("", raw, dst)
+	if len(v.errors) > 0 {
+		writeValidationError(w, v.errors)
+		return false
+	}
+	return v.valid(w, req)
+}
+
+// unmarshaler is the type of types that decode themselves from JSON.
+var unmarshaler = reflect.TypeFor[json.Unmarshaler]()
+
+// decode decodes data into dst, the part of the request at field,
+// recording every unknown field and value of the wrong type in it rather
+// than stopping at the first. Objects and arrays of objects are decoded a
+// member at a time, so that the errors of each are found.
+func (v *validation) decode(field string, data json.RawMessage, dst reflect.Value) {
+	if dst.Kind() == reflect.Pointer && string(data) != "null" {
+		elem := reflect.New(dst.Type().Elem())
+		v.decode(field, data, elem.Elem())
+		dst.Set(elem)
+		return
+	}
+	if reflect.PointerTo(dst.Type()).Implements(unmarshaler) {
+		v.decodeValue(field, data, dst)
+		return
+	}
+	switch dst.Kind() {
+	case reflect.Struct:
+		keys, values, ok := objectMembers(data)
+		if !ok {
+			break
+		}
+		prefix := field
+		if prefix != "" {
+			prefix += "."
+		}
+		for i, key := range keys {
+			index, known := decodedField(dst.Type(), key)
+			if !known {
+				v.check(false, prefix+key, "is not a field of this request")
+				continue
+			}
+			v.decode(prefix+key, values[i], dst.FieldByIndex(index))
+		}
+		return
+	case reflect.Slice:
+		var elems []json.RawMessage
+		if dst.Type().Elem().Kind() == reflect.Uint8 || json.Unmarshal(data, &elems) != nil || elems == nil {
+			break
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			v.decode(fmt.Sprintf("%s[%d]", field, i), elem, slice.Index(i))
+		}
+		dst.Set(slice)
+		return
+	}
+	v.decodeValue(field, data, dst)
+}
+
+// decodeValue decodes data into dst as a whole, recording why it cannot as
+// the error of field.
+func (v *validation) decodeValue(field string, data json.RawMessage, dst reflect.Value) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst.Addr().Interface())
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			field += "." + typeErr.Field
+		}
+		v.check(false, field, "must be %s, not %s", jsonType(typeErr.Type.String()), typeErr.Value)
+	default:
+		v.check(false, field, "%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+}
+
+// objectMembers returns the keys and values of the JSON object data in
+// order, or false if data is not an object.
+func objectMembers(data []byte) ([]string, []json.RawMessage, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, false
+	}
+	keys, values := []string{}, []json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, false
+		}
+		keys, values = append(keys, tok.(string)), append(values, value)
+	}
+	return keys, values, true
+}
+
+// decodedField returns the index of the field of struct type t that
+// encoding/json decodes key into, preferring an exact match of its name to
+// one that differs in case, as encoding/json does.
+func decodedField(t reflect.Type, key string) ([]int, bool) {
+	var folded []int
+	for _, f := range jsonFields(t) {
+		if f.name == key {
+			return f.Index, true
+		}
+		if folded == nil && strings.EqualFold(f.name, key) {
+			folded = f.Index
+		}
+	}
+	return folded, folded != nil
+}
+
+// jsonType names the JSON type a Go type decodes from.
+func jsonType(goType string) string {
+	switch {
+	case strings.HasPrefix(goType, "int"), strings.HasPrefix(goType, "uint"):
+		return "an integer"
+	case strings.HasPrefix(goType, "float"):
+		return "a number"
+	case goType == "string":
+		return "a string"
+	case goType == "bool":
+		return "a boolean"
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	}
+	return "an object"
+}
+
+// validateFields checks the keys of a document's fields.
+func validateFields[V any](v *validation, field string, fields map[string]V) {
+	for key := range fields {
+		v.check(key != "", field, "must not have an empty key")
+	}
+}
+
+// validateIDs checks that each of ids could be a document ID.
+func validateIDs(v *validation, field string, ids []int) {
+	for i, id := range ids {
+		v.check(id >= 1, fmt.Sprintf("%s[%d]", field, i), "must be a document ID, >= 1")
+	}
+}
+
+func validatePageSize(v *validation, pageSize int) {
+	v.check(pageSize >= 0 && pageSize <= maxPageSize, "pageSize", "must be between 1 and %d, or 0 for %d", maxPageSize, defaultPageSize)
+}
+
+func (req SearchRequest) validate(v *validation) {
+	v.check(req.MaxResults >= 0, "maxResults", "must be >= 0")
+	v.check(req.MinScore >= 0, "minScore", "must be >= 0")
+	v.check(req.RelativeCutoff >= 0 && req.RelativeCutoff <= 1, "relativeCutoff", "must be between 0 and 1")
+	v.check(req.MinShouldMatch >= 0 && req.MinShouldMatch <= 1, "minShouldMatch", "must be between 0 and 1")
+	v.check(req.PreferredBoost >= 0, "preferredBoost", "must be >= 0")
+}
+
+func (req SearchPageRequest) validate(v *validation) {
+	req.SearchRequest.validate(v)
+	validatePageSize(v, req.PageSize)
+}
+
+func (req BatchSearchRequest) validate(v *validation) {
+	v.check(len(req.Queries) >= 1 && len(req.Queries) <= maxBatchQueries, "queries", "must have between 1 and %d queries", maxBatchQueries)
+	seen := make(map[string]bool)
+	for i, key := range req.keys() {
+		prefix := fmt.Sprintf("queries[%d].", i)
+		v.check(!seen[key], prefix+"id", "%q is the key of an earlier query; give each query a distinct id", key)
+		seen[key] = true
+		v.nested(prefix, req.Queries[i])
+	}
+}
+
+func (req ListRequest) validate(v *validation) {
+	validatePageSize(v, req.PageSize)
+}
+
+func (req QueryRequest) validate(v *validation) {
+	v.check(req.Min >= 1, "min", "must be >= 1")
+	v.check(req.Max >= req.Min, "max", "must be >= min")
+}
+
+func (req GetRequest) validate(v *validation) {
+	validateIDs(v, "ids", req.Ids)
+}
+
+func (req AddRequest) validate(v *validation) {
+	v.check(req.Document != "", "document", "must not be empty")
+	if req.Fields != nil {
+		validateFields(v, "fields", *req.Fields)
+	}
+	validateIDs(v, "preferredDocuments", req.PreferredDocuments)
+}
+
+func (req UpdateRequest) validate(v *validation) {
+	v.check(req.Id != nil || req.Document != nil, "id", "must be given, or document")
+	v.check(req.Id == nil || *req.Id >= 1, "id", "must be a document ID, >= 1")
+	v.check(req.Fields == nil || (req.SetFields == nil && req.UnsetFields == nil), "fields", "cannot be combined with setFields or unsetFields")
+	if req.Fields != nil {
+		validateFields(v, "fields", *req.Fields)
+	}
+	validateFields(v, "setFields", req.SetFields)
+	v.check(req.ExpectedVersion >= 0, "expectedVersion", "must be >= 0")
+}
+
+func (req DeleteRequest) validate(v *validation) {
+	v.check(req.Id != nil || req.Document != nil, "id", "must be given, or document")
+	v.check(req.Id == nil || *req.Id >= 1, "id", "must be a document ID, >= 1")
+	v.check(req.ExpectedVersion >= 0, "expectedVersion", "must be >= 0")
+}
+
+func (req MergeRequest) validate(v *validation) {
+	v.check(req.Source >= 1, "source", "must be a document ID, >= 1")
+	v.check(req.Target >= 1, "target", "must be a document ID, >= 1")
+	v.check(req.Source != req.Target, "target", "must differ from source")
+	v.check(req.ExpectedSourceVersion >= 0, "expectedSourceVersion", "must be >= 0")
+	v.check(req.ExpectedTargetVersion >= 0, "expectedTargetVersion", "must be >= 0")
+}
+
+func (req SplitRequest) validate(v *validation) {
+	v.check(req.Source >= 1, "source", "must be a document ID, >= 1")
+	v.check(req.Document != "", "document", "must not be empty")
+	validateIDs(v, "variants", req.Variants)
+	v.check(req.ExpectedVersion >= 0, "expectedVersion", "must be >= 0")
+}
+
+func (req HistoryRequest) validate(v *validation) {
+	v.check(req.Id == nil || *req.Id >= 1, "id", "must be a document ID, >= 1")
+	v.check(req.After >= 0, "after", "must be >= 0")
+	validatePageSize(v, req.PageSize)
+}
+
+func (req BatchRequest) validate(v *validation) {
+	v.check(len(req.Operations) >= 1 && len(req.Operations) <= maxBatchOperations, "operations", "must have between 1 and %d operations", maxBatchOperations)
+}
+
+func (req UndeleteRequest) validate(v *validation) {
+	v.check(req.Id >= 1, "id", "must be a document ID, >= 1")
+	v.check(req.ExpectedVersion >= 0, "expectedVersion", "must be >= 0")
+}
+
+func (req UndoRequest) validate(v *validation) {
+	v.check(req.Seq >= 1, "seq", "must be a change number, >= 1")
+}
+
+func (req SuggestRequest) validate(v *validation) {
+	v.check(req.MaxResults >= 0, "maxResults", "must be >= 0")
+}
+
+func (patch DocumentPatch) validate(v *validation) {
+	v.check(patch.Document == nil || *patch.Document != "", "document", "must not be empty; leave it out to keep the text")
+	validateFields(v, "fields", patch.Fields)
+	if patch.PreferredDocuments != nil {
+		validateIDs(v, "preferredDocuments", *patch.PreferredDocuments)
+	}
+}
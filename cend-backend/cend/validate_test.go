@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// expectInvalidFields checks that rec is a VALIDATION_ERROR naming exactly
+// the given fields.
+func expectInvalidFields(t *testing.T, rec *httptest.ResponseRecorder, fields ...string) {
+	t.Helper()
+	expectError(t, rec, http.StatusBadRequest, "VALIDATION_ERROR")
+	var resp ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	got := []string{}
+	for _, f := range resp.Fields {
+		got = append(got, f.Field)
+	}
+	if !slices.Equal(got, fields) {
+		t.Errorf("Expected invalid fields %v, got %v", fields, got)
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	router := newTestRouter(t)
+
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search", `{"query": "ibm", "maxResult": 1}`), "maxResult")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search", `{"query": 42}`), "query")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search/page", `{"query": "ibm", "pageSize": "10"}`), "pageSize")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search/page", `{"query": 42, "maxResult": 1, "pageSize": "10"}`), "query", "maxResult", "pageSize")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search/batch", `{"queries": [{"query": 1}, {"query": "ibm", "limit": 5}], "workers": 2}`),
+		"queries[0].query", "queries[1].limit", "workers")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/batch", `{"operations": [{"op": "add", "document": "IBM", "preferredDocuments": ["0"]}]}`),
+		"operations[0].preferredDocuments[0]")
+	expectError(t, serve(t, router, http.MethodPost, "/search", `{"query": "ibm"} {}`), http.StatusBadRequest, "INVALID_JSON")
+	expectError(t, serve(t, router, http.MethodPost, "/search", ""), http.StatusBadRequest, "INVALID_JSON")
+
+	large := `{"query": "` + strings.Repeat("ibm ", maxBodyBytes/4) + `"}`
+	expectError(t, serve(t, router, http.MethodPost, "/search", large), http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE")
+}
+
+func TestValidationListsEveryField(t *testing.T) {
+	router := newTestRouter(t)
+
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search", `{"query": "ibm", "maxResults": -1, "minScore": -1, "relativeCutoff": 2}`),
+		"maxResults", "minScore", "relativeCutoff")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/add", `{"document": "", "fields": {"": "x"}, "preferredDocuments": [1, 0]}`),
+		"document", "fields", "preferredDocuments[1]")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/update", `{"id": 1, "fields": {}, "setFields": {}, "expectedVersion": -1}`),
+		"fields", "expectedVersion")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/merge", `{"source": 1, "target": 1}`), "target")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/search/batch", `{"queries": [{"query": "ibm"}, {"query": "ibm", "maxResults": -1}]}`),
+		"queries[1].id", "queries[1].maxResults")
+	expectInvalidFields(t, serve(t, router, http.MethodPost, "/batch", `{"operations": []}`), "operations")
+
+	expectInvalidFields(t, serve(t, router, http.MethodGet, "/collections/docs/search?query=ibm&explain=maybe&pageSize=-1&minScore=-1", ""),
+		"explain", "minScore", "pageSize")
+	expectInvalidFields(t, serve(t, router, http.MethodPatch, "/collections/docs/documents/1", `{"document": "", "preferredDocuments": [-2]}`),
+		"document", "preferredDocuments[0]")
+}
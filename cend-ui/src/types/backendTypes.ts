@@ -9,6 +9,8 @@ export type TermEntry = {
     fields: Record<string, string> | null;
     isPreferred: boolean;
     preferredDocuments: number[];
+    version: number;
+    deleted?: string;
 };
 
 export type NewTermEntry = {